@@ -0,0 +1,218 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrCircuitOpen is returned when connecting to a BMC is not even attempted,
+// because its previous connections failed.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// States of a circuit, as exposed by Breaker.Circuits.
+const (
+	// CircuitClosed means connections are attempted normally.
+	CircuitClosed = "closed"
+	// CircuitOpen means connections fail fast with ErrCircuitOpen.
+	CircuitOpen = "open"
+	// CircuitHalfOpen means the cooldown is over and a single trial
+	// connection is allowed, which closes the circuit if it succeeds.
+	CircuitHalfOpen = "half-open"
+)
+
+// circuitStateValues are the values of metricCircuitState.
+var circuitStateValues = map[string]float64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+var metricCircuitState = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "reboot_bmc_circuit_state",
+		Help: "State of the circuit breaker of each BMC (0: closed, 1: half-open, 2: open)",
+	},
+	[]string{
+		"address",
+	},
+)
+
+// Circuit is the state of the circuit breaker of a BMC.
+type Circuit struct {
+	Address string `json:"address"`
+	// State is CircuitClosed, CircuitOpen or CircuitHalfOpen.
+	State string `json:"state"`
+	// Failures is the number of consecutive failed connections.
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+	// OpenUntil is when an open circuit becomes half-open.
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+
+	// trial is whether a trial connection is in progress.
+	trial bool
+}
+
+// Breaker is a Connector that stops connecting to BMCs that are down. After
+// a number of consecutive failed connections to a BMC, its circuit opens:
+// connections fail fast with ErrCircuitOpen until the cooldown is over.
+// Then a single trial connection is attempted, which either closes the
+// circuit or opens it again.
+//
+// Only failures that suggest the BMC is unreachable count: timeouts, DNS
+// failures, refused or reset connections. Host connections are never
+// affected.
+type Breaker struct {
+	connector Connector
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+	// circuits has the BMCs whose last connection failed, by address.
+	circuits map[string]*Circuit
+}
+
+// NewBreaker returns a Breaker opening circuits after threshold consecutive
+// failures for cooldown, and delegating connections to c. A threshold of
+// zero disables it.
+func NewBreaker(c Connector, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		connector: c,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		circuits:  make(map[string]*Circuit),
+	}
+}
+
+// NewConnection creates a connection, unless config.Hostname is a BMC whose
+// circuit is open.
+func (b *Breaker) NewConnection(config *ConnectionConfig) (Connection, error) {
+	return b.NewConnectionContext(context.Background(), config)
+}
+
+// NewConnectionContext is like NewConnection, but gives up when ctx is done.
+func (b *Breaker) NewConnectionContext(ctx context.Context,
+	config *ConnectionConfig) (Connection, error) {
+
+	if config.ConnType != BMCConnection || b.threshold <= 0 {
+		return NewConnectionContext(ctx, b.connector, config)
+	}
+
+	if err := b.allow(config.Hostname); err != nil {
+		return nil, err
+	}
+	conn, err := NewConnectionContext(ctx, b.connector, config)
+	b.record(config.Hostname, err)
+	return conn, err
+}
+
+// allow returns an error wrapping ErrCircuitOpen if no connection must be
+// attempted to address.
+func (b *Breaker) allow(address string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[address]
+	if !ok {
+		return nil
+	}
+	switch c.State {
+	case CircuitOpen:
+		if b.now().Before(*c.OpenUntil) {
+			return fmt.Errorf("%w: %s failed %d times, last with: %s (until %s)",
+				ErrCircuitOpen, address, c.Failures, c.LastError,
+				c.OpenUntil.UTC().Format(time.RFC3339))
+		}
+		b.setState(c, CircuitHalfOpen)
+		c.OpenUntil = nil
+		c.trial = true
+	case CircuitHalfOpen:
+		if c.trial {
+			return fmt.Errorf("%w: %s is being tried again", ErrCircuitOpen, address)
+		}
+		c.trial = true
+	}
+	return nil
+}
+
+// record updates the circuit of address with the outcome of a connection.
+func (b *Breaker) record(address string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[address]
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// Nothing has been learned about the BMC.
+		if ok {
+			c.trial = false
+		}
+		return
+	case !IsTransientConnectError(err) && !errors.Is(err, ErrDNS):
+		// The BMC replied, even if only to refuse the credentials.
+		if ok {
+			b.setState(c, CircuitClosed)
+			delete(b.circuits, address)
+		}
+		return
+	case !ok:
+		c = &Circuit{Address: address, State: CircuitClosed}
+		b.circuits[address] = c
+	}
+
+	c.Failures++
+	c.LastError = err.Error()
+	c.trial = false
+	if c.State == CircuitHalfOpen || c.Failures >= b.threshold {
+		if c.State != CircuitOpen {
+			log.Printf("Opening circuit breaker for %s after %d failures: %v",
+				address, c.Failures, err)
+		}
+		until := b.now().Add(b.cooldown)
+		c.OpenUntil = &until
+		b.setState(c, CircuitOpen)
+	}
+}
+
+// setState changes the state of c and updates metricCircuitState.
+func (b *Breaker) setState(c *Circuit, state string) {
+	c.State = state
+	metricCircuitState.WithLabelValues(c.Address).Set(circuitStateValues[state])
+}
+
+// Circuits returns the circuits that are not closed, or that are closed but
+// had failures since the last successful connection, sorted by address.
+func (b *Breaker) Circuits() []Circuit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuits := make([]Circuit, 0, len(b.circuits))
+	for _, c := range b.circuits {
+		circuits = append(circuits, *c)
+	}
+	sort.Slice(circuits, func(i, j int) bool {
+		return circuits[i].Address < circuits[j].Address
+	})
+	return circuits
+}
+
+// ServeHTTP handles GET requests, writing the Circuits as JSON.
+func (b *Breaker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.Circuits())
+}
@@ -0,0 +1,154 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failingConnector fails every connection with err, and counts them.
+type failingConnector struct {
+	err   error
+	calls int
+}
+
+func (c *failingConnector) NewConnection(*ConnectionConfig) (Connection, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &blockingConnection{output: "ok"}, nil
+}
+
+func TestBreaker(t *testing.T) {
+	c := &failingConnector{err: fmt.Errorf("%w: dial", ErrConnectionRefused)}
+	b := NewBreaker(c, 3, time.Minute)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	bmc := &ConnectionConfig{Hostname: "bmc.example.org", ConnType: BMCConnection}
+	connect := func() error {
+		_, err := b.NewConnection(bmc)
+		return err
+	}
+
+	// The circuit opens after three failures.
+	for i := 0; i < 3; i++ {
+		if err := connect(); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("NewConnection() #%d - unexpected ErrCircuitOpen", i)
+		}
+	}
+	if err := connect(); !errors.Is(err, ErrCircuitOpen) || c.calls != 3 {
+		t.Fatalf("NewConnection() = %v after %d calls, want ErrCircuitOpen", err, c.calls)
+	}
+	circuits := b.Circuits()
+	if len(circuits) != 1 || circuits[0].State != CircuitOpen ||
+		circuits[0].Failures != 3 || circuits[0].OpenUntil == nil {
+		t.Errorf("unexpected circuits: %+v", circuits)
+	}
+
+	// Other BMCs and hosts are not affected.
+	if _, err := b.NewConnection(&ConnectionConfig{
+		Hostname: "other.example.org", ConnType: BMCConnection,
+	}); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("NewConnection() - unexpected ErrCircuitOpen for another BMC")
+	}
+	if _, err := b.NewConnection(&ConnectionConfig{
+		Hostname: "bmc.example.org", ConnType: HostConnection,
+	}); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("NewConnection() - unexpected ErrCircuitOpen for a host")
+	}
+
+	// After the cooldown, a failed trial opens the circuit again.
+	now = now.Add(time.Minute)
+	calls := c.calls
+	if err := connect(); errors.Is(err, ErrCircuitOpen) || c.calls != calls+1 {
+		t.Errorf("NewConnection() = %v, want a trial connection", err)
+	}
+	if err := connect(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("NewConnection() = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful trial closes it.
+	now = now.Add(time.Minute)
+	c.err = nil
+	if err := connect(); err != nil {
+		t.Errorf("NewConnection() unexpected error: %v", err)
+	}
+	for _, circuit := range b.Circuits() {
+		if circuit.Address == "bmc.example.org" {
+			t.Errorf("unexpected circuit: %+v", circuit)
+		}
+	}
+
+	// Failures that don't mean the BMC is down don't count.
+	c.err = fmt.Errorf("%w: password", ErrAuthRejected)
+	for i := 0; i < 5; i++ {
+		if err := connect(); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("NewConnection() - unexpected ErrCircuitOpen")
+		}
+	}
+}
+
+func TestBreaker_halfOpen(t *testing.T) {
+	c := &failingConnector{err: classify(timeoutError{})}
+	b := NewBreaker(c, 1, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	if err := b.allow("bmc"); err != nil {
+		t.Fatalf("allow() unexpected error: %v", err)
+	}
+	b.record("bmc", c.err)
+	now = now.Add(time.Minute)
+
+	// A single trial is allowed at a time.
+	if err := b.allow("bmc"); err != nil {
+		t.Fatalf("allow() unexpected error: %v", err)
+	}
+	if err := b.allow("bmc"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("allow() = %v, want ErrCircuitOpen", err)
+	}
+	// A canceled trial allows another one.
+	b.record("bmc", context.Canceled)
+	if err := b.allow("bmc"); err != nil {
+		t.Errorf("allow() unexpected error: %v", err)
+	}
+}
+
+func TestBreaker_disabled(t *testing.T) {
+	c := &failingConnector{err: classify(timeoutError{})}
+	b := NewBreaker(c, 0, time.Minute)
+	bmc := &ConnectionConfig{Hostname: "bmc", ConnType: BMCConnection}
+	for i := 0; i < 10; i++ {
+		if _, err := b.NewConnection(bmc); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("NewConnection() - unexpected ErrCircuitOpen")
+		}
+	}
+}
+
+func TestBreaker_ServeHTTP(t *testing.T) {
+	b := NewBreaker(&failingConnector{}, 1, time.Minute)
+	b.record("bmc", fmt.Errorf("%w: no such host", ErrDNS))
+
+	rr := httptest.NewRecorder()
+	b.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/debug/breakers", nil))
+	var circuits []Circuit
+	if err := json.Unmarshal(rr.Body.Bytes(), &circuits); err != nil {
+		t.Fatalf("cannot unmarshal circuits: %v", err)
+	}
+	if len(circuits) != 1 || circuits[0].Address != "bmc" || circuits[0].State != CircuitOpen {
+		t.Errorf("unexpected circuits: %+v", circuits)
+	}
+
+	rr = httptest.NewRecorder()
+	b.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/debug/breakers", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
@@ -1,11 +1,13 @@
 package connector
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"path/filepath"
 	"time"
 
@@ -34,8 +36,15 @@ type ConnectionConfig struct {
 	Password       string
 	PrivateKeyFile string
 
+	// Model is the BMC's model as stored in the credentials, if known.
+	Model string
+
 	ConnType ConnType
 	Timeout  time.Duration
+
+	// HostKeyCallback verifies the remote host's key. If nil, the
+	// connector's default callback is used.
+	HostKeyCallback ssh.HostKeyCallback
 }
 
 // Connector is a provider for Connections.
@@ -44,10 +53,22 @@ type Connector interface {
 }
 
 type sshConnector struct {
-	dialer dialer
+	dialer          dialer
+	hostKeyCallback ssh.HostKeyCallback
+
+	// commands are the commands run on BMCs. If nil, DRACCommands are used.
+	commands *Commands
 }
 
 func (s *sshConnector) NewConnection(config *ConnectionConfig) (Connection, error) {
+	return s.NewConnectionContext(context.Background(), config)
+}
+
+// NewConnectionContext connects to config.Hostname, giving up when ctx is
+// done.
+func (s *sshConnector) NewConnectionContext(ctx context.Context,
+	config *ConnectionConfig) (Connection, error) {
+
 	var authMethods []ssh.AuthMethod
 	privateBytes, err := ioutil.ReadFile(filepath.Clean(config.PrivateKeyFile))
 
@@ -90,32 +111,80 @@ func (s *sshConnector) NewConnection(config *ConnectionConfig) (Connection, erro
 	})
 	authMethods = append(authMethods, keyboardInteractiveAuth)
 
-	// TODO: find out how to enable host key verification for M-Lab hosts.
+	hostKeyCallback := config.HostKeyCallback
+	if hostKeyCallback == nil {
+		hostKeyCallback = s.hostKeyCallback
+	}
+	if hostKeyCallback == nil {
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	}
+
+	// The error returned by the host key callback is kept so that it can be
+	// returned as-is, since the ssh package does not guarantee that
+	// it's preserved when wrapped in the handshake error.
+	var hostKeyErr error
 	clientConfig := &ssh.ClientConfig{
-		User:            config.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         config.Timeout,
+		User: config.Username,
+		Auth: authMethods,
+		HostKeyCallback: func(hostname string, remote net.Addr,
+			key ssh.PublicKey) error {
+			hostKeyErr = hostKeyCallback(hostname, remote, key)
+			return hostKeyErr
+		},
+		Timeout: config.Timeout,
 	}
 
-	cl, err := s.dialer.Dial("tcp",
-		fmt.Sprintf("%s:%d", config.Hostname, config.Port), clientConfig)
+	addr := fmt.Sprintf("%s:%d", config.Hostname, config.Port)
+	var cl client
+	if d, ok := s.dialer.(contextDialer); ok {
+		cl, err = d.DialContext(ctx, "tcp", addr, clientConfig)
+	} else {
+		cl, err = s.dialer.Dial("tcp", addr, clientConfig)
+	}
 
 	if err != nil {
-		return nil, err
+		if hostKeyErr != nil {
+			return nil, hostKeyErr
+		}
+		if ctx.Err() != nil {
+			return nil, contextError(ctx.Err())
+		}
+		return nil, classify(err)
+	}
+
+	commands := s.commands
+	if commands == nil {
+		commands = &DRACCommands
 	}
 
 	return &sshConnection{
-		config: config,
-		client: cl,
+		config:   config,
+		client:   cl,
+		commands: commands,
 	}, nil
 }
 
 // NewConnector returns a new Connector based on a default dialer
 // implementation.
 func NewConnector() Connector {
+	return NewConnectorWithHostKeyCallback(nil)
+}
+
+// NewConnectorWithHostKeyCallback returns a new Connector verifying host
+// keys with the provided callback, unless a different one is specified in
+// the ConnectionConfig. If the callback is nil, host keys are not verified.
+func NewConnectorWithHostKeyCallback(cb ssh.HostKeyCallback) Connector {
+	return NewSSHConnector(DRACCommands, cb)
+}
+
+// NewSSHConnector returns a new Connector running the provided commands on
+// BMCs via SSH. Host keys are verified as in
+// NewConnectorWithHostKeyCallback.
+func NewSSHConnector(commands Commands, cb ssh.HostKeyCallback) Connector {
 	return &sshConnector{
-		dialer: &sshDialer{},
+		dialer:          &sshDialer{},
+		hostKeyCallback: cb,
+		commands:        &commands,
 	}
 }
 
@@ -123,29 +192,89 @@ func NewConnector() Connector {
 type Connection interface {
 	ExecDRACShell(string) (string, error)
 	Reboot() (string, error)
+
+	// PowerOn, PowerOff, HardReset and GracefulShutdown control the power
+	// of the node managed by a BMC.
+	PowerOn() (string, error)
+	PowerOff() (string, error)
+	HardReset() (string, error)
+	GracefulShutdown() (string, error)
+	// PowerStatus returns either PowerStatusOn or PowerStatusOff.
+	PowerStatus() (string, error)
+
 	Close() error
 }
 
+// Names of the Connection methods that change the state of a node, as
+// passed to Describer.Describe.
+const (
+	MethodReboot           = "Reboot"
+	MethodPowerOn          = "PowerOn"
+	MethodPowerOff         = "PowerOff"
+	MethodHardReset        = "HardReset"
+	MethodGracefulShutdown = "GracefulShutdown"
+)
+
+// Describer is implemented by Connections that can tell what one of their
+// methods would do, e.g. which command it would run, without running it.
+// It's used for dry runs.
+type Describer interface {
+	Describe(method string) string
+}
+
 type sshConnection struct {
-	config *ConnectionConfig
-	client client
+	config   *ConnectionConfig
+	client   client
+	commands *Commands
+}
+
+// closeOnDone closes session and the connection as soon as ctx is done,
+// which aborts any command running over them. The returned function must be
+// called once the command is over, and reports whether it has been aborted.
+func (c *sshConnection) closeOnDone(ctx context.Context, session session) func() bool {
+	return closeOnDone(ctx, closerFunc(func() error {
+		session.Close()
+		return c.client.Close()
+	}))
 }
 
 // exec runs a command over the connection. It's meant to be used internally
-// inside wrappers such as Reboot().
+// inside wrappers such as Reboot(). If the command fails, the error is a
+// *CommandError.
 func (c *sshConnection) exec(cmd string) (string, error) {
+	return c.execContext(context.Background(), cmd)
+}
+
+// execContext is like exec, but aborts the command when ctx is done.
+func (c *sshConnection) execContext(ctx context.Context, cmd string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", contextError(err)
+	}
 	session, err := c.client.NewSession()
 	if err != nil {
-		return "", err
+		return "", &classifiedError{kind: ErrSession, err: err}
 	}
 	defer session.Close()
 
+	stop := c.closeOnDone(ctx, session)
 	output, err := session.CombinedOutput(cmd)
+	if stop() {
+		log.Printf("Command \"%v\" aborted: %v", cmd, ctx.Err())
+		return string(output), contextError(ctx.Err())
+	}
+	if err == nil {
+		err = racadmError(cmd, string(output))
+	}
 	if err != nil {
 		log.Printf("Error executing command \"%v\": %v", cmd, err)
+		return string(output), &CommandError{
+			Command: cmd,
+			Output:  string(output),
+			Err:     err,
+		}
 	}
 
-	return string(output), err
+	return string(output), nil
 }
 
 // ExecDRACShell runs a command in shell mode on a DRAC's SSH server.
@@ -155,15 +284,35 @@ func (c *sshConnection) exec(cmd string) (string, error) {
 // indefinitely. However, the exit code is sent after the session is closed,
 // which can be forced 1. with stdin.Close() 2. by writing "exit" on stdin.
 //
-// To know if the command execution has succeeded, the client of this API
-// *must* check stdout/stderr.
+// To know if the command execution has succeeded, stdout/stderr are checked
+// with CheckRACADMOutput. If they report a failure, the error is a
+// *CommandError wrapping a *RACADMError. BMCs whose Commands don't
+// understand racadm return ErrNotSupported.
 func (c *sshConnection) ExecDRACShell(cmd string) (string, error) {
+	return c.ExecDRACShellContext(context.Background(), cmd)
+}
+
+// ExecDRACShellContext is like ExecDRACShell, but closes the session and
+// the connection when ctx is done, since a hanging session.Wait() can't be
+// interrupted otherwise.
+func (c *sshConnection) ExecDRACShellContext(ctx context.Context,
+	cmd string) (string, error) {
+
+	if !c.commands.RACADM {
+		return "", fmt.Errorf("%w: racadm commands on this BMC", ErrNotSupported)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", contextError(err)
+	}
 	session, err := c.client.NewSession()
 	if err != nil {
-		return "", err
+		return "", &classifiedError{kind: ErrSession, err: err}
 	}
 	defer session.Close()
 
+	stop := c.closeOnDone(ctx, session)
+	defer stop()
+
 	stdin, err := session.StdinPipe()
 	if err != nil {
 		return "", err
@@ -200,16 +349,34 @@ func (c *sshConnection) ExecDRACShell(cmd string) (string, error) {
 
 	readers := io.MultiReader(stdout, stderr)
 	out, err := ioutil.ReadAll(readers)
+	if stop() {
+		log.Printf("Command \"%v\" aborted: %v", cmd, ctx.Err())
+		return string(out), contextError(ctx.Err())
+	}
 	if err != nil {
 		return "", err
 	}
 
+	if err = CheckRACADMOutput(string(out)); err != nil {
+		log.Printf("Error executing command \"%v\": %v", cmd, err)
+		return string(out), &CommandError{
+			Command: cmd,
+			Output:  string(out),
+			Err:     err,
+		}
+	}
 	return string(out), nil
 }
 
 // Reboot reboots the node via this Connection. The method to perform the
 // reboot is chosen depending on sshConnection.ConnType.
 func (c *sshConnection) Reboot() (string, error) {
+	return c.RebootContext(context.Background())
+}
+
+// RebootContext is like Reboot, but closes the session and the connection
+// when ctx is done.
+func (c *sshConnection) RebootContext(ctx context.Context) (string, error) {
 	var output string
 	var err error
 
@@ -219,12 +386,12 @@ func (c *sshConnection) Reboot() (string, error) {
 		// automatically trigger a "systemctl reboot" command.
 		// To actually start an SSH session (and thus trigger a reboot) a
 		// command must be executed.
-		output, err = c.exec("")
+		output, err = c.execContext(ctx, "")
 		if err != nil {
 			return "", err
 		}
 	} else if c.config.ConnType == BMCConnection {
-		output, err = c.exec("racadm serveraction powercycle")
+		output, err = c.execContext(ctx, c.commands.Reboot)
 		if err != nil {
 			return "", err
 		}
@@ -234,6 +401,104 @@ func (c *sshConnection) Reboot() (string, error) {
 	return output, nil
 }
 
+// bmcExec runs a command on a BMC, aborting it when ctx is done. Host
+// connections are not supported.
+func (c *sshConnection) bmcExec(ctx context.Context, cmd string) (string, error) {
+	if c.config.ConnType != BMCConnection {
+		return "", fmt.Errorf("%w: power control over a host connection",
+			ErrNotSupported)
+	}
+	return c.execContext(ctx, cmd)
+}
+
+// PowerOn powers on the node.
+func (c *sshConnection) PowerOn() (string, error) {
+	return c.PowerOnContext(context.Background())
+}
+
+// PowerOnContext is like PowerOn, but closes the session and the connection
+// when ctx is done.
+func (c *sshConnection) PowerOnContext(ctx context.Context) (string, error) {
+	return c.bmcExec(ctx, c.commands.PowerOn)
+}
+
+// PowerOff immediately powers off the node.
+func (c *sshConnection) PowerOff() (string, error) {
+	return c.PowerOffContext(context.Background())
+}
+
+// PowerOffContext is like PowerOff, but closes the session and the
+// connection when ctx is done.
+func (c *sshConnection) PowerOffContext(ctx context.Context) (string, error) {
+	return c.bmcExec(ctx, c.commands.PowerOff)
+}
+
+// HardReset resets the node without powering it off.
+func (c *sshConnection) HardReset() (string, error) {
+	return c.HardResetContext(context.Background())
+}
+
+// HardResetContext is like HardReset, but closes the session and the
+// connection when ctx is done.
+func (c *sshConnection) HardResetContext(ctx context.Context) (string, error) {
+	return c.bmcExec(ctx, c.commands.HardReset)
+}
+
+// GracefulShutdown asks the node's OS to shut down.
+func (c *sshConnection) GracefulShutdown() (string, error) {
+	return c.GracefulShutdownContext(context.Background())
+}
+
+// GracefulShutdownContext is like GracefulShutdown, but closes the session
+// and the connection when ctx is done.
+func (c *sshConnection) GracefulShutdownContext(ctx context.Context) (string, error) {
+	return c.bmcExec(ctx, c.commands.GracefulShutdown)
+}
+
+// PowerStatus returns the node's power status, parsed from the output of
+// the BMC's power status command.
+func (c *sshConnection) PowerStatus() (string, error) {
+	return c.PowerStatusContext(context.Background())
+}
+
+// PowerStatusContext is like PowerStatus, but closes the session and the
+// connection when ctx is done.
+func (c *sshConnection) PowerStatusContext(ctx context.Context) (string, error) {
+	output, err := c.bmcExec(ctx, c.commands.PowerStatus)
+	if err != nil {
+		return "", err
+	}
+	return parsePowerStatus(output)
+}
+
+// Describe returns the command that method would run over the connection.
+func (c *sshConnection) Describe(method string) string {
+	if c.config.ConnType == HostConnection {
+		if method == MethodReboot {
+			return fmt.Sprintf("login as %s on %s:%d (systemctl reboot)",
+				c.config.Username, c.config.Hostname, c.config.Port)
+		}
+		return method + " (not supported over a host connection)"
+	}
+
+	var cmd string
+	switch method {
+	case MethodReboot:
+		cmd = c.commands.Reboot
+	case MethodPowerOn:
+		cmd = c.commands.PowerOn
+	case MethodPowerOff:
+		cmd = c.commands.PowerOff
+	case MethodHardReset:
+		cmd = c.commands.HardReset
+	case MethodGracefulShutdown:
+		cmd = c.commands.GracefulShutdown
+	default:
+		return method
+	}
+	return fmt.Sprintf("%s on %s:%d", cmd, c.config.Hostname, c.config.Port)
+}
+
 func (c *sshConnection) Close() error {
 	err := c.client.Close()
 	if err != nil {
@@ -13,6 +13,7 @@ import (
 
 type mockDialer struct {
 	mustFail bool
+	hostKey  ssh.PublicKey
 }
 
 type mockClient struct {
@@ -21,6 +22,10 @@ type mockClient struct {
 
 type mockSession struct {
 	messages map[string]string
+	// failing commands return their message as output, and an error.
+	failing map[string]bool
+	// shellOutput is the output of commands run in shell mode.
+	shellOutput string
 }
 
 // Dial is a fake implementation returning an empty ssh.Client
@@ -31,6 +36,13 @@ func (d *mockDialer) Dial(network, addr string,
 		return nil, errors.New("method Dial() failed")
 	}
 
+	if d.hostKey != nil {
+		err := config.HostKeyCallback(addr, nil, d.hostKey)
+		if err != nil {
+			return nil, fmt.Errorf("ssh: handshake failed: %v", err)
+		}
+	}
+
 	return mc, nil
 }
 
@@ -52,6 +64,9 @@ func (c *mockClient) Close() error {
 // CombinedOutput returns pre-made responses contained in a map.
 func (session *mockSession) CombinedOutput(cmd string) ([]byte, error) {
 	if val, ok := session.messages[cmd]; ok {
+		if session.failing[cmd] {
+			return []byte(val), errors.New("Process exited with status 1")
+		}
 		return []byte(val), nil
 	}
 
@@ -79,6 +94,9 @@ func (session *mockSession) StdinPipe() (io.WriteCloser, error) {
 }
 
 func (session *mockSession) StdoutPipe() (io.Reader, error) {
+	if session.shellOutput != "" {
+		return strings.NewReader(session.shellOutput), nil
+	}
 	return strings.NewReader("test output"), nil
 }
 
@@ -131,11 +149,48 @@ func Test_sshConnector_NewConnection(t *testing.T) {
 	md.mustFail = false
 }
 
+func Test_sshConnector_NewConnection_hostKey(t *testing.T) {
+	store, err := NewHostKeyStore("", HostKeyStrict)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore() returned err: %v", err)
+	}
+	store.Pin("testhost", newTestKey(t))
+
+	connector := &sshConnector{
+		dialer:          md,
+		hostKeyCallback: store.HostKeyCallback(),
+	}
+	config := &ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: BMCConnection,
+	}
+
+	// A mismatching host key should be reported as such, even though the
+	// dialer doesn't wrap the callback's error.
+	md.hostKey = newTestKey(t)
+	_, err = connector.NewConnection(config)
+	if !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("NewConnection() - expected ErrHostKeyMismatch, got %v", err)
+	}
+
+	// The callback in ConnectionConfig takes precedence.
+	config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	_, err = connector.NewConnection(config)
+	if err != nil {
+		t.Errorf("NewConnection() - unexpected error: %v", err)
+	}
+	md.hostKey = nil
+}
+
 func TestNewConnector(t *testing.T) {
 	// Just test that a default Connector is created
 	if NewConnector() == nil {
 		t.Errorf("NewConnector() returned nil.")
 	}
+	if NewConnectorWithHostKeyCallback(ssh.InsecureIgnoreHostKey()) == nil {
+		t.Errorf("NewConnectorWithHostKeyCallback() returned nil.")
+	}
 }
 
 func Test_sshConnection_Reboot(t *testing.T) {
@@ -279,4 +334,129 @@ func Test_sshConnection_ExecDRACShell(t *testing.T) {
 	if err != nil {
 		t.Errorf("ExecDRACShell() returned error: %v", err)
 	}
+
+	// Failures are detected from the output.
+	ms.shellOutput = "/admin1-> racadm getversion\nCOMMAND PROCESSING FAILED\n"
+	defer func() { ms.shellOutput = "" }()
+	out, err := bmcConn.ExecDRACShell("racadm getversion")
+	var racErr *RACADMError
+	if !errors.As(err, &racErr) || racErr.Message != "COMMAND PROCESSING FAILED" {
+		t.Errorf("ExecDRACShell() expected RACADMError, got %v", err)
+	}
+	// stderr follows stdout.
+	if out != ms.shellOutput+"error" {
+		t.Errorf("ExecDRACShell() unexpected output: %q", out)
+	}
+}
+
+func Test_sshConnection_power(t *testing.T) {
+	connector := &sshConnector{
+		dialer: md,
+	}
+
+	bmcConn, err := connector.NewConnection(&ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: BMCConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnection() - unexpected error: %v", err)
+	}
+
+	ms.messages["racadm serveraction powerup"] = "Server power operation successful"
+	ms.messages["racadm serveraction powerdown"] = "Server power operation successful"
+	ms.messages["racadm serveraction hardreset"] = "Server power operation successful"
+	ms.messages["racadm serveraction graceshutdown"] = "Server power operation successful"
+	ms.messages["racadm serveraction powerstatus"] = "Server power status: ON"
+	defer func() {
+		delete(ms.messages, "racadm serveraction powerup")
+		delete(ms.messages, "racadm serveraction powerdown")
+		delete(ms.messages, "racadm serveraction hardreset")
+		delete(ms.messages, "racadm serveraction graceshutdown")
+		delete(ms.messages, "racadm serveraction powerstatus")
+	}()
+
+	actions := map[string]func() (string, error){
+		"PowerOn":          bmcConn.PowerOn,
+		"PowerOff":         bmcConn.PowerOff,
+		"HardReset":        bmcConn.HardReset,
+		"GracefulShutdown": bmcConn.GracefulShutdown,
+	}
+	for name, action := range actions {
+		output, err := action()
+		if err != nil || output != "Server power operation successful" {
+			t.Errorf("%s() = %q, %v", name, output, err)
+		}
+	}
+
+	status, err := bmcConn.PowerStatus()
+	if err != nil || status != PowerStatusOn {
+		t.Errorf("PowerStatus() = %q, %v, want %q", status, err, PowerStatusOn)
+	}
+
+	// Unparseable output is reported as an error.
+	ms.messages["racadm serveraction powerstatus"] = "ERROR"
+	if _, err = bmcConn.PowerStatus(); err == nil {
+		t.Errorf("PowerStatus() expected err, got nil.")
+	}
+
+	// Command failures are reported as errors.
+	mc.mustFail = true
+	if _, err = bmcConn.PowerStatus(); err == nil {
+		t.Errorf("PowerStatus() expected err, got nil.")
+	}
+	mc.mustFail = false
+
+	// Power control is not supported over host connections.
+	hostConn, err := connector.NewConnection(&ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: HostConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnection() - unexpected error: %v", err)
+	}
+	if _, err = hostConn.PowerOff(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("PowerOff() expected ErrNotSupported, got %v", err)
+	}
+}
+
+func Test_sshConnection_Describe(t *testing.T) {
+	connector := &sshConnector{
+		dialer:   md,
+		commands: &ILOCommands,
+	}
+
+	bmcConn, err := connector.NewConnection(&ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: BMCConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnection() - unexpected error: %v", err)
+	}
+	d := bmcConn.(Describer)
+	if got := d.Describe(MethodReboot); got != "reset /system1 on testhost:22" {
+		t.Errorf("Describe(%s) = %q", MethodReboot, got)
+	}
+	if got := d.Describe(MethodPowerOff); got != "stop -f /system1 on testhost:22" {
+		t.Errorf("Describe(%s) = %q", MethodPowerOff, got)
+	}
+
+	hostConn, err := connector.NewConnection(&ConnectionConfig{
+		Hostname: "testhost",
+		Username: "reboot-api",
+		Port:     22,
+		ConnType: HostConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnection() - unexpected error: %v", err)
+	}
+	d = hostConn.(Describer)
+	if got := d.Describe(MethodReboot); got != "login as reboot-api on testhost:22 (systemctl reboot)" {
+		t.Errorf("Describe(%s) = %q", MethodReboot, got)
+	}
+	if got := d.Describe(MethodPowerOn); !strings.Contains(got, "not supported") {
+		t.Errorf("Describe(%s) = %q", MethodPowerOn, got)
+	}
 }
@@ -0,0 +1,213 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ContextConnector is implemented by Connectors that can abort connecting
+// when a context is done.
+type ContextConnector interface {
+	NewConnectionContext(context.Context, *ConnectionConfig) (Connection, error)
+}
+
+// ContextConnection is implemented by Connections whose commands can be
+// aborted when a context is done, e.g. by closing the underlying session and
+// connection.
+type ContextConnection interface {
+	RebootContext(context.Context) (string, error)
+	ExecDRACShellContext(context.Context, string) (string, error)
+}
+
+// PowerContextConnection is implemented by Connections whose power actions
+// can be aborted when a context is done.
+type PowerContextConnection interface {
+	PowerOnContext(context.Context) (string, error)
+	PowerOffContext(context.Context) (string, error)
+	HardResetContext(context.Context) (string, error)
+	GracefulShutdownContext(context.Context) (string, error)
+	PowerStatusContext(context.Context) (string, error)
+}
+
+// NewConnectionContext creates a connection with c, giving up when ctx is
+// done. If c is not a ContextConnector, a connection established after ctx
+// is done is closed right away.
+func NewConnectionContext(ctx context.Context, c Connector,
+	config *ConnectionConfig) (Connection, error) {
+
+	if cc, ok := c.(ContextConnector); ok {
+		return cc.NewConnectionContext(ctx, config)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+
+	type result struct {
+		conn Connection
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := c.NewConnection(config)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// RebootContext reboots the node via conn, giving up when ctx is done. If
+// conn is not a ContextConnection, it's closed when ctx is done.
+func RebootContext(ctx context.Context, conn Connection) (string, error) {
+	if cc, ok := conn.(ContextConnection); ok {
+		return cc.RebootContext(ctx)
+	}
+	return runContext(ctx, conn, conn.Reboot)
+}
+
+// ExecDRACShellContext runs cmd in shell mode on a DRAC via conn, giving up
+// when ctx is done. If conn is not a ContextConnection, it's closed when ctx
+// is done.
+func ExecDRACShellContext(ctx context.Context, conn Connection,
+	cmd string) (string, error) {
+
+	if cc, ok := conn.(ContextConnection); ok {
+		return cc.ExecDRACShellContext(ctx, cmd)
+	}
+	return runContext(ctx, conn, func() (string, error) {
+		return conn.ExecDRACShell(cmd)
+	})
+}
+
+// PowerOnContext powers on the node via conn, giving up when ctx is done. If
+// conn is not a PowerContextConnection, it's closed when ctx is done.
+func PowerOnContext(ctx context.Context, conn Connection) (string, error) {
+	if pc, ok := conn.(PowerContextConnection); ok {
+		return pc.PowerOnContext(ctx)
+	}
+	return runContext(ctx, conn, conn.PowerOn)
+}
+
+// PowerOffContext powers off the node via conn, giving up when ctx is done.
+// If conn is not a PowerContextConnection, it's closed when ctx is done.
+func PowerOffContext(ctx context.Context, conn Connection) (string, error) {
+	if pc, ok := conn.(PowerContextConnection); ok {
+		return pc.PowerOffContext(ctx)
+	}
+	return runContext(ctx, conn, conn.PowerOff)
+}
+
+// HardResetContext resets the node via conn, giving up when ctx is done. If
+// conn is not a PowerContextConnection, it's closed when ctx is done.
+func HardResetContext(ctx context.Context, conn Connection) (string, error) {
+	if pc, ok := conn.(PowerContextConnection); ok {
+		return pc.HardResetContext(ctx)
+	}
+	return runContext(ctx, conn, conn.HardReset)
+}
+
+// GracefulShutdownContext asks the node's OS to shut down via conn, giving
+// up when ctx is done. If conn is not a PowerContextConnection, it's closed
+// when ctx is done.
+func GracefulShutdownContext(ctx context.Context, conn Connection) (string, error) {
+	if pc, ok := conn.(PowerContextConnection); ok {
+		return pc.GracefulShutdownContext(ctx)
+	}
+	return runContext(ctx, conn, conn.GracefulShutdown)
+}
+
+// PowerStatusContext returns the node's power status via conn, giving up
+// when ctx is done. If conn is not a PowerContextConnection, it's closed
+// when ctx is done.
+func PowerStatusContext(ctx context.Context, conn Connection) (string, error) {
+	if pc, ok := conn.(PowerContextConnection); ok {
+		return pc.PowerStatusContext(ctx)
+	}
+	return runContext(ctx, conn, conn.PowerStatus)
+}
+
+// runContext runs f, and closes c and returns early if ctx is done first.
+func runContext(ctx context.Context, c io.Closer,
+	f func() (string, error)) (string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return "", contextError(err)
+	}
+
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := f()
+		done <- result{output, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.output, r.err
+	case <-ctx.Done():
+		c.Close()
+		return "", contextError(ctx.Err())
+	}
+}
+
+// closeOnDone closes c as soon as ctx is done, until the returned function
+// is called. That function reports whether c has been closed, and can be
+// called more than once.
+func closeOnDone(ctx context.Context, c io.Closer) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+
+	stop := make(chan struct{})
+	closed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+			closed <- true
+		case <-stop:
+			closed <- false
+		}
+	}()
+	var (
+		once      sync.Once
+		wasClosed bool
+	)
+	return func() bool {
+		once.Do(func() {
+			close(stop)
+			wasClosed = <-closed
+		})
+		return wasClosed
+	}
+}
+
+// closerFunc is a function used as an io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// contextError returns err, the error of a done context, in the ErrTimeout
+// category if the context's deadline was exceeded.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &classifiedError{kind: ErrTimeout, err: err}
+	}
+	return err
+}
@@ -0,0 +1,266 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingSession is a session whose commands hang until it's closed.
+type blockingSession struct {
+	closed chan struct{}
+}
+
+func newBlockingSession() *blockingSession {
+	return &blockingSession{closed: make(chan struct{})}
+}
+
+func (s *blockingSession) CombinedOutput(cmd string) ([]byte, error) {
+	<-s.closed
+	return nil, io.EOF
+}
+
+func (s *blockingSession) Close() error {
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func (s *blockingSession) Shell() error                       { return nil }
+func (s *blockingSession) StdinPipe() (io.WriteCloser, error) { return &mockStdin{}, nil }
+func (s *blockingSession) StdoutPipe() (io.Reader, error)     { return strings.NewReader(""), nil }
+func (s *blockingSession) StderrPipe() (io.Reader, error)     { return strings.NewReader(""), nil }
+
+// Wait hangs like it does on DRACs.
+func (s *blockingSession) Wait() error {
+	<-s.closed
+	return io.EOF
+}
+
+// blockingClient is a client returning a blockingSession.
+type blockingClient struct {
+	session *blockingSession
+	closed  bool
+}
+
+func (c *blockingClient) NewSession() (session, error) {
+	return c.session, nil
+}
+
+func (c *blockingClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+// blockingConnector is a Connector whose connections are established once
+// release is closed. They can't be canceled otherwise.
+type blockingConnector struct {
+	release chan struct{}
+	conn    *blockingConnection
+}
+
+func (c *blockingConnector) NewConnection(*ConnectionConfig) (Connection, error) {
+	<-c.release
+	return c.conn, nil
+}
+
+// blockingConnection is a Connection whose methods hang until it's closed,
+// unless they have an output.
+type blockingConnection struct {
+	output string
+	closed chan struct{}
+}
+
+func (c *blockingConnection) Reboot() (string, error) {
+	if c.output != "" {
+		return c.output, nil
+	}
+	<-c.closed
+	return "", io.EOF
+}
+
+func (c *blockingConnection) PowerOn() (string, error)          { return "", ErrNotSupported }
+func (c *blockingConnection) PowerOff() (string, error)         { return "", ErrNotSupported }
+func (c *blockingConnection) HardReset() (string, error)        { return "", ErrNotSupported }
+func (c *blockingConnection) GracefulShutdown() (string, error) { return "", ErrNotSupported }
+func (c *blockingConnection) PowerStatus() (string, error)      { return "", ErrNotSupported }
+
+func (c *blockingConnection) ExecDRACShell(string) (string, error) {
+	<-c.closed
+	return "", io.EOF
+}
+
+func (c *blockingConnection) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func Test_sshConnection_RebootContext(t *testing.T) {
+	cl := &blockingClient{session: newBlockingSession()}
+	conn := &sshConnection{
+		config:   &ConnectionConfig{ConnType: BMCConnection},
+		client:   cl,
+		commands: &DRACCommands,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := RebootContext(ctx, conn)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("RebootContext() expected a timeout, got %v", err)
+	}
+	if !cl.closed {
+		t.Errorf("RebootContext() did not close the connection")
+	}
+
+	// Nothing is run with a done context.
+	if _, err = conn.RebootContext(ctx); !errors.Is(err, ErrTimeout) {
+		t.Errorf("RebootContext() expected a timeout, got %v", err)
+	}
+}
+
+func Test_sshConnection_PowerContext(t *testing.T) {
+	for name, f := range map[string]func(context.Context, Connection) (string, error){
+		"PowerOnContext":          PowerOnContext,
+		"PowerOffContext":         PowerOffContext,
+		"HardResetContext":        HardResetContext,
+		"GracefulShutdownContext": GracefulShutdownContext,
+		"PowerStatusContext":      PowerStatusContext,
+	} {
+		cl := &blockingClient{session: newBlockingSession()}
+		conn := &sshConnection{
+			config:   &ConnectionConfig{ConnType: BMCConnection},
+			client:   cl,
+			commands: &DRACCommands,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := f(ctx, conn)
+		cancel()
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("%s() expected a timeout, got %v", name, err)
+		}
+		if !cl.closed {
+			t.Errorf("%s() did not close the connection", name)
+		}
+	}
+}
+
+func Test_sshConnection_ExecDRACShellContext(t *testing.T) {
+	cl := &blockingClient{session: newBlockingSession()}
+	conn := &sshConnection{
+		config:   &ConnectionConfig{ConnType: BMCConnection},
+		client:   cl,
+		commands: &DRACCommands,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := ExecDRACShellContext(ctx, conn, "racadm getversion")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("ExecDRACShellContext() expected context.Canceled, got %v", err)
+	}
+	if !cl.closed {
+		t.Errorf("ExecDRACShellContext() did not close the connection")
+	}
+}
+
+func TestNewConnectionContext(t *testing.T) {
+	c := &blockingConnector{
+		release: make(chan struct{}),
+		conn:    &blockingConnection{closed: make(chan struct{})},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewConnectionContext(ctx, c, &ConnectionConfig{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("NewConnectionContext() expected context.Canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := NewConnectionContext(ctx, c, &ConnectionConfig{}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("NewConnectionContext() expected ErrTimeout, got %v", err)
+	}
+
+	// The connection established after giving up is closed.
+	close(c.release)
+	select {
+	case <-c.conn.closed:
+	case <-time.After(time.Second):
+		t.Errorf("NewConnectionContext() did not close the late connection")
+	}
+
+	// Connectors supporting contexts are used as such.
+	connector := &sshConnector{dialer: md}
+	conn, err := NewConnectionContext(context.Background(), connector, &ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: BMCConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnectionContext() unexpected error: %v", err)
+	}
+	if _, ok := conn.(ContextConnection); !ok {
+		t.Errorf("NewConnectionContext() did not return a ContextConnection")
+	}
+}
+
+func TestRebootContext(t *testing.T) {
+	conn := &blockingConnection{closed: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := RebootContext(ctx, conn); !errors.Is(err, ErrTimeout) {
+		t.Errorf("RebootContext() expected ErrTimeout, got %v", err)
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Errorf("RebootContext() did not close the connection")
+	}
+
+	// Successful calls go through.
+	out, err := RebootContext(context.Background(), &blockingConnection{output: "ok"})
+	if err != nil || out != "ok" {
+		t.Errorf("RebootContext() = %q, %v", out, err)
+	}
+}
+
+func Test_closeOnDone(t *testing.T) {
+	closed := make(chan struct{})
+	c := closerFunc(func() error {
+		close(closed)
+		return nil
+	})
+
+	stop := closeOnDone(context.Background(), c)
+	if stop() {
+		t.Errorf("closeOnDone() closed with a background context")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop = closeOnDone(ctx, c)
+	if stop() || stop() {
+		t.Errorf("closeOnDone() closed after being stopped")
+	}
+
+	stop = closeOnDone(ctx, c)
+	cancel()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("closeOnDone() did not close when the context was done")
+	}
+	if !stop() {
+		t.Errorf("closeOnDone() did not report closing")
+	}
+}
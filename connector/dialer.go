@@ -1,7 +1,9 @@
 package connector
 
 import (
+	"context"
 	"io"
+	"net"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -11,6 +13,13 @@ type dialer interface {
 	Dial(network, addr string, config *ssh.ClientConfig) (client, error)
 }
 
+// contextDialer is implemented by dialers that can give up when a context
+// is done.
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string,
+		config *ssh.ClientConfig) (client, error)
+}
+
 // client is an interface to allow mocking of ssh.Client in unit tests.
 type client interface {
 	NewSession() (session, error)
@@ -49,3 +58,36 @@ func (d *sshDialer) Dial(network, addr string,
 
 	return sshClient{cl}, nil
 }
+
+// DialContext is like Dial, but gives up when ctx is done. Since the SSH
+// handshake doesn't take a context, the TCP connection is closed to abort
+// it. The hooks of the ConnectTrace of ctx, if any, are called.
+func (d *sshDialer) DialContext(ctx context.Context, network, addr string,
+	config *ssh.ClientConfig) (client, error) {
+
+	trace := contextConnectTrace(ctx)
+	nd := &net.Dialer{Timeout: config.Timeout}
+	trace.dialStart(addr)
+	conn, err := nd.DialContext(ctx, network, addr)
+	trace.dialDone(err)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := closeOnDone(ctx, conn)
+	trace.handshakeStart()
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	trace.handshakeDone(err)
+	if stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return sshClient{ssh.NewClient(c, chans, reqs)}, nil
+}
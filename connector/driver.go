@@ -0,0 +1,169 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownModel is returned when no driver is registered for a BMC model.
+var ErrUnknownModel = errors.New("unknown BMC model")
+
+// Power statuses returned by Connection.PowerStatus.
+const (
+	PowerStatusOn  = "on"
+	PowerStatusOff = "off"
+)
+
+// Commands is the set of CLI commands used to manage a BMC over SSH.
+type Commands struct {
+	Reboot           string
+	PowerOn          string
+	PowerOff         string
+	HardReset        string
+	GracefulShutdown string
+	PowerStatus      string
+
+	// RACADM is whether the BMC's shell understands racadm commands, as run
+	// by ExecDRACShell.
+	RACADM bool
+}
+
+var (
+	// DRACCommands are the racadm commands for Dell iDRACs.
+	DRACCommands = Commands{
+		Reboot:           "racadm serveraction powercycle",
+		PowerOn:          "racadm serveraction powerup",
+		PowerOff:         "racadm serveraction powerdown",
+		HardReset:        "racadm serveraction hardreset",
+		GracefulShutdown: "racadm serveraction graceshutdown",
+		PowerStatus:      "racadm serveraction powerstatus",
+		RACADM:           true,
+	}
+
+	// ILOCommands are the SMASH CLP commands for HPE iLOs.
+	ILOCommands = Commands{
+		Reboot:           "reset /system1",
+		PowerOn:          "start /system1",
+		PowerOff:         "stop -f /system1",
+		HardReset:        "reset -f /system1",
+		GracefulShutdown: "stop /system1",
+		PowerStatus:      "show /system1 enabledstate",
+	}
+)
+
+// parsePowerStatus extracts the power status from the output of a power
+// status command, e.g. "Server power status: ON" for racadm or
+// "enabledstate=enabled" for SMASH CLP. A bare "On" or "Off", as reported by
+// Redfish, is accepted as well.
+func parsePowerStatus(output string) (string, error) {
+	for _, line := range strings.Split(output, "\n") {
+		value := line
+		if i := strings.LastIndexAny(line, ":="); i >= 0 {
+			value = line[i+1:]
+		}
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "on", "enabled":
+			return PowerStatusOn, nil
+		case "off", "disabled":
+			return PowerStatusOff, nil
+		}
+	}
+	return "", fmt.Errorf("cannot parse power status: %q", output)
+}
+
+// Driver describes how to manage BMCs of a given vendor or model.
+type Driver struct {
+	// Name is a human-readable description of the driver.
+	Name string
+	// Connector creates connections to BMCs handled by this driver.
+	Connector Connector
+}
+
+// Registry maps BMC models, as stored in Credentials.Model, to Drivers.
+//
+// Registry is itself a Connector: BMC connections are delegated to the
+// driver registered for ConnectionConfig.Model, while host connections
+// always use the host Connector.
+type Registry struct {
+	host         Connector
+	defaultModel string
+
+	mu      sync.RWMutex
+	drivers map[string]*Driver
+}
+
+// NewRegistry returns an empty Registry. BMCs with no model use the driver
+// registered for defaultModel, and host connections use host.
+func NewRegistry(defaultModel string, host Connector) *Registry {
+	return &Registry{
+		host:         host,
+		defaultModel: strings.ToLower(defaultModel),
+		drivers:      make(map[string]*Driver),
+	}
+}
+
+// Register adds a Driver for the given models. Model names are
+// case-insensitive, and registering a model twice replaces its driver.
+func (r *Registry) Register(d *Driver, models ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range models {
+		r.drivers[strings.ToLower(m)] = d
+	}
+}
+
+// Driver returns the Driver for model, or for the default model if model is
+// empty. If there is no such driver, the error wraps ErrUnknownModel.
+func (r *Registry) Driver(model string) (*Driver, error) {
+	model = strings.ToLower(model)
+	if model == "" {
+		model = r.defaultModel
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.drivers[model]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownModel, model)
+	}
+	return d, nil
+}
+
+// Models returns the sorted list of registered models.
+func (r *Registry) Models() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	models := make([]string, 0, len(r.drivers))
+	for m := range r.drivers {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// NewConnection creates a connection using the driver for config.Model.
+func (r *Registry) NewConnection(config *ConnectionConfig) (Connection, error) {
+	return r.NewConnectionContext(context.Background(), config)
+}
+
+// NewConnectionContext is like NewConnection, but gives up when ctx is done.
+func (r *Registry) NewConnectionContext(ctx context.Context,
+	config *ConnectionConfig) (Connection, error) {
+
+	if config.ConnType == HostConnection {
+		return NewConnectionContext(ctx, r.host, config)
+	}
+
+	d, err := r.Driver(config.Model)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnectionContext(ctx, d.Connector, config)
+}
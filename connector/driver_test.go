@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type namedConnector struct {
+	name string
+}
+
+func (n *namedConnector) NewConnection(*ConnectionConfig) (Connection, error) {
+	return nil, errors.New(n.name)
+}
+
+func TestRegistry_NewConnection(t *testing.T) {
+	r := NewRegistry("DRAC", &namedConnector{"host"})
+	r.Register(&Driver{Name: "drac", Connector: &namedConnector{"drac"}},
+		"drac", "iDRAC")
+	r.Register(&Driver{Name: "redfish", Connector: &namedConnector{"redfish"}},
+		"Redfish")
+
+	tests := []struct {
+		model    string
+		connType ConnType
+		want     string
+	}{
+		{model: "", connType: BMCConnection, want: "drac"},
+		{model: "idrac", connType: BMCConnection, want: "drac"},
+		{model: "REDFISH", connType: BMCConnection, want: "redfish"},
+		{model: "redfish", connType: HostConnection, want: "host"},
+	}
+	for _, tt := range tests {
+		_, err := r.NewConnection(&ConnectionConfig{
+			Model:    tt.model,
+			ConnType: tt.connType,
+		})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("NewConnection() with model %q used %v, want %s",
+				tt.model, err, tt.want)
+		}
+	}
+
+	// Unknown models must not fall back to the default driver.
+	_, err := r.NewConnection(&ConnectionConfig{
+		Model:    "unknown",
+		ConnType: BMCConnection,
+	})
+	if !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("NewConnection() expected ErrUnknownModel, got %v", err)
+	}
+
+	want := []string{"drac", "idrac", "redfish"}
+	if got := r.Models(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Models() = %v, want %v", got, want)
+	}
+}
+
+func TestRegistry_Driver(t *testing.T) {
+	r := NewRegistry("missing", &namedConnector{"host"})
+	if _, err := r.Driver(""); !errors.Is(err, ErrUnknownModel) {
+		t.Errorf("Driver() expected ErrUnknownModel, got %v", err)
+	}
+
+	d := &Driver{Name: "ilo", Connector: &namedConnector{"ilo"}}
+	r.Register(d, "ilo")
+	if got, err := r.Driver("ILO"); err != nil || got != d {
+		t.Errorf("Driver() = %v, %v, want %v", got, err, d)
+	}
+}
+
+func TestNewSSHConnector(t *testing.T) {
+	connector := NewSSHConnector(ILOCommands, nil).(*sshConnector)
+	connector.dialer = md
+
+	conn, err := connector.NewConnection(&ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: BMCConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+
+	// The iLO reboot command must be used rather than racadm.
+	ms.messages[ILOCommands.Reboot] = "Resetting server"
+	defer delete(ms.messages, ILOCommands.Reboot)
+	output, err := conn.Reboot()
+	if err != nil || output != "Resetting server" {
+		t.Errorf("Reboot() = %q, %v", output, err)
+	}
+
+	// iLOs don't run racadm commands.
+	if _, err = conn.ExecDRACShell("racadm getversion"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("ExecDRACShell() expected ErrNotSupported, got %v", err)
+	}
+}
+
+func Test_parsePowerStatus(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{output: "Server power status: ON", want: PowerStatusOn},
+		{output: "Server power status: OFF\n", want: PowerStatusOff},
+		{output: "/system1\n  Properties\n    enabledstate=enabled\n", want: PowerStatusOn},
+		{output: "/system1\n  Properties\n    enabledstate=disabled\n", want: PowerStatusOff},
+		{output: "On", want: PowerStatusOn},
+		{output: "Off", want: PowerStatusOff},
+		{output: "PoweringOn", wantErr: true},
+		{output: "ERROR: Unable to perform the requested action.", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePowerStatus(tt.output)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePowerStatus(%q) error = %v, wantErr %v", tt.output, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parsePowerStatus(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
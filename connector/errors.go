@@ -0,0 +1,109 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Categories of connection and command failures, to be used with errors.Is.
+// Errors returned by Connectors and Connections match at most one of them,
+// besides ErrHostKeyMismatch and ErrUnknownModel.
+var (
+	// ErrDNS means the BMC or host name could not be resolved.
+	ErrDNS = errors.New("DNS lookup failed")
+	// ErrConnectionRefused means nothing is listening on the remote port.
+	ErrConnectionRefused = errors.New("connection refused")
+	// ErrTimeout means the remote end did not reply in time.
+	ErrTimeout = errors.New("timeout")
+	// ErrAuthRejected means the credentials were refused.
+	ErrAuthRejected = errors.New("authentication rejected")
+	// ErrSession means an SSH session could not be opened on an
+	// established connection.
+	ErrSession = errors.New("cannot open session")
+	// ErrNonZeroExit means a command exited with a non-zero status.
+	ErrNonZeroExit = errors.New("non-zero exit status")
+	// ErrRACADM means racadm printed an error, whatever its exit status.
+	ErrRACADM = errors.New("racadm reported an error")
+)
+
+// classifiedError is an error belonging to one of the categories above. Its
+// message is the underlying error's.
+type classifiedError struct {
+	kind error
+	err  error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classify returns err so that it matches its category, if it has one.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var (
+		dnsErr *net.DNSError
+		netErr net.Error
+	)
+	// Errors during the SSH handshake are wrapped with %v, so some of them
+	// can only be recognized by their message.
+	msg := err.Error()
+	switch {
+	case errors.As(err, &dnsErr):
+		return &classifiedError{kind: ErrDNS, err: err}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return &classifiedError{kind: ErrConnectionRefused, err: err}
+	case errors.As(err, &netErr) && netErr.Timeout(),
+		strings.Contains(msg, "i/o timeout"):
+		return &classifiedError{kind: ErrTimeout, err: err}
+	case strings.Contains(msg, "unable to authenticate"):
+		return &classifiedError{kind: ErrAuthRejected, err: err}
+	}
+	return err
+}
+
+// authError returns an error in the ErrAuthRejected category.
+func authError(format string, a ...interface{}) error {
+	return &classifiedError{kind: ErrAuthRejected, err: fmt.Errorf(format, a...)}
+}
+
+// CommandError is returned when a command run over a Connection fails. It
+// keeps the command's output, which usually explains the failure.
+type CommandError struct {
+	Command string
+	Output  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	if e.Command == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the command exited with a non-zero status, when target
+// is ErrNonZeroExit.
+func (e *CommandError) Is(target error) bool {
+	var exitErr *ssh.ExitError
+	return target == ErrNonZeroExit && errors.As(e.Err, &exitErr)
+}
@@ -0,0 +1,154 @@
+package connector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// timeoutError is a net.Error timing out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "deadline exceeded" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_classify(t *testing.T) {
+	categories := []error{ErrDNS, ErrConnectionRefused, ErrTimeout,
+		ErrAuthRejected}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "dns",
+			err: &net.OpError{Op: "dial", Err: &net.DNSError{
+				Err: "no such host", Name: "mlab1d.abc0t"}},
+			want: ErrDNS,
+		},
+		{
+			name: "refused",
+			err: &net.OpError{Op: "dial",
+				Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			want: ErrConnectionRefused,
+		},
+		{
+			name: "timeout",
+			err:  &net.OpError{Op: "dial", Err: timeoutError{}},
+			want: ErrTimeout,
+		},
+		{
+			name: "handshake-timeout",
+			err:  errors.New("ssh: handshake failed: read tcp 10.0.0.1:22: i/o timeout"),
+			want: ErrTimeout,
+		},
+		{
+			name: "auth",
+			err: errors.New("ssh: handshake failed: ssh: unable to authenticate, " +
+				"attempted methods [none password], no supported methods remain"),
+			want: ErrAuthRejected,
+		},
+		{
+			name: "other",
+			err:  errors.New("ssh: handshake failed: EOF"),
+		},
+	}
+	for _, tt := range tests {
+		err := classify(tt.err)
+		if err.Error() != tt.err.Error() {
+			t.Errorf("classify() %s changed the message: %v", tt.name, err)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("classify() %s does not wrap the original error", tt.name)
+		}
+		for _, c := range categories {
+			if errors.Is(err, c) != (c == tt.want) {
+				t.Errorf("classify() %s: errors.Is(%v) = %v", tt.name, c, !(c == tt.want))
+			}
+		}
+	}
+	if classify(nil) != nil {
+		t.Errorf("classify(nil) is not nil")
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	err := &CommandError{
+		Command: "racadm serveraction powercycle",
+		Output:  "ERROR: Unable to perform the requested action.",
+		Err:     io.EOF,
+	}
+	if err.Error() != "racadm serveraction powercycle: EOF" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("CommandError does not wrap its error")
+	}
+	if errors.Is(err, ErrNonZeroExit) {
+		t.Errorf("CommandError unexpectedly matches ErrNonZeroExit")
+	}
+	err.Err = fmt.Errorf("wrapped: %w", &ssh.ExitError{})
+	if !errors.Is(err, ErrNonZeroExit) {
+		t.Errorf("CommandError does not match ErrNonZeroExit")
+	}
+	err.Err = io.EOF
+
+	// Host reboots don't run any command.
+	err.Command = ""
+	if err.Error() != "EOF" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func Test_sshConnection_exec(t *testing.T) {
+	connector := &sshConnector{
+		dialer: md,
+	}
+	conn, err := connector.NewConnection(&ConnectionConfig{
+		Hostname: "testhost",
+		Port:     22,
+		ConnType: BMCConnection,
+	})
+	if err != nil {
+		t.Fatalf("NewConnection() - unexpected error: %v", err)
+	}
+
+	ms.messages["racadm serveraction powerdown"] = "ERROR: Server is already powered OFF."
+	ms.failing = map[string]bool{"racadm serveraction powerdown": true}
+	defer func() {
+		delete(ms.messages, "racadm serveraction powerdown")
+		ms.failing = nil
+	}()
+
+	_, err = conn.PowerOff()
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("PowerOff() expected CommandError, got %v", err)
+	}
+	if cmdErr.Command != "racadm serveraction powerdown" ||
+		cmdErr.Output != "ERROR: Server is already powered OFF." {
+		t.Errorf("unexpected CommandError: %+v", cmdErr)
+	}
+
+	// racadm errors are detected even if the command exits successfully.
+	ms.failing = nil
+	if _, err = conn.PowerOff(); !errors.Is(err, ErrRACADM) {
+		t.Errorf("PowerOff() expected ErrRACADM, got %v", err)
+	}
+
+	mc.mustFail = true
+	if _, err = conn.PowerOff(); !errors.Is(err, ErrSession) {
+		t.Errorf("PowerOff() expected ErrSession, got %v", err)
+	}
+	if _, err = conn.ExecDRACShell("racadm getversion"); !errors.Is(err, ErrSession) {
+		t.Errorf("ExecDRACShell() expected ErrSession, got %v", err)
+	}
+	mc.mustFail = false
+}
@@ -0,0 +1,239 @@
+package connector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	// ErrHostKeyMismatch is returned when the key presented by a remote host
+	// differs from the known or pinned key for that host.
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+
+	// ErrHostKeyUnknown is returned in strict mode when the remote host has
+	// no known key.
+	ErrHostKeyUnknown = errors.New("unknown host key")
+)
+
+// HostKeyMode controls how a HostKeyStore treats unknown and changed keys.
+type HostKeyMode int
+
+const (
+	// HostKeyTOFU trusts and records the first key seen for a host, then
+	// rejects any different key.
+	HostKeyTOFU HostKeyMode = iota
+	// HostKeyStrict only accepts keys that are already known or pinned.
+	HostKeyStrict
+	// HostKeyWarn records new keys like HostKeyTOFU, but only logs a
+	// warning when a known key changes. Pinned keys are still enforced.
+	HostKeyWarn
+)
+
+var hostKeyModeNames = map[HostKeyMode]string{
+	HostKeyTOFU:   "tofu",
+	HostKeyStrict: "strict",
+	HostKeyWarn:   "warn",
+}
+
+func (m HostKeyMode) String() string {
+	if name, ok := hostKeyModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("HostKeyMode(%d)", int(m))
+}
+
+// ParseHostKeyMode returns the HostKeyMode corresponding to the given name,
+// i.e. "tofu", "strict" or "warn".
+func ParseHostKeyMode(name string) (HostKeyMode, error) {
+	for mode, n := range hostKeyModeNames {
+		if strings.EqualFold(name, n) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid host key mode: %q", name)
+}
+
+// HostKeyStore is a known_hosts-style store of SSH host keys. Its
+// HostKeyCallback method can be used as ConnectionConfig.HostKeyCallback.
+//
+// If the store has been created with a path, newly learned keys are
+// appended to that file in known_hosts format.
+type HostKeyStore struct {
+	mode HostKeyMode
+	path string
+
+	mu     sync.Mutex
+	known  map[string][]ssh.PublicKey
+	pinned map[string][]ssh.PublicKey
+}
+
+// NewHostKeyStore returns a HostKeyStore using the given mode. If path is
+// not empty, known keys are loaded from it. A missing file is not an error,
+// as it will be created when the first key is learned.
+func NewHostKeyStore(path string, mode HostKeyMode) (*HostKeyStore, error) {
+	s := &HostKeyStore{
+		mode:   mode,
+		path:   path,
+		known:  make(map[string][]ssh.PublicKey),
+		pinned: make(map[string][]ssh.PublicKey),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	err := readKnownHosts(path, s.known)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Pin adds keys to the ones pinned for hostname. Once a host has pinned keys,
+// only those are accepted regardless of the store's mode and of any
+// previously learned key.
+func (s *HostKeyStore) Pin(hostname string, keys ...ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host := knownhosts.Normalize(hostname)
+	s.pinned[host] = append(s.pinned[host], keys...)
+}
+
+// LoadPinned pins all the keys contained in a known_hosts file.
+func (s *HostKeyStore) LoadPinned(path string) error {
+	pinned := make(map[string][]ssh.PublicKey)
+	err := readKnownHosts(path, pinned)
+	if err != nil {
+		return err
+	}
+
+	for host, keys := range pinned {
+		s.Pin(host, keys...)
+	}
+	return nil
+}
+
+// HostKeyCallback returns a ssh.HostKeyCallback checking keys against this
+// store.
+func (s *HostKeyStore) HostKeyCallback() ssh.HostKeyCallback {
+	return s.check
+}
+
+func (s *HostKeyStore) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	host := knownhosts.Normalize(hostname)
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pinned, ok := s.pinned[host]; ok {
+		if containsKey(pinned, key) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s presented %s, which is not pinned",
+			ErrHostKeyMismatch, host, fingerprint)
+	}
+
+	if known, ok := s.known[host]; ok {
+		if containsKey(known, key) {
+			return nil
+		}
+		if s.mode == HostKeyWarn {
+			log.Printf("WARNING: host key for %s has changed (now %s)",
+				host, fingerprint)
+			return nil
+		}
+		return fmt.Errorf("%w: %s presented %s", ErrHostKeyMismatch, host,
+			fingerprint)
+	}
+
+	if s.mode == HostKeyStrict {
+		return fmt.Errorf("%w: %s presented %s", ErrHostKeyUnknown, host,
+			fingerprint)
+	}
+
+	log.Printf("Trusting new host key for %s: %s", host, fingerprint)
+	s.known[host] = []ssh.PublicKey{key}
+	if s.path != "" {
+		// Failing to persist the key does not prevent the connection, but
+		// the key will have to be learned again after a restart.
+		err := appendKnownHost(s.path, host, key)
+		if err != nil {
+			log.Printf("Cannot save host key for %s: %v", host, err)
+		}
+	}
+
+	return nil
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	marshaled := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), marshaled) {
+			return true
+		}
+	}
+	return false
+}
+
+// readKnownHosts parses a known_hosts file and adds its keys to dst. Hashed
+// hostnames and marker lines (e.g. @revoked) are skipped.
+func readKnownHosts(path string, dst map[string][]ssh.PublicKey) error {
+	content, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+
+	for len(content) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(content)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot parse %s: %v", path, err)
+		}
+		content = rest
+
+		if marker != "" {
+			continue
+		}
+		for _, h := range hosts {
+			if strings.HasPrefix(h, "|") {
+				continue
+			}
+			host := knownhosts.Normalize(h)
+			dst[host] = append(dst[host], key)
+		}
+	}
+
+	return nil
+}
+
+func appendKnownHost(path, host string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(filepath.Clean(path),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{host}, key))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,181 @@
+package connector
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned err: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey() returned err: %v", err)
+	}
+	return key
+}
+
+func TestParseHostKeyMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    HostKeyMode
+		wantErr bool
+	}{
+		{name: "tofu", want: HostKeyTOFU},
+		{name: "STRICT", want: HostKeyStrict},
+		{name: "warn", want: HostKeyWarn},
+		{name: "invalid", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHostKeyMode(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHostKeyMode(%q) error = %v, wantErr %v", tt.name,
+				err, tt.wantErr)
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("ParseHostKeyMode(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHostKeyStore_modes(t *testing.T) {
+	key := newTestKey(t)
+	otherKey := newTestKey(t)
+
+	tests := []struct {
+		mode        HostKeyMode
+		unknownErr  error
+		changedErr  error
+		wantLearned bool
+	}{
+		{mode: HostKeyTOFU, changedErr: ErrHostKeyMismatch, wantLearned: true},
+		{mode: HostKeyStrict, unknownErr: ErrHostKeyUnknown},
+		{mode: HostKeyWarn, wantLearned: true},
+	}
+
+	for _, tt := range tests {
+		s, err := NewHostKeyStore("", tt.mode)
+		if err != nil {
+			t.Fatalf("NewHostKeyStore() returned err: %v", err)
+		}
+		cb := s.HostKeyCallback()
+
+		// First connection to an unknown host.
+		err = cb("bmc.example.org:22", nil, key)
+		if !errors.Is(err, tt.unknownErr) || (err != nil) != (tt.unknownErr != nil) {
+			t.Errorf("%v: unknown host: got err %v, want %v", tt.mode, err,
+				tt.unknownErr)
+		}
+		if _, ok := s.known["bmc.example.org"]; ok != tt.wantLearned {
+			t.Errorf("%v: key learned = %v, want %v", tt.mode, ok, tt.wantLearned)
+		}
+
+		if !tt.wantLearned {
+			continue
+		}
+
+		// The same key must be accepted again.
+		if err = cb("bmc.example.org:22", nil, key); err != nil {
+			t.Errorf("%v: known key rejected: %v", tt.mode, err)
+		}
+
+		// A different key for the same host.
+		err = cb("bmc.example.org:22", nil, otherKey)
+		if !errors.Is(err, tt.changedErr) || (err != nil) != (tt.changedErr != nil) {
+			t.Errorf("%v: changed key: got err %v, want %v", tt.mode, err,
+				tt.changedErr)
+		}
+	}
+}
+
+func TestHostKeyStore_Pin(t *testing.T) {
+	key := newTestKey(t)
+	otherKey := newTestKey(t)
+
+	// Pinned keys must be enforced even in warn mode.
+	s, err := NewHostKeyStore("", HostKeyWarn)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore() returned err: %v", err)
+	}
+	s.Pin("bmc.example.org", key)
+
+	cb := s.HostKeyCallback()
+	if err = cb("bmc.example.org:22", nil, key); err != nil {
+		t.Errorf("pinned key rejected: %v", err)
+	}
+	if err = cb("bmc.example.org:22", nil, otherKey); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("expected ErrHostKeyMismatch, got %v", err)
+	}
+
+	// Non-default ports are normalized the same way as known_hosts does.
+	s.Pin("bmc.example.org:806", otherKey)
+	if err = cb("bmc.example.org:806", nil, otherKey); err != nil {
+		t.Errorf("pinned key rejected: %v", err)
+	}
+}
+
+func TestHostKeyStore_persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostkey")
+	if err != nil {
+		t.Fatalf("TempDir() returned err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "known_hosts")
+
+	key := newTestKey(t)
+	otherKey := newTestKey(t)
+
+	// The file does not exist yet, so the store should start empty.
+	s, err := NewHostKeyStore(path, HostKeyTOFU)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore() returned err: %v", err)
+	}
+	if err = s.HostKeyCallback()("bmc.example.org:22", nil, key); err != nil {
+		t.Fatalf("callback returned err: %v", err)
+	}
+
+	// A new store must load the learned key from the file.
+	s, err = NewHostKeyStore(path, HostKeyStrict)
+	if err != nil {
+		t.Fatalf("NewHostKeyStore() returned err: %v", err)
+	}
+	if err = s.HostKeyCallback()("bmc.example.org:22", nil, key); err != nil {
+		t.Errorf("persisted key rejected: %v", err)
+	}
+
+	// Pinned keys can be loaded from a known_hosts file.
+	pinnedPath := filepath.Join(dir, "pinned")
+	err = ioutil.WriteFile(pinnedPath,
+		[]byte("# comment\n"+knownhosts.Line([]string{"bmc.example.org"}, otherKey)+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+	if err = s.LoadPinned(pinnedPath); err != nil {
+		t.Fatalf("LoadPinned() returned err: %v", err)
+	}
+	if err = s.HostKeyCallback()("bmc.example.org:22", nil, key); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("expected ErrHostKeyMismatch, got %v", err)
+	}
+
+	// Invalid files should return an error.
+	err = ioutil.WriteFile(pinnedPath, []byte("bmc.example.org invalid\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+	if err = s.LoadPinned(pinnedPath); err == nil {
+		t.Errorf("LoadPinned() expected err, got nil.")
+	}
+	if _, err = NewHostKeyStore(pinnedPath, HostKeyTOFU); err == nil {
+		t.Errorf("NewHostKeyStore() expected err, got nil.")
+	}
+}
@@ -0,0 +1,653 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// This file implements the subset of IPMI v2.0 over LAN (RMCP+) needed to
+// control a chassis' power. Sessions are always established with cipher
+// suite 3 (RAKP-HMAC-SHA1, HMAC-SHA1-96, AES-CBC-128), which is the
+// default on most BMCs.
+
+const (
+	ipmiDefaultTimeout = 10 * time.Second
+	ipmiMaxPacketSize  = 1024
+
+	// IPMI v2.0 passwords are at most 20 bytes long, the size of the key
+	// used for RAKP authentication.
+	ipmiMaxPasswordLength = 20
+
+	// RMCP+ payload types.
+	ipmiPayloadIPMI        = 0x00
+	ipmiPayloadOpenSession = 0x10
+	ipmiPayloadOpenSessRsp = 0x11
+	ipmiPayloadRAKP1       = 0x12
+	ipmiPayloadRAKP2       = 0x13
+	ipmiPayloadRAKP3       = 0x14
+	ipmiPayloadRAKP4       = 0x15
+	ipmiPayloadEncrypted   = 0x80
+	ipmiPayloadAuth        = 0x40
+
+	ipmiAuthTypeNone  = 0x00
+	ipmiAuthTypeRMCPP = 0x06
+
+	// Network functions and commands.
+	ipmiNetFnChassis          = 0x00
+	ipmiNetFnApp              = 0x06
+	ipmiCmdChassisStatus      = 0x01
+	ipmiCmdChassisControl     = 0x02
+	ipmiCmdGetChannelAuthCaps = 0x38
+	ipmiCmdSetSessionPriv     = 0x3b
+	ipmiCmdCloseSession       = 0x3c
+
+	ipmiBMCAddress     = 0x20
+	ipmiConsoleAddress = 0x81
+
+	ipmiPrivAdmin = 0x04
+	// Requested role for RAKP1: administrator, with name-only lookup.
+	ipmiRoleAdmin = 0x14
+
+	// Chassis control values.
+	ipmiChassisPowerDown    = 0x00
+	ipmiChassisPowerUp      = 0x01
+	ipmiChassisPowerCycle   = 0x02
+	ipmiChassisHardReset    = 0x03
+	ipmiChassisSoftShutdown = 0x05
+)
+
+var ipmiRMCPHeader = []byte{0x06, 0x00, 0xff, 0x07}
+
+// ipmiChassisControls maps the supported ResetTypes to chassis control
+// values.
+var ipmiChassisControls = map[ResetType]byte{
+	ResetOn:               ipmiChassisPowerUp,
+	ResetForceOff:         ipmiChassisPowerDown,
+	ResetForceRestart:     ipmiChassisHardReset,
+	ResetPowerCycle:       ipmiChassisPowerCycle,
+	ResetGracefulShutdown: ipmiChassisSoftShutdown,
+}
+
+type ipmiConnector struct {
+	port int32
+}
+
+// NewIPMIConnector returns a Connector for BMCs supporting IPMI v2.0 over
+// LAN on the specified UDP port.
+func NewIPMIConnector(port int32) Connector {
+	return &ipmiConnector{
+		port: port,
+	}
+}
+
+// NewConnection establishes an authenticated IPMI session with the BMC.
+func (i *ipmiConnector) NewConnection(config *ConnectionConfig) (Connection, error) {
+	if config.ConnType != BMCConnection {
+		return nil, errors.New("ipmi only supports BMC connections")
+	}
+	// Longer passwords would be truncated, and rejected by the BMC with a
+	// misleading authentication error.
+	if len(config.Password) > ipmiMaxPasswordLength {
+		return nil, fmt.Errorf("ipmi: password longer than %d bytes",
+			ipmiMaxPasswordLength)
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = ipmiDefaultTimeout
+	}
+
+	addr := net.JoinHostPort(config.Hostname, strconv.Itoa(int(i.port)))
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	c := &ipmiConnection{
+		config:  config,
+		conn:    conn,
+		timeout: timeout,
+	}
+	err = c.openSession()
+	if err != nil {
+		conn.Close()
+		return nil, classify(err)
+	}
+
+	return c, nil
+}
+
+type ipmiConnection struct {
+	config  *ConnectionConfig
+	conn    net.Conn
+	timeout time.Duration
+
+	// Remote console (ours) and managed system (BMC's) session IDs.
+	consoleID uint32
+	sessionID uint32
+
+	seq   uint32
+	rqSeq uint8
+
+	// Integrity and confidentiality keys derived from the session key.
+	k1 []byte
+	k2 []byte
+}
+
+func ipmiChecksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return -sum
+}
+
+func ipmiHMAC(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// roundTrip sends a packet and returns the next packet received. When ctx
+// is done, the pending read is interrupted by moving the socket's deadline,
+// rather than by closing it, so that the session can still be closed.
+func (c *ipmiConnection) roundTrip(ctx context.Context, packet []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, contextError(err)
+	}
+	err := c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+	stop := closeOnDone(ctx, closerFunc(func() error {
+		return c.conn.SetDeadline(time.Unix(1, 0))
+	}))
+	defer stop()
+
+	buf := make([]byte, ipmiMaxPacketSize)
+	_, err = c.conn.Write(packet)
+	n := 0
+	if err == nil {
+		n, err = c.conn.Read(buf)
+	}
+	if stop() {
+		return nil, contextError(ctx.Err())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if n < len(ipmiRMCPHeader) || !bytes.Equal(buf[:4], ipmiRMCPHeader) {
+		return nil, errors.New("ipmi: invalid RMCP packet")
+	}
+	return buf[len(ipmiRMCPHeader):n], nil
+}
+
+// message builds an IPMI request message.
+func (c *ipmiConnection) message(netFn, cmd byte, data []byte) []byte {
+	c.rqSeq = (c.rqSeq + 1) & 0x3f
+
+	msg := []byte{ipmiBMCAddress, netFn << 2}
+	msg = append(msg, ipmiChecksum(msg))
+	body := append([]byte{ipmiConsoleAddress, c.rqSeq << 2, cmd}, data...)
+	msg = append(msg, body...)
+	return append(msg, ipmiChecksum(body))
+}
+
+// parseResponse validates an IPMI response message to the given command
+// and returns its data, excluding the completion code.
+func (c *ipmiConnection) parseResponse(cmd byte, msg []byte) ([]byte, error) {
+	if len(msg) < 8 {
+		return nil, errors.New("ipmi: response message too short")
+	}
+	if ipmiChecksum(msg[:2]) != msg[2] ||
+		ipmiChecksum(msg[3:len(msg)-1]) != msg[len(msg)-1] {
+		return nil, errors.New("ipmi: invalid response checksum")
+	}
+	if msg[5] != cmd || msg[4]>>2 != c.rqSeq {
+		return nil, errors.New("ipmi: unexpected response")
+	}
+	if msg[6] != 0 {
+		return nil, fmt.Errorf("ipmi: command 0x%02x failed with completion code 0x%02x",
+			cmd, msg[6])
+	}
+	return msg[7 : len(msg)-1], nil
+}
+
+// sessionPacket builds an unauthenticated RMCP+ packet, as used during the
+// session setup.
+func sessionPacket(payloadType byte, payload []byte) []byte {
+	p := append([]byte{}, ipmiRMCPHeader...)
+	p = append(p, ipmiAuthTypeRMCPP, payloadType)
+	p = append(p, make([]byte, 8)...) // session ID and sequence number
+	p = append(p, byte(len(payload)), byte(len(payload)>>8))
+	return append(p, payload...)
+}
+
+// parseSessionPacket returns the payload of an unauthenticated RMCP+
+// packet, checking that it has the expected type.
+func parseSessionPacket(payloadType byte, packet []byte) ([]byte, error) {
+	if len(packet) < 12 || packet[0] != ipmiAuthTypeRMCPP {
+		return nil, errors.New("ipmi: invalid RMCP+ packet")
+	}
+	if packet[1]&0x3f != payloadType {
+		return nil, fmt.Errorf("ipmi: unexpected payload type 0x%02x", packet[1])
+	}
+	length := int(binary.LittleEndian.Uint16(packet[10:12]))
+	if len(packet) < 12+length {
+		return nil, errors.New("ipmi: truncated RMCP+ packet")
+	}
+	return packet[12 : 12+length], nil
+}
+
+// openSession performs the RMCP+ session setup as described in section
+// 13.15 of the IPMI v2.0 specification.
+func (c *ipmiConnection) openSession() error {
+	err := c.getChannelAuthCapabilities()
+	if err != nil {
+		return err
+	}
+
+	id := make([]byte, 4)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	c.consoleID = binary.LittleEndian.Uint32(id) | 1
+
+	// Open Session Request, proposing cipher suite 3.
+	req := []byte{0x00, ipmiPrivAdmin, 0x00, 0x00}
+	req = append(req, le32(c.consoleID)...)
+	req = append(req,
+		0x00, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00, // RAKP-HMAC-SHA1
+		0x01, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00, // HMAC-SHA1-96
+		0x02, 0x00, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00) // AES-CBC-128
+	resp, err := c.sessionRoundTrip(ipmiPayloadOpenSession, ipmiPayloadOpenSessRsp, req)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 12 {
+		return errors.New("ipmi: open session response too short")
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("ipmi: open session failed with status 0x%02x", resp[1])
+	}
+	c.sessionID = binary.LittleEndian.Uint32(resp[8:12])
+
+	// RAKP Message 1.
+	rm := make([]byte, 16)
+	if _, err = rand.Read(rm); err != nil {
+		return err
+	}
+	username := []byte(c.config.Username)
+	if len(username) > 16 {
+		return errors.New("ipmi: username longer than 16 characters")
+	}
+	userInfo := append([]byte{ipmiRoleAdmin, byte(len(username))}, username...)
+
+	req = []byte{0x00, 0x00, 0x00, 0x00}
+	req = append(req, le32(c.sessionID)...)
+	req = append(req, rm...)
+	req = append(req, ipmiRoleAdmin, 0x00, 0x00, byte(len(username)))
+	req = append(req, username...)
+	resp, err = c.sessionRoundTrip(ipmiPayloadRAKP1, ipmiPayloadRAKP2, req)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 2 {
+		return errors.New("ipmi: RAKP2 too short")
+	}
+	if resp[1] != 0 {
+		return authError("ipmi: authentication failed with status 0x%02x", resp[1])
+	}
+	if len(resp) < 60 {
+		return errors.New("ipmi: RAKP2 too short")
+	}
+	rc, guid := resp[8:24], resp[24:40]
+
+	// The user key is the password, zero-padded to 20 bytes.
+	kuid := make([]byte, sha1.Size)
+	copy(kuid, c.config.Password)
+
+	expected := ipmiHMAC(kuid, le32(c.consoleID), le32(c.sessionID), rm, rc,
+		guid, userInfo)
+	if !hmac.Equal(expected, resp[40:60]) {
+		return authError("ipmi: authentication failed: invalid password")
+	}
+
+	sik := ipmiHMAC(kuid, rm, rc, userInfo)
+	c.k1 = ipmiHMAC(sik, bytes.Repeat([]byte{0x01}, sha1.Size))
+	c.k2 = ipmiHMAC(sik, bytes.Repeat([]byte{0x02}, sha1.Size))
+
+	// RAKP Message 3.
+	req = []byte{0x00, 0x00, 0x00, 0x00}
+	req = append(req, le32(c.sessionID)...)
+	req = append(req, ipmiHMAC(kuid, rc, le32(c.consoleID), userInfo)...)
+	resp, err = c.sessionRoundTrip(ipmiPayloadRAKP3, ipmiPayloadRAKP4, req)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 2 {
+		return errors.New("ipmi: RAKP4 too short")
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("ipmi: session activation failed with status 0x%02x", resp[1])
+	}
+	if len(resp) < 20 {
+		return errors.New("ipmi: RAKP4 too short")
+	}
+	icv := ipmiHMAC(sik, rm, le32(c.sessionID), guid)[:12]
+	if !hmac.Equal(icv, resp[8:20]) {
+		return errors.New("ipmi: invalid RAKP4 integrity check value")
+	}
+
+	// Sessions start with the User privilege level.
+	_, err = c.command(context.Background(), ipmiNetFnApp, ipmiCmdSetSessionPriv,
+		[]byte{ipmiPrivAdmin})
+	return err
+}
+
+func (c *ipmiConnection) sessionRoundTrip(reqType, respType byte,
+	payload []byte) ([]byte, error) {
+	packet, err := c.roundTrip(context.Background(), sessionPacket(reqType, payload))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := parseSessionPacket(respType, packet)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) >= 8 && binary.LittleEndian.Uint32(resp[4:8]) != c.consoleID {
+		return nil, errors.New("ipmi: response for a different session")
+	}
+	return resp, nil
+}
+
+// getChannelAuthCapabilities checks that the BMC supports IPMI v2.0. It's
+// sent as an IPMI v1.5 message since no session exists yet.
+func (c *ipmiConnection) getChannelAuthCapabilities() error {
+	// Channel 0x0e is the current channel, bit 7 requests v2.0 data.
+	msg := c.message(ipmiNetFnApp, ipmiCmdGetChannelAuthCaps,
+		[]byte{0x8e, ipmiPrivAdmin})
+
+	p := append([]byte{}, ipmiRMCPHeader...)
+	p = append(p, ipmiAuthTypeNone)
+	p = append(p, make([]byte, 8)...) // sequence number and session ID
+	p = append(p, byte(len(msg)))
+	p = append(p, msg...)
+
+	packet, err := c.roundTrip(context.Background(), p)
+	if err != nil {
+		return err
+	}
+	if len(packet) < 10 || packet[0] != ipmiAuthTypeNone {
+		return errors.New("ipmi: invalid channel authentication capabilities response")
+	}
+	data, err := c.parseResponse(ipmiCmdGetChannelAuthCaps, packet[10:])
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 || data[1]&0x80 == 0 {
+		return errors.New("ipmi: the BMC does not support IPMI v2.0")
+	}
+	return nil
+}
+
+// command sends an IPMI request over the authenticated and encrypted
+// session and returns the response data, giving up when ctx is done.
+func (c *ipmiConnection) command(ctx context.Context, netFn, cmd byte,
+	data []byte) ([]byte, error) {
+
+	payload, err := c.encrypt(c.message(netFn, cmd, data))
+	if err != nil {
+		return nil, err
+	}
+
+	c.seq++
+	p := []byte{ipmiAuthTypeRMCPP, ipmiPayloadEncrypted | ipmiPayloadAuth | ipmiPayloadIPMI}
+	p = append(p, le32(c.sessionID)...)
+	p = append(p, le32(c.seq)...)
+	p = append(p, byte(len(payload)), byte(len(payload)>>8))
+	p = append(p, payload...)
+
+	// The integrity pad makes the authenticated data a multiple of 4 bytes.
+	padLen := (4 - (len(p)+2)%4) % 4
+	p = append(p, bytes.Repeat([]byte{0xff}, padLen)...)
+	p = append(p, byte(padLen), 0x07)
+	p = append(p, ipmiHMAC(c.k1, p)[:12]...)
+
+	packet, err := c.roundTrip(ctx, append(append([]byte{}, ipmiRMCPHeader...), p...))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packet) < 12+12 || packet[0] != ipmiAuthTypeRMCPP ||
+		packet[1] != ipmiPayloadEncrypted|ipmiPayloadAuth|ipmiPayloadIPMI {
+		return nil, errors.New("ipmi: invalid session packet")
+	}
+	authCode := packet[len(packet)-12:]
+	if !hmac.Equal(authCode, ipmiHMAC(c.k1, packet[:len(packet)-12])[:12]) {
+		return nil, errors.New("ipmi: invalid integrity check value")
+	}
+	if binary.LittleEndian.Uint32(packet[2:6]) != c.consoleID {
+		return nil, errors.New("ipmi: response for a different session")
+	}
+	length := int(binary.LittleEndian.Uint16(packet[10:12]))
+	if len(packet) < 12+length+12 {
+		return nil, errors.New("ipmi: truncated session packet")
+	}
+
+	msg, err := c.decrypt(packet[12 : 12+length])
+	if err != nil {
+		return nil, err
+	}
+	return c.parseResponse(cmd, msg)
+}
+
+// encrypt encrypts a payload with AES-CBC-128, prefixing it with the IV.
+func (c *ipmiConnection) encrypt(data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(c.k2[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	// Confidentiality pad bytes are 1, 2, 3... followed by the pad length.
+	padLen := (aes.BlockSize - (len(data)+1)%aes.BlockSize) % aes.BlockSize
+	plain := append([]byte{}, data...)
+	for i := 1; i <= padLen; i++ {
+		plain = append(plain, byte(i))
+	}
+	plain = append(plain, byte(padLen))
+
+	out := make([]byte, aes.BlockSize+len(plain))
+	if _, err = rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).
+		CryptBlocks(out[aes.BlockSize:], plain)
+	return out, nil
+}
+
+func (c *ipmiConnection) decrypt(payload []byte) ([]byte, error) {
+	if len(payload) < 2*aes.BlockSize || len(payload)%aes.BlockSize != 0 {
+		return nil, errors.New("ipmi: invalid encrypted payload")
+	}
+	block, err := aes.NewCipher(c.k2[:16])
+	if err != nil {
+		return nil, err
+	}
+
+	plain := make([]byte, len(payload)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, payload[:aes.BlockSize]).
+		CryptBlocks(plain, payload[aes.BlockSize:])
+
+	padLen := int(plain[len(plain)-1])
+	if padLen >= len(plain) {
+		return nil, errors.New("ipmi: invalid confidentiality pad")
+	}
+	return plain[:len(plain)-padLen-1], nil
+}
+
+// Reset sends the chassis control command corresponding to resetType.
+func (c *ipmiConnection) Reset(resetType ResetType) (string, error) {
+	return c.ResetContext(context.Background(), resetType)
+}
+
+// ResetContext is like Reset, but gives up waiting for the BMC's response
+// when ctx is done.
+func (c *ipmiConnection) ResetContext(ctx context.Context,
+	resetType ResetType) (string, error) {
+
+	control, ok := ipmiChassisControls[resetType]
+	if !ok {
+		return "", ErrNotSupported
+	}
+
+	_, err := c.command(ctx, ipmiNetFnChassis, ipmiCmdChassisControl, []byte{control})
+	if err != nil {
+		log.Printf("Error executing chassis control %s: %v", resetType, err)
+		return "", err
+	}
+	return fmt.Sprintf("Chassis control %s successful", resetType), nil
+}
+
+// PowerState returns "On" or "Off" depending on the chassis status.
+func (c *ipmiConnection) PowerState() (string, error) {
+	return c.powerState(context.Background())
+}
+
+func (c *ipmiConnection) powerState(ctx context.Context) (string, error) {
+	data, err := c.command(ctx, ipmiNetFnChassis, ipmiCmdChassisStatus, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 1 {
+		return "", errors.New("ipmi: chassis status response too short")
+	}
+	if data[0]&0x01 != 0 {
+		return "On", nil
+	}
+	return "Off", nil
+}
+
+// PowerOn powers up the chassis.
+func (c *ipmiConnection) PowerOn() (string, error) {
+	return c.Reset(ResetOn)
+}
+
+// PowerOnContext is like PowerOn, but gives up when ctx is done.
+func (c *ipmiConnection) PowerOnContext(ctx context.Context) (string, error) {
+	return c.ResetContext(ctx, ResetOn)
+}
+
+// PowerOff powers down the chassis.
+func (c *ipmiConnection) PowerOff() (string, error) {
+	return c.Reset(ResetForceOff)
+}
+
+// PowerOffContext is like PowerOff, but gives up when ctx is done.
+func (c *ipmiConnection) PowerOffContext(ctx context.Context) (string, error) {
+	return c.ResetContext(ctx, ResetForceOff)
+}
+
+// HardReset resets the chassis without powering it off.
+func (c *ipmiConnection) HardReset() (string, error) {
+	return c.Reset(ResetForceRestart)
+}
+
+// HardResetContext is like HardReset, but gives up when ctx is done.
+func (c *ipmiConnection) HardResetContext(ctx context.Context) (string, error) {
+	return c.ResetContext(ctx, ResetForceRestart)
+}
+
+// GracefulShutdown asks the OS to shut down via ACPI.
+func (c *ipmiConnection) GracefulShutdown() (string, error) {
+	return c.Reset(ResetGracefulShutdown)
+}
+
+// GracefulShutdownContext is like GracefulShutdown, but gives up when ctx
+// is done.
+func (c *ipmiConnection) GracefulShutdownContext(ctx context.Context) (string, error) {
+	return c.ResetContext(ctx, ResetGracefulShutdown)
+}
+
+// PowerStatus returns PowerStatusOn or PowerStatusOff depending on the
+// chassis status.
+func (c *ipmiConnection) PowerStatus() (string, error) {
+	return c.PowerStatusContext(context.Background())
+}
+
+// PowerStatusContext is like PowerStatus, but gives up when ctx is done.
+func (c *ipmiConnection) PowerStatusContext(ctx context.Context) (string, error) {
+	state, err := c.powerState(ctx)
+	if err != nil {
+		return "", err
+	}
+	return parsePowerStatus(state)
+}
+
+// ExecDRACShell is not supported over IPMI.
+func (c *ipmiConnection) ExecDRACShell(string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// ExecDRACShellContext is not supported over IPMI.
+func (c *ipmiConnection) ExecDRACShellContext(context.Context, string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// Reboot power-cycles the chassis.
+func (c *ipmiConnection) Reboot() (string, error) {
+	return c.Reset(ResetPowerCycle)
+}
+
+// RebootContext is like Reboot, but gives up when ctx is done.
+func (c *ipmiConnection) RebootContext(ctx context.Context) (string, error) {
+	return c.ResetContext(ctx, ResetPowerCycle)
+}
+
+// Describe returns the chassis control command that method would send.
+func (c *ipmiConnection) Describe(method string) string {
+	resetTypes := map[string]ResetType{
+		MethodReboot:           ResetPowerCycle,
+		MethodPowerOn:          ResetOn,
+		MethodPowerOff:         ResetForceOff,
+		MethodHardReset:        ResetForceRestart,
+		MethodGracefulShutdown: ResetGracefulShutdown,
+	}
+	resetType, ok := resetTypes[method]
+	if !ok {
+		return method
+	}
+	return fmt.Sprintf("chassis control %s on %s", resetType,
+		c.conn.RemoteAddr())
+}
+
+// Close closes the IPMI session and the underlying socket.
+func (c *ipmiConnection) Close() error {
+	_, err := c.command(context.Background(), ipmiNetFnApp, ipmiCmdCloseSession,
+		le32(c.sessionID))
+	if err != nil {
+		log.Printf("Error while closing IPMI session: %v", err)
+	}
+	return c.conn.Close()
+}
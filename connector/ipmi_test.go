@@ -0,0 +1,216 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector/ipmitest"
+)
+
+func newIPMITestConnection(b *ipmitest.BMC, username, password string) (Connection, error) {
+	return NewIPMIConnector(b.Port()).NewConnection(&ConnectionConfig{
+		Hostname: b.Host(),
+		Username: username,
+		Password: password,
+		ConnType: BMCConnection,
+		Model:    "ipmi",
+		Timeout:  time.Second,
+	})
+}
+
+func Test_ipmiConnector_NewConnection(t *testing.T) {
+	b, err := ipmitest.NewBMC("admin", "secret")
+	if err != nil {
+		t.Fatalf("NewBMC() returned err: %v", err)
+	}
+	defer b.Close()
+
+	conn, err := newIPMITestConnection(b, "admin", "secret")
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+	if err = conn.Close(); err != nil {
+		t.Errorf("Close() returned err: %v", err)
+	}
+	if b.ClosedSessions() != 1 {
+		t.Errorf("the IPMI session has not been closed")
+	}
+
+	// Wrong username or password.
+	if _, err = newIPMITestConnection(b, "root", "secret"); err == nil {
+		t.Errorf("NewConnection() expected err, got nil.")
+	}
+	if _, err = newIPMITestConnection(b, "admin", "wrong"); !errors.Is(err, ErrAuthRejected) {
+		t.Errorf("NewConnection() expected ErrAuthRejected, got %v", err)
+	}
+	if _, err = newIPMITestConnection(b, "averyveryverylongusername", "secret"); err == nil {
+		t.Errorf("NewConnection() expected err, got nil.")
+	}
+	// Passwords longer than 20 bytes are rejected rather than truncated.
+	_, err = newIPMITestConnection(b, "admin", "averyveryverylongpassword")
+	if err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("NewConnection() expected a password length err, got %v", err)
+	}
+
+	// Host connections are not supported.
+	_, err = NewIPMIConnector(b.Port()).NewConnection(&ConnectionConfig{
+		Hostname: b.Host(),
+		ConnType: HostConnection,
+	})
+	if err == nil {
+		t.Errorf("NewConnection() expected err, got nil.")
+	}
+
+	// The BMC does not reply.
+	b.Close()
+	if _, err = newIPMITestConnection(b, "admin", "secret"); err == nil {
+		t.Errorf("NewConnection() expected err, got nil.")
+	}
+}
+
+func Test_ipmiConnection_Reset(t *testing.T) {
+	b, err := ipmitest.NewBMC("admin", "secret")
+	if err != nil {
+		t.Fatalf("NewBMC() returned err: %v", err)
+	}
+	defer b.Close()
+
+	conn, err := newIPMITestConnection(b, "admin", "secret")
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+	defer conn.Close()
+	ic := conn.(*ipmiConnection)
+
+	output, err := conn.Reboot()
+	if err != nil {
+		t.Errorf("Reboot() returned err: %v", err)
+	}
+	if output != "Chassis control PowerCycle successful" {
+		t.Errorf("Reboot() returned an unexpected output: %s", output)
+	}
+
+	tests := []struct {
+		resetType ResetType
+		powerOn   bool
+	}{
+		{resetType: ResetForceOff, powerOn: false},
+		{resetType: ResetOn, powerOn: true},
+		{resetType: ResetGracefulShutdown, powerOn: false},
+		{resetType: ResetForceRestart, powerOn: true},
+	}
+	for _, tt := range tests {
+		if _, err = ic.Reset(tt.resetType); err != nil {
+			t.Errorf("Reset(%s) returned err: %v", tt.resetType, err)
+		}
+		state, err := ic.PowerState()
+		if err != nil {
+			t.Errorf("PowerState() returned err: %v", err)
+		}
+		if (state == "On") != tt.powerOn {
+			t.Errorf("PowerState() after %s = %s", tt.resetType, state)
+		}
+	}
+
+	// Describing an action doesn't perform it.
+	if got := ic.Describe(MethodReboot); !strings.HasPrefix(got, "chassis control PowerCycle on ") {
+		t.Errorf("Describe(%s) = %q", MethodReboot, got)
+	}
+
+	expected := []byte{
+		ipmitest.ChassisPowerCycle, ipmitest.ChassisPowerDown,
+		ipmitest.ChassisPowerUp, ipmitest.ChassisSoftShutdown,
+		ipmitest.ChassisHardReset,
+	}
+	if string(b.ChassisControls()) != string(expected) {
+		t.Errorf("unexpected chassis controls: %v", b.ChassisControls())
+	}
+
+	for _, action := range []func() (string, error){
+		conn.PowerOff, conn.PowerOn, conn.HardReset, conn.GracefulShutdown,
+	} {
+		if _, err = action(); err != nil {
+			t.Errorf("power action returned err: %v", err)
+		}
+	}
+	if status, err := conn.PowerStatus(); err != nil || status != PowerStatusOff {
+		t.Errorf("PowerStatus() = %s, %v, want %s", status, err, PowerStatusOff)
+	}
+	expected = append(expected, ipmitest.ChassisPowerDown,
+		ipmitest.ChassisPowerUp, ipmitest.ChassisHardReset,
+		ipmitest.ChassisSoftShutdown)
+	if string(b.ChassisControls()) != string(expected) {
+		t.Errorf("unexpected chassis controls: %v", b.ChassisControls())
+	}
+
+	// GracefulRestart has no chassis control equivalent.
+	if _, err = ic.Reset(ResetGracefulRestart); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Reset() expected ErrNotSupported, got %v", err)
+	}
+	if _, err = conn.ExecDRACShell("racadm getversion"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("ExecDRACShell() expected ErrNotSupported, got %v", err)
+	}
+
+	// Unknown commands are reported via their completion code.
+	if _, err = ic.command(context.Background(), 0x0a, 0x10, nil); err == nil {
+		t.Errorf("command() expected err, got nil.")
+	}
+
+	// Commands fail once the BMC stops responding.
+	b.Close()
+	if _, err = conn.Reboot(); err == nil {
+		t.Errorf("Reboot() expected err, got nil.")
+	}
+	if _, err = ic.PowerState(); err == nil {
+		t.Errorf("PowerState() expected err, got nil.")
+	}
+	if _, err = conn.PowerStatus(); err == nil {
+		t.Errorf("PowerStatus() expected err, got nil.")
+	}
+}
+
+func Test_ipmiConnection_context(t *testing.T) {
+	b, err := ipmitest.NewBMC("admin", "secret")
+	if err != nil {
+		t.Fatalf("NewBMC() returned err: %v", err)
+	}
+	defer b.Close()
+
+	conn, err := newIPMITestConnection(b, "admin", "secret")
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+
+	// Commands the BMC doesn't reply to are abandoned when the context is
+	// done, without waiting for the connection's timeout.
+	b.SetUnresponsive(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err = RebootContext(ctx, conn); !errors.Is(err, context.Canceled) {
+		t.Errorf("RebootContext() expected context.Canceled, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = PowerStatusContext(ctx, conn); !errors.Is(err, ErrTimeout) {
+		t.Errorf("PowerStatusContext() expected ErrTimeout, got %v", err)
+	}
+	if time.Since(start) >= time.Second {
+		t.Errorf("commands were not abandoned when the context was done")
+	}
+
+	// The session is still usable, and is closed only once.
+	b.SetUnresponsive(false)
+	if status, err := conn.PowerStatus(); err != nil || status != PowerStatusOn {
+		t.Errorf("PowerStatus() = %s, %v, want %s", status, err, PowerStatusOn)
+	}
+	if err = conn.Close(); err != nil {
+		t.Errorf("Close() returned err: %v", err)
+	}
+	if b.ClosedSessions() != 1 {
+		t.Errorf("ClosedSessions() = %d, want 1", b.ClosedSessions())
+	}
+}
@@ -0,0 +1,438 @@
+// Package ipmitest provides a fake IPMI v2.0 BMC listening on UDP, to use in
+// tests.
+package ipmitest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Chassis control values, as received by the BMC.
+const (
+	ChassisPowerDown    = 0x00
+	ChassisPowerUp      = 0x01
+	ChassisPowerCycle   = 0x02
+	ChassisHardReset    = 0x03
+	ChassisSoftShutdown = 0x05
+)
+
+// RMCP+ status codes returned by the BMC.
+const (
+	StatusOK               = 0x00
+	StatusInvalidSessionID = 0x02
+	StatusUnauthorizedName = 0x0d
+	StatusInvalidIntegrity = 0x0f
+	StatusInvalidAuthAlg   = 0x11
+)
+
+const (
+	completionOK             = 0x00
+	completionInvalidCommand = 0xc1
+)
+
+var rmcpHeader = []byte{0x06, 0x00, 0xff, 0x07}
+
+type session struct {
+	consoleID uint32
+	sessionID uint32
+	seq       uint32
+
+	rm       []byte
+	rc       []byte
+	userInfo []byte
+
+	k1, k2 []byte
+	active bool
+}
+
+// BMC is a fake IPMI v2.0 BMC supporting cipher suite 3 and the subset of
+// commands used by connector: channel authentication capabilities, session
+// setup and teardown, chassis status and chassis control.
+type BMC struct {
+	conn     *net.UDPConn
+	username string
+	password string
+	guid     []byte
+
+	mu       sync.Mutex
+	sessions map[uint32]*session
+	powerOn  bool
+	controls []byte
+	closed   int
+	// unresponsive makes the BMC ignore chassis commands.
+	unresponsive bool
+}
+
+// NewBMC starts a fake BMC on a random UDP port of the loopback interface,
+// accepting the given credentials. The chassis is initially powered on.
+func NewBMC(username, password string) (*BMC, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	b := &BMC{
+		conn:     conn,
+		username: username,
+		password: password,
+		guid:     make([]byte, 16),
+		sessions: make(map[uint32]*session),
+		powerOn:  true,
+	}
+	rand.Read(b.guid)
+
+	go b.serve()
+	return b, nil
+}
+
+// Host returns the address the BMC is listening on.
+func (b *BMC) Host() string {
+	return b.conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// Port returns the UDP port the BMC is listening on.
+func (b *BMC) Port() int32 {
+	return int32(b.conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// Close stops the BMC.
+func (b *BMC) Close() error {
+	return b.conn.Close()
+}
+
+// PowerOn returns whether the chassis is powered on.
+func (b *BMC) PowerOn() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.powerOn
+}
+
+// SetPowerOn sets the chassis power state.
+func (b *BMC) SetPowerOn(on bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.powerOn = on
+}
+
+// SetUnresponsive makes the BMC ignore chassis commands, as an overloaded
+// BMC would, or reply to them again.
+func (b *BMC) SetUnresponsive(unresponsive bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unresponsive = unresponsive
+}
+
+// ChassisControls returns the chassis control values received so far.
+func (b *BMC) ChassisControls() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.controls...)
+}
+
+// ClosedSessions returns how many sessions have been closed by the client.
+func (b *BMC) ClosedSessions() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (b *BMC) serve() {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		resp := b.handle(append([]byte{}, buf[:n]...))
+		if resp != nil {
+			b.conn.WriteToUDP(append(append([]byte{}, rmcpHeader...), resp...), addr)
+		}
+	}
+}
+
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return -sum
+}
+
+func mac(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha1.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// handle returns the response to a packet, without the RMCP header, or nil
+// if the packet must be ignored.
+func (b *BMC) handle(packet []byte) []byte {
+	if len(packet) < 5 || !bytes.Equal(packet[:4], rmcpHeader) {
+		return nil
+	}
+	packet = packet[4:]
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch packet[0] {
+	case 0x00:
+		return b.handleV15(packet)
+	case 0x06:
+		if len(packet) < 12 {
+			return nil
+		}
+		length := int(binary.LittleEndian.Uint16(packet[10:12]))
+		if len(packet) < 12+length {
+			return nil
+		}
+		payload := packet[12 : 12+length]
+
+		switch packet[1] {
+		case 0x10:
+			return b.openSession(payload)
+		case 0x12:
+			return b.rakp1(payload)
+		case 0x14:
+			return b.rakp3(payload)
+		case 0xc0:
+			return b.sessionMessage(packet, payload)
+		}
+	}
+	return nil
+}
+
+// handleV15 only supports Get Channel Authentication Capabilities.
+func (b *BMC) handleV15(packet []byte) []byte {
+	if len(packet) < 10 {
+		return nil
+	}
+	req := packet[10:]
+	if len(req) < 7 || req[1]>>2 != 0x06 || req[5] != 0x38 {
+		return nil
+	}
+
+	// Channel 1, IPMI v2.0 extended data, IPMI v2.0 connections supported.
+	msg := response(req, completionOK, []byte{0x01, 0x80, 0x04, 0x02, 0, 0, 0, 0})
+	resp := append([]byte{0x00}, make([]byte, 8)...)
+	resp = append(resp, byte(len(msg)))
+	return append(resp, msg...)
+}
+
+// response builds the IPMI response message to the request message req.
+func response(req []byte, cc byte, data []byte) []byte {
+	msg := []byte{req[3], (req[1]>>2 | 1) << 2}
+	msg = append(msg, checksum(msg))
+	body := append([]byte{req[0], req[4], req[5], cc}, data...)
+	msg = append(msg, body...)
+	return append(msg, checksum(body))
+}
+
+func sessionPacket(payloadType byte, payload []byte) []byte {
+	p := []byte{0x06, payloadType}
+	p = append(p, make([]byte, 8)...)
+	p = append(p, byte(len(payload)), byte(len(payload)>>8))
+	return append(p, payload...)
+}
+
+func (b *BMC) openSession(req []byte) []byte {
+	if len(req) != 32 {
+		return nil
+	}
+	consoleID := binary.LittleEndian.Uint32(req[4:8])
+
+	resp := []byte{req[0], StatusOK, 0x04, 0x00}
+	resp = append(resp, le32(consoleID)...)
+
+	// Only cipher suite 3 is supported.
+	if req[12] != 0x01 || req[20] != 0x01 || req[28] != 0x01 {
+		resp[1] = StatusInvalidAuthAlg
+		return sessionPacket(0x11, resp)
+	}
+
+	s := &session{consoleID: consoleID}
+	for s.sessionID == 0 || b.sessions[s.sessionID] != nil {
+		id := make([]byte, 4)
+		rand.Read(id)
+		s.sessionID = binary.LittleEndian.Uint32(id)
+	}
+	b.sessions[s.sessionID] = s
+
+	resp = append(resp, le32(s.sessionID)...)
+	resp = append(resp, req[8:32]...)
+	return sessionPacket(0x11, resp)
+}
+
+func (b *BMC) rakp1(req []byte) []byte {
+	if len(req) < 28 || len(req) < 28+int(req[27]) {
+		return nil
+	}
+	s, ok := b.sessions[binary.LittleEndian.Uint32(req[4:8])]
+	if !ok {
+		return sessionPacket(0x13, []byte{req[0], StatusInvalidSessionID, 0, 0})
+	}
+
+	resp := []byte{req[0], StatusOK, 0, 0}
+	resp = append(resp, le32(s.consoleID)...)
+
+	username := req[28 : 28+int(req[27])]
+	if string(username) != b.username {
+		resp[1] = StatusUnauthorizedName
+		return sessionPacket(0x13, resp)
+	}
+
+	s.rm = append([]byte{}, req[8:24]...)
+	s.rc = make([]byte, 16)
+	rand.Read(s.rc)
+	s.userInfo = append([]byte{req[24], req[27]}, username...)
+
+	resp = append(resp, s.rc...)
+	resp = append(resp, b.guid...)
+	resp = append(resp, mac(b.kuid(), le32(s.consoleID), le32(s.sessionID),
+		s.rm, s.rc, b.guid, s.userInfo)...)
+	return sessionPacket(0x13, resp)
+}
+
+func (b *BMC) kuid() []byte {
+	k := make([]byte, sha1.Size)
+	copy(k, b.password)
+	return k
+}
+
+func (b *BMC) rakp3(req []byte) []byte {
+	if len(req) < 8 {
+		return nil
+	}
+	s, ok := b.sessions[binary.LittleEndian.Uint32(req[4:8])]
+	if !ok || s.rc == nil {
+		return sessionPacket(0x15, []byte{req[0], StatusInvalidSessionID, 0, 0})
+	}
+
+	resp := []byte{req[0], StatusOK, 0, 0}
+	resp = append(resp, le32(s.consoleID)...)
+
+	expected := mac(b.kuid(), s.rc, le32(s.consoleID), s.userInfo)
+	if len(req) < 28 || !hmac.Equal(expected, req[8:28]) {
+		resp[1] = StatusInvalidIntegrity
+		delete(b.sessions, s.sessionID)
+		return sessionPacket(0x15, resp)
+	}
+
+	sik := mac(b.kuid(), s.rm, s.rc, s.userInfo)
+	s.k1 = mac(sik, bytes.Repeat([]byte{0x01}, sha1.Size))
+	s.k2 = mac(sik, bytes.Repeat([]byte{0x02}, sha1.Size))
+	s.active = true
+
+	resp = append(resp, mac(sik, s.rm, le32(s.sessionID), b.guid)[:12]...)
+	return sessionPacket(0x15, resp)
+}
+
+func (b *BMC) sessionMessage(packet, payload []byte) []byte {
+	s, ok := b.sessions[binary.LittleEndian.Uint32(packet[2:6])]
+	if !ok || !s.active || len(packet) < 12+len(payload)+12 {
+		return nil
+	}
+
+	// Verify the integrity of the whole packet.
+	end := len(packet) - 12
+	if !hmac.Equal(packet[end:], mac(s.k1, packet[:end])[:12]) {
+		return nil
+	}
+
+	req := decrypt(s.k2, payload)
+	if len(req) < 7 {
+		return nil
+	}
+
+	netFn, cmd := req[1]>>2, req[5]
+	if b.unresponsive && netFn == 0x00 {
+		return nil
+	}
+	var cc byte = completionOK
+	var data []byte
+
+	switch {
+	case netFn == 0x06 && cmd == 0x3b: // Set Session Privilege Level
+		data = []byte{req[6]}
+	case netFn == 0x06 && cmd == 0x3c: // Close Session
+		delete(b.sessions, s.sessionID)
+		b.closed++
+	case netFn == 0x00 && cmd == 0x01: // Get Chassis Status
+		var state byte
+		if b.powerOn {
+			state = 0x01
+		}
+		data = []byte{state, 0x00, 0x00}
+	case netFn == 0x00 && cmd == 0x02: // Chassis Control
+		control := req[6]
+		b.controls = append(b.controls, control)
+		switch control {
+		case ChassisPowerDown, ChassisSoftShutdown:
+			b.powerOn = false
+		case ChassisPowerUp, ChassisPowerCycle, ChassisHardReset:
+			b.powerOn = true
+		}
+	default:
+		cc = completionInvalidCommand
+	}
+
+	enc := encrypt(s.k2, response(req, cc, data))
+	s.seq++
+	resp := []byte{0x06, 0xc0}
+	resp = append(resp, le32(s.consoleID)...)
+	resp = append(resp, le32(s.seq)...)
+	resp = append(resp, byte(len(enc)), byte(len(enc)>>8))
+	resp = append(resp, enc...)
+	padLen := (4 - (len(resp)+2)%4) % 4
+	resp = append(resp, bytes.Repeat([]byte{0xff}, padLen)...)
+	resp = append(resp, byte(padLen), 0x07)
+	return append(resp, mac(s.k1, resp)[:12]...)
+}
+
+func encrypt(k2, data []byte) []byte {
+	block, _ := aes.NewCipher(k2[:16])
+	padLen := (aes.BlockSize - (len(data)+1)%aes.BlockSize) % aes.BlockSize
+	plain := append([]byte{}, data...)
+	for i := 1; i <= padLen; i++ {
+		plain = append(plain, byte(i))
+	}
+	plain = append(plain, byte(padLen))
+
+	out := make([]byte, aes.BlockSize+len(plain))
+	rand.Read(out[:aes.BlockSize])
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).
+		CryptBlocks(out[aes.BlockSize:], plain)
+	return out
+}
+
+func decrypt(k2, payload []byte) []byte {
+	if len(payload) < 2*aes.BlockSize || len(payload)%aes.BlockSize != 0 {
+		return nil
+	}
+	block, _ := aes.NewCipher(k2[:16])
+	plain := make([]byte, len(payload)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, payload[:aes.BlockSize]).
+		CryptBlocks(plain, payload[aes.BlockSize:])
+
+	padLen := int(plain[len(plain)-1])
+	if padLen >= len(plain) {
+		return nil
+	}
+	return plain[:len(plain)-padLen-1]
+}
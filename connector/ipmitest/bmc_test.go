@@ -0,0 +1,62 @@
+package ipmitest
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBMC(t *testing.T) {
+	b, err := NewBMC("admin", "secret")
+	if err != nil {
+		t.Fatalf("NewBMC() returned err: %v", err)
+	}
+	defer b.Close()
+
+	if b.Host() != "127.0.0.1" || b.Port() == 0 {
+		t.Errorf("unexpected address: %s:%d", b.Host(), b.Port())
+	}
+
+	if !b.PowerOn() {
+		t.Errorf("the chassis should be initially powered on")
+	}
+	b.SetPowerOn(false)
+	if b.PowerOn() {
+		t.Errorf("SetPowerOn(false) didn't power off the chassis")
+	}
+	if len(b.ChassisControls()) != 0 || b.ClosedSessions() != 0 {
+		t.Errorf("unexpected initial state")
+	}
+
+	conn, err := net.Dial("udp", b.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial() returned err: %v", err)
+	}
+	defer conn.Close()
+
+	// Get Channel Authentication Capabilities as sent by ipmitool.
+	msg := []byte{0x20, 0x18, 0xc8, 0x81, 0x04, 0x38, 0x8e, 0x04, 0xb1}
+	packet := append([]byte{0x06, 0x00, 0xff, 0x07, 0x00}, make([]byte, 8)...)
+	packet = append(packet, byte(len(msg)))
+	packet = append(packet, msg...)
+	conn.Write(packet)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read() returned err: %v", err)
+	}
+	resp := buf[4+10 : n]
+	if resp[5] != 0x38 || resp[6] != completionOK || resp[8]&0x80 == 0 {
+		t.Errorf("unexpected response: %x", resp)
+	}
+
+	// Invalid packets are ignored.
+	if b.handle([]byte{0x01, 0x02}) != nil {
+		t.Errorf("handle() should ignore invalid packets")
+	}
+	if b.handle([]byte{0x06, 0x00, 0xff, 0x07, 0x06, 0xc0}) != nil {
+		t.Errorf("handle() should ignore truncated packets")
+	}
+}
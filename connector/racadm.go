@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"regexp"
+	"strings"
+)
+
+// racadm's exit status can't be trusted: depending on the iDRAC generation,
+// failed commands exit successfully or, in shell mode, don't report an exit
+// status at all. Failures must be recognized from the output instead.
+var (
+	// racadmFailures are the lines reporting a failure, on any iDRAC
+	// generation, e.g.:
+	//
+	//   ERROR: Unable to perform the requested action.   (iDRAC6/7/8)
+	//   ERROR: SWC0242 : A required parameter is missing. (iDRAC9)
+	//   COMMAND PROCESSING FAILED                        (iDRAC6)
+	//   UNKNOWN COMMAND: foo                             (iDRAC6)
+	racadmFailures = regexp.MustCompile(
+		`^(ERROR\b|COMMAND PROCESSING FAILED|UNKNOWN COMMAND|Invalid subcommand|` +
+			`Invalid object name|SEC\d{4}:|RAC\d{3,4}: Unable)`)
+
+	// racadmSoftFailures are lines that usually report a failure, unless the
+	// output also contains a success message, e.g. a warning printed before
+	// the operation succeeds.
+	racadmSoftFailures = regexp.MustCompile(`(?i)^(unable to|.*\bfailed\b)`)
+
+	// racadmSuccesses are the lines reporting a success, e.g.:
+	//
+	//   Server power operation successful
+	//   Server power operation initiated successfully
+	//   RAC reset operation initiated successfully.
+	//   Object value modified successfully
+	//   RAC1017: Successfully modified the object value and the change is
+	racadmSuccesses = regexp.MustCompile(`(?i)(\bsuccessful(ly)?\b|^RAC\d{3,4}: Successfully)`)
+)
+
+// RACADMError is returned when the output of a racadm command reports a
+// failure. It matches ErrRACADM.
+type RACADMError struct {
+	// Message is the line of output reporting the failure.
+	Message string
+}
+
+func (e *RACADMError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrRACADM.
+func (e *RACADMError) Is(target error) bool {
+	return target == ErrRACADM
+}
+
+// CheckRACADMOutput returns a *RACADMError if the output of a racadm command
+// reports a failure, and nil otherwise. Output with no recognizable message,
+// e.g. the output of racadm get* commands, is not a failure.
+func CheckRACADMOutput(output string) error {
+	var soft string
+	succeeded := false
+	for _, line := range racadmLines(output) {
+		switch {
+		case racadmFailures.MatchString(line):
+			return &RACADMError{Message: line}
+		case racadmSuccesses.MatchString(line):
+			succeeded = true
+		case soft == "" && !strings.Contains(line, "=") &&
+			racadmSoftFailures.MatchString(line):
+			soft = line
+		}
+	}
+	if soft != "" && !succeeded {
+		return &RACADMError{Message: soft}
+	}
+	return nil
+}
+
+// ParseRACADMOutput extracts the key/value pairs printed by racadm get*
+// commands, e.g. "Firmware Version = 2.70.70.70" (getsysinfo, getversion),
+// "#cfgNicIpAddress=10.0.0.1" (getconfig) or "#Version=4.40.00.00" (get).
+// Read-only markers ("#") and section headers ("[Key=...]") are stripped.
+// If the output reports a failure, the error is a *RACADMError.
+func ParseRACADMOutput(output string) (map[string]string, error) {
+	if err := CheckRACADMOutput(output); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, line := range racadmLines(output) {
+		if strings.HasPrefix(line, "[") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key := strings.TrimSpace(strings.TrimLeft(line[:i], "#"))
+		if key == "" {
+			continue
+		}
+		values[key] = strings.TrimSpace(line[i+1:])
+	}
+	return values, nil
+}
+
+// racadmError returns a *RACADMError if cmd is a racadm command whose
+// output reports a failure.
+func racadmError(cmd, output string) error {
+	if !strings.HasPrefix(cmd, "racadm") {
+		return nil
+	}
+	return CheckRACADMOutput(output)
+}
+
+// racadmLines returns the non-empty lines of output, trimmed.
+func racadmLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,176 @@
+package connector
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCheckRACADMOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		wantMsg string
+	}{
+		{
+			name:   "idrac8-success",
+			output: "Server power operation successful\n",
+		},
+		{
+			name:   "idrac9-success",
+			output: "Server power operation initiated successfully\n",
+		},
+		{
+			name:   "racreset-success",
+			output: "RAC reset operation initiated successfully. It may take a few\nminutes for the RAC to come online again.\n",
+		},
+		{
+			name:   "set-success-with-id",
+			output: "[Key=iDRAC.Embedded.1#NIC.1]\nRAC1017: Successfully modified the object value and the change is in\n         pending state.\n",
+		},
+		{
+			name:   "no-message",
+			output: "Bios Version                     = 2.5.4\niDRAC Version                    = 2.70.70.70\n",
+		},
+		{
+			name:   "empty",
+			output: "",
+		},
+		{
+			name:    "idrac8-error",
+			output:  "\nERROR: Unable to perform the requested action.\n",
+			wantMsg: "ERROR: Unable to perform the requested action.",
+		},
+		{
+			name:    "idrac9-error-with-id",
+			output:  "ERROR: SWC0242 : A required parameter is missing.\n",
+			wantMsg: "ERROR: SWC0242 : A required parameter is missing.",
+		},
+		{
+			name:    "idrac6-error",
+			output:  "/admin1-> racadm serveraction powercycle\nCOMMAND PROCESSING FAILED\n",
+			wantMsg: "COMMAND PROCESSING FAILED",
+		},
+		{
+			name:    "unknown-command",
+			output:  "UNKNOWN COMMAND: serveractoin\n",
+			wantMsg: "UNKNOWN COMMAND: serveractoin",
+		},
+		{
+			name:    "soft-failure",
+			output:  "Unable to connect to RAC at specified IP address.\n",
+			wantMsg: "Unable to connect to RAC at specified IP address.",
+		},
+		{
+			name:   "soft-failure-then-success",
+			output: "Login failed, retrying.\nServer power operation successful\n",
+		},
+		{
+			name:   "failed-value",
+			output: "Last Update Status = Failed\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRACADMOutput(tt.output)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Errorf("CheckRACADMOutput() unexpected error: %v", err)
+				}
+				return
+			}
+			var racErr *RACADMError
+			if !errors.As(err, &racErr) || racErr.Message != tt.wantMsg {
+				t.Fatalf("CheckRACADMOutput() = %v, want %q", err, tt.wantMsg)
+			}
+			if !errors.Is(err, ErrRACADM) || err.Error() != tt.wantMsg {
+				t.Errorf("unexpected RACADMError: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseRACADMOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "getversion",
+			output: "Bios Version                     = 2.5.4\n" +
+				"iDRAC Version                    = 2.70.70.70\n" +
+				"Lifecycle Controller Version     = 2.70.70.70\n",
+			want: map[string]string{
+				"Bios Version":                 "2.5.4",
+				"iDRAC Version":                "2.70.70.70",
+				"Lifecycle Controller Version": "2.70.70.70",
+			},
+		},
+		{
+			name: "getsysinfo",
+			output: "RAC Information:\n" +
+				"RAC Date/Time           = Thu Oct 15 2020 10:00:00\n" +
+				"Firmware Version        = 2.70.70.70\n\n" +
+				"System Information:\n" +
+				"Power Status            = ON\n",
+			want: map[string]string{
+				"RAC Date/Time":    "Thu Oct 15 2020 10:00:00",
+				"Firmware Version": "2.70.70.70",
+				"Power Status":     "ON",
+			},
+		},
+		{
+			name: "get",
+			output: "[Key=iDRAC.Embedded.1#Info.1]\n" +
+				"#Build=12\n" +
+				"Name=iDRAC\n" +
+				"#Version=4.40.00.00\n",
+			want: map[string]string{
+				"Build":   "12",
+				"Name":    "iDRAC",
+				"Version": "4.40.00.00",
+			},
+		},
+		{
+			name:   "getconfig",
+			output: "# cfgNicIpAddress=10.0.0.1\ncfgNicEnable=1\n",
+			want: map[string]string{
+				"cfgNicIpAddress": "10.0.0.1",
+				"cfgNicEnable":    "1",
+			},
+		},
+		{
+			name:    "error",
+			output:  "ERROR: Invalid object name specified.\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRACADMOutput(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRACADMOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRACADMOutput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_racadmError(t *testing.T) {
+	if err := racadmError("racadm serveraction powercycle",
+		"Server power operation successful"); err != nil {
+		t.Errorf("racadmError() unexpected error: %v", err)
+	}
+	err := racadmError("racadm serveraction powercycle",
+		"\nERROR: Unable to perform the requested action.\n")
+	if !errors.Is(err, ErrRACADM) {
+		t.Errorf("racadmError() expected ErrRACADM, got %v", err)
+	}
+	if err := racadmError("reset /system1", "ERROR: not a racadm command"); err != nil {
+		t.Errorf("racadmError() unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package connector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+// ErrNotSupported is returned when a Connection does not support the
+// requested operation.
+var ErrNotSupported = errors.New("operation not supported by this connection")
+
+// ResetType is a power action, named after the ResetType values of the
+// Redfish ComputerSystem.Reset action.
+type ResetType string
+
+const (
+	// ResetForceRestart performs an immediate shutdown followed by a restart.
+	ResetForceRestart ResetType = "ForceRestart"
+	// ResetGracefulRestart performs a graceful shutdown followed by a
+	// restart.
+	ResetGracefulRestart ResetType = "GracefulRestart"
+	// ResetOn turns the system on.
+	ResetOn ResetType = "On"
+	// ResetForceOff turns the system off immediately.
+	ResetForceOff ResetType = "ForceOff"
+	// ResetGracefulShutdown shuts the system down gracefully.
+	ResetGracefulShutdown ResetType = "GracefulShutdown"
+	// ResetPowerCycle turns the system off and then on again.
+	ResetPowerCycle ResetType = "PowerCycle"
+)
+
+const (
+	redfishSystemsPath = "/redfish/v1/Systems"
+	resetActionName    = "#ComputerSystem.Reset"
+)
+
+type redfishConnector struct {
+	port int32
+	tls  *redfishTLS
+}
+
+// NewRedfishConnector returns a Connector for BMCs exposing a Redfish API
+// via HTTPS on the specified port. BMC certificates must be signed by a CA
+// trusted by the system.
+func NewRedfishConnector(port int32) Connector {
+	return &redfishConnector{
+		port: port,
+		tls:  &redfishTLS{},
+	}
+}
+
+// NewRedfishConnectorWithTLS is like NewRedfishConnector, verifying BMC
+// certificates as specified by config.
+func NewRedfishConnectorWithTLS(port int32, config *RedfishTLSConfig) (Connector, error) {
+	t, err := newRedfishTLS(config)
+	if err != nil {
+		return nil, err
+	}
+	return &redfishConnector{
+		port: port,
+		tls:  t,
+	}, nil
+}
+
+// NewConnection looks up the first ComputerSystem on the Redfish service,
+// which also verifies that the provided credentials are valid.
+func (r *redfishConnector) NewConnection(config *ConnectionConfig) (Connection, error) {
+	if config.ConnType != BMCConnection {
+		return nil, errors.New("redfish only supports BMC connections")
+	}
+
+	c := &redfishConnection{
+		config:  config,
+		baseURL: fmt.Sprintf("https://%s:%d", config.Hostname, r.port),
+		client: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: r.tls.config(config.Hostname),
+			},
+		},
+	}
+
+	var systems struct {
+		Members []struct {
+			ID string `json:"@odata.id"`
+		}
+	}
+	err := c.do(http.MethodGet, redfishSystemsPath, nil, &systems)
+	if err != nil {
+		return nil, err
+	}
+	if len(systems.Members) == 0 {
+		return nil, errors.New("no systems found on the Redfish service")
+	}
+	c.systemPath = systems.Members[0].ID
+
+	return c, nil
+}
+
+type redfishConnection struct {
+	config     *ConnectionConfig
+	baseURL    string
+	systemPath string
+	client     *http.Client
+}
+
+// redfishSystem holds the fields of a ComputerSystem used by this package.
+type redfishSystem struct {
+	PowerState string
+	Actions    map[string]struct {
+		Target string `json:"target"`
+	}
+}
+
+// do sends a request to the Redfish service. If body is not nil it's sent
+// JSON-encoded, and if out is not nil the response is decoded into it.
+func (c *redfishConnection) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.config.Username, c.config.Password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return classify(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return authError("redfish: %s %s returned %s", method, path, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("redfish: %s %s returned %s: %s", method, path,
+			resp.Status, bytes.TrimSpace(msg))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *redfishConnection) system() (*redfishSystem, error) {
+	system := &redfishSystem{}
+	err := c.do(http.MethodGet, c.systemPath, nil, system)
+	if err != nil {
+		return nil, err
+	}
+	return system, nil
+}
+
+// Reset performs a ComputerSystem.Reset action of the given type.
+func (c *redfishConnection) Reset(resetType ResetType) (string, error) {
+	system, err := c.system()
+	if err != nil {
+		return "", err
+	}
+
+	// The action's target should be advertised by the system, but if it's
+	// missing we can fall back to the standard URI.
+	target := c.systemPath + "/Actions/ComputerSystem.Reset"
+	if action, ok := system.Actions[resetActionName]; ok && action.Target != "" {
+		target = action.Target
+	}
+
+	err = c.do(http.MethodPost, target, map[string]ResetType{
+		"ResetType": resetType,
+	}, nil)
+	if err != nil {
+		log.Printf("Error executing reset action %s: %v", resetType, err)
+		return "", err
+	}
+
+	return fmt.Sprintf("Reset action %s successful", resetType), nil
+}
+
+// PowerState returns the system's PowerState as reported by Redfish, e.g.
+// "On" or "Off".
+func (c *redfishConnection) PowerState() (string, error) {
+	system, err := c.system()
+	if err != nil {
+		return "", err
+	}
+	return system.PowerState, nil
+}
+
+// PowerOn powers on the system with an On reset action.
+func (c *redfishConnection) PowerOn() (string, error) {
+	return c.Reset(ResetOn)
+}
+
+// PowerOff powers off the system with a ForceOff reset action.
+func (c *redfishConnection) PowerOff() (string, error) {
+	return c.Reset(ResetForceOff)
+}
+
+// HardReset restarts the system with a ForceRestart reset action.
+func (c *redfishConnection) HardReset() (string, error) {
+	return c.Reset(ResetForceRestart)
+}
+
+// GracefulShutdown shuts down the system with a GracefulShutdown reset
+// action.
+func (c *redfishConnection) GracefulShutdown() (string, error) {
+	return c.Reset(ResetGracefulShutdown)
+}
+
+// PowerStatus returns the system's PowerState as PowerStatusOn or
+// PowerStatusOff.
+func (c *redfishConnection) PowerStatus() (string, error) {
+	state, err := c.PowerState()
+	if err != nil {
+		return "", err
+	}
+	return parsePowerStatus(state)
+}
+
+// ExecDRACShell is not supported over Redfish.
+func (c *redfishConnection) ExecDRACShell(string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// Reboot power-cycles the system with a ForceRestart reset action.
+func (c *redfishConnection) Reboot() (string, error) {
+	return c.Reset(ResetForceRestart)
+}
+
+// Describe returns the reset action that method would perform.
+func (c *redfishConnection) Describe(method string) string {
+	resetTypes := map[string]ResetType{
+		MethodReboot:           ResetForceRestart,
+		MethodPowerOn:          ResetOn,
+		MethodPowerOff:         ResetForceOff,
+		MethodHardReset:        ResetForceRestart,
+		MethodGracefulShutdown: ResetGracefulShutdown,
+	}
+	resetType, ok := resetTypes[method]
+	if !ok {
+		return method
+	}
+	return fmt.Sprintf("ComputerSystem.Reset (ResetType: %s) on %s%s",
+		resetType, c.baseURL, c.systemPath)
+}
+
+func (c *redfishConnection) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
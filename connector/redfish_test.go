@@ -0,0 +1,161 @@
+package connector
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/reboot-service/connector/redfishtest"
+)
+
+// newRedfishTestConnector returns a Connector trusting the certificate of s.
+func newRedfishTestConnector(s *redfishtest.Server) Connector {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	return &redfishConnector{
+		port: s.Port(),
+		tls:  &redfishTLS{roots: roots},
+	}
+}
+
+func newRedfishTestConnection(s *redfishtest.Server, password string) (Connection, error) {
+	connector := newRedfishTestConnector(s)
+	return connector.NewConnection(&ConnectionConfig{
+		Hostname: s.Host(),
+		Username: "admin",
+		Password: password,
+		ConnType: BMCConnection,
+		Model:    "redfish",
+	})
+}
+
+func Test_redfishConnector_NewConnection(t *testing.T) {
+	s := redfishtest.NewServer("admin", "secret")
+	defer s.Close()
+
+	conn, err := newRedfishTestConnection(s, "secret")
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+	conn.Close()
+
+	// Wrong credentials should make NewConnection fail.
+	_, err = newRedfishTestConnection(s, "wrong")
+	if !errors.Is(err, ErrAuthRejected) {
+		t.Errorf("NewConnection() expected ErrAuthRejected, got %v", err)
+	}
+
+	// Host connections are not supported.
+	_, err = newRedfishTestConnector(s).NewConnection(&ConnectionConfig{
+		Hostname: s.Host(),
+		ConnType: HostConnection,
+	})
+	if err == nil {
+		t.Errorf("NewConnection() expected err, got nil.")
+	}
+
+	// Nothing listening on this port.
+	s.Close()
+	_, err = newRedfishTestConnection(s, "secret")
+	if !errors.Is(err, ErrConnectionRefused) {
+		t.Errorf("NewConnection() expected ErrConnectionRefused, got %v", err)
+	}
+}
+
+func Test_redfishConnection_Reset(t *testing.T) {
+	s := redfishtest.NewServer("admin", "secret")
+	defer s.Close()
+
+	conn, err := newRedfishTestConnection(s, "secret")
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+	defer conn.Close()
+	rc := conn.(*redfishConnection)
+
+	output, err := conn.Reboot()
+	if err != nil {
+		t.Errorf("Reboot() returned err: %v", err)
+	}
+	if output != "Reset action ForceRestart successful" {
+		t.Errorf("Reboot() returned an unexpected output: %s", output)
+	}
+
+	for _, rt := range []ResetType{ResetForceOff, ResetOn, ResetGracefulRestart} {
+		_, err = rc.Reset(rt)
+		if err != nil {
+			t.Errorf("Reset(%s) returned err: %v", rt, err)
+		}
+	}
+	for _, action := range []func() (string, error){
+		conn.PowerOff, conn.PowerOn, conn.HardReset, conn.GracefulShutdown,
+	} {
+		if _, err = action(); err != nil {
+			t.Errorf("power action returned err: %v", err)
+		}
+	}
+
+	// Describing an action doesn't perform it.
+	if got := rc.Describe(MethodPowerOff); !strings.HasPrefix(got,
+		"ComputerSystem.Reset (ResetType: ForceOff) on "+s.URL) {
+		t.Errorf("Describe(%s) = %q", MethodPowerOff, got)
+	}
+
+	// Unsupported reset types are reported as errors.
+	_, err = rc.Reset("Invalid")
+	if err == nil {
+		t.Errorf("Reset() expected err, got nil.")
+	}
+
+	resets := s.Resets()
+	expected := []string{"ForceRestart", "ForceOff", "On", "GracefulRestart",
+		"ForceOff", "On", "ForceRestart", "GracefulShutdown"}
+	if len(resets) != len(expected) {
+		t.Fatalf("unexpected resets: %v", resets)
+	}
+	for i := range expected {
+		if resets[i] != expected[i] {
+			t.Errorf("unexpected resets: %v", resets)
+		}
+	}
+}
+
+func Test_redfishConnection_PowerState(t *testing.T) {
+	s := redfishtest.NewServer("admin", "secret")
+	defer s.Close()
+
+	conn, err := newRedfishTestConnection(s, "secret")
+	if err != nil {
+		t.Fatalf("NewConnection() returned err: %v", err)
+	}
+	defer conn.Close()
+	rc := conn.(*redfishConnection)
+
+	s.SetPowerState("Off")
+	state, err := rc.PowerState()
+	if err != nil || state != "Off" {
+		t.Errorf("PowerState() = %s, %v, want Off", state, err)
+	}
+	status, err := conn.PowerStatus()
+	if err != nil || status != PowerStatusOff {
+		t.Errorf("PowerStatus() = %s, %v, want %s", status, err, PowerStatusOff)
+	}
+
+	// Transitional states can't be reported as on or off.
+	s.SetPowerState("PoweringOn")
+	if _, err = conn.PowerStatus(); err == nil {
+		t.Errorf("PowerStatus() expected err, got nil.")
+	}
+
+	_, err = conn.ExecDRACShell("racadm getversion")
+	if !errors.Is(err, ErrNotSupported) {
+		t.Errorf("ExecDRACShell() expected ErrNotSupported, got %v", err)
+	}
+
+	s.Close()
+	_, err = rc.PowerState()
+	if err == nil {
+		t.Errorf("PowerState() expected err, got nil.")
+	}
+}
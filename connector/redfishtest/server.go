@@ -0,0 +1,164 @@
+// Package redfishtest provides a fake Redfish service to use in tests.
+package redfishtest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+const (
+	// SystemPath is the path of the only ComputerSystem on the fake service.
+	SystemPath = "/redfish/v1/Systems/System.Embedded.1"
+	// ResetPath is the target of the ComputerSystem.Reset action.
+	ResetPath = SystemPath + "/Actions/ComputerSystem.Reset"
+)
+
+// Server is a fake Redfish service exposing a single ComputerSystem. It
+// only supports the requests needed to read the power state and to perform
+// reset actions.
+type Server struct {
+	*httptest.Server
+
+	username string
+	password string
+
+	mu         sync.Mutex
+	powerState string
+	resets     []string
+}
+
+// NewServer starts a new HTTPS Server requiring the provided credentials.
+// The system is initially powered on.
+func NewServer(username, password string) *Server {
+	s := &Server{
+		username:   username,
+		password:   password,
+		powerState: "On",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redfish/v1/Systems", s.handleSystems)
+	mux.HandleFunc(SystemPath, s.handleSystem)
+	mux.HandleFunc(ResetPath, s.handleReset)
+
+	s.Server = httptest.NewTLSServer(s.authenticate(mux))
+	return s
+}
+
+// Host returns the host the server is listening on.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Listener.Addr().String())
+	return host
+}
+
+// Port returns the port the server is listening on.
+func (s *Server) Port() int32 {
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return int32(p)
+}
+
+// PowerState returns the current power state of the fake system.
+func (s *Server) PowerState() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.powerState
+}
+
+// SetPowerState sets the power state of the fake system.
+func (s *Server) SetPowerState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerState = state
+}
+
+// Resets returns the ResetType of every reset action received so far.
+func (s *Server) Resets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.resets...)
+}
+
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.username || pass != s.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleSystems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"@odata.id":           "/redfish/v1/Systems",
+		"Members@odata.count": 1,
+		"Members": []map[string]string{
+			{"@odata.id": SystemPath},
+		},
+	})
+}
+
+func (s *Server) handleSystem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"@odata.id":  SystemPath,
+		"PowerState": s.PowerState(),
+		"Actions": map[string]interface{}{
+			"#ComputerSystem.Reset": map[string]interface{}{
+				"target": ResetPath,
+				"ResetType@Redfish.AllowableValues": []string{
+					"On", "ForceOff", "GracefulRestart", "ForceRestart",
+					"GracefulShutdown", "PowerCycle",
+				},
+			},
+		},
+	})
+}
+
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		ResetType string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch body.ResetType {
+	case "On", "ForceRestart", "GracefulRestart", "PowerCycle":
+		s.powerState = "On"
+	case "ForceOff", "GracefulShutdown":
+		s.powerState = "Off"
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.resets = append(s.resets, body.ResetType)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,72 @@
+package redfishtest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer("admin", "secret")
+	defer s.Close()
+
+	if s.Host() != "127.0.0.1" || s.Port() == 0 {
+		t.Errorf("unexpected address: %s:%d", s.Host(), s.Port())
+	}
+
+	client := s.Client()
+
+	// Requests without valid credentials must be rejected.
+	resp, err := client.Get(s.URL + SystemPath)
+	if err != nil {
+		t.Fatalf("Get() returned err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{method: "GET", path: "/redfish/v1/Systems", status: http.StatusOK},
+		{method: "POST", path: "/redfish/v1/Systems", status: http.StatusMethodNotAllowed},
+		{method: "GET", path: SystemPath, status: http.StatusOK},
+		{method: "DELETE", path: SystemPath, status: http.StatusMethodNotAllowed},
+		{method: "GET", path: ResetPath, status: http.StatusMethodNotAllowed},
+		{method: "POST", path: ResetPath, body: "{", status: http.StatusBadRequest},
+		{method: "POST", path: ResetPath, body: `{"ResetType":"Invalid"}`, status: http.StatusBadRequest},
+		{method: "POST", path: ResetPath, body: `{"ResetType":"ForceOff"}`, status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, s.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatalf("NewRequest() returned err: %v", err)
+		}
+		req.SetBasicAuth("admin", "secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() returned err: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status,
+				resp.StatusCode)
+		}
+	}
+
+	if s.PowerState() != "Off" {
+		t.Errorf("PowerState() = %s, want Off", s.PowerState())
+	}
+	if resets := s.Resets(); len(resets) != 1 || resets[0] != "ForceOff" {
+		t.Errorf("Resets() = %v, want [ForceOff]", resets)
+	}
+
+	s.SetPowerState("On")
+	if s.PowerState() != "On" {
+		t.Errorf("PowerState() = %s, want On", s.PowerState())
+	}
+}
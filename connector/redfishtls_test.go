@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-lab/reboot-service/connector/redfishtest"
+)
+
+func TestNewRedfishConnectorWithTLS(t *testing.T) {
+	s := redfishtest.NewServer("admin", "secret")
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "redfishtls")
+	if err != nil {
+		t.Fatalf("TempDir() returned err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: s.Certificate().Raw,
+	}), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+
+	fingerprint := sha256.Sum256(s.Certificate().Raw)
+	pinnedFile := filepath.Join(dir, "pinned")
+	err = ioutil.WriteFile(pinnedFile, []byte(fmt.Sprintf(
+		"# Test server\n%s %s\n", s.Host(), hex.EncodeToString(fingerprint[:]))), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+	wrongPinFile := filepath.Join(dir, "wrong")
+	err = ioutil.WriteFile(wrongPinFile, []byte(fmt.Sprintf(
+		"%s %x\n", s.Host(), sha256.Sum256(nil))), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		config   *RedfishTLSConfig
+		wantErr  bool
+		mismatch bool
+	}{
+		{
+			// The test server's certificate is self-signed.
+			name:    "system-roots",
+			config:  &RedfishTLSConfig{},
+			wantErr: true,
+		},
+		{
+			name:   "ca-file",
+			config: &RedfishTLSConfig{CAFile: caFile},
+		},
+		{
+			name:   "pinned",
+			config: &RedfishTLSConfig{PinnedCertsFile: pinnedFile},
+		},
+		{
+			// Pinned certificates are enforced even when skipping
+			// verification.
+			name: "wrong-pin",
+			config: &RedfishTLSConfig{
+				PinnedCertsFile:    wrongPinFile,
+				InsecureSkipVerify: true,
+			},
+			wantErr:  true,
+			mismatch: true,
+		},
+		{
+			name:   "insecure",
+			config: &RedfishTLSConfig{InsecureSkipVerify: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewRedfishConnectorWithTLS(s.Port(), tt.config)
+			if err != nil {
+				t.Fatalf("NewRedfishConnectorWithTLS() returned err: %v", err)
+			}
+			conn, err := c.NewConnection(&ConnectionConfig{
+				Hostname: s.Host(),
+				Username: "admin",
+				Password: "secret",
+				ConnType: BMCConnection,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewConnection() returned err: %v, want error: %t", err, tt.wantErr)
+			}
+			if err == nil {
+				conn.Close()
+			}
+			if errors.Is(err, ErrHostKeyMismatch) != tt.mismatch {
+				t.Errorf("NewConnection() returned err: %v, want mismatch: %t", err, tt.mismatch)
+			}
+		})
+	}
+
+	// Invalid files are reported.
+	for _, config := range []*RedfishTLSConfig{
+		{CAFile: filepath.Join(dir, "missing")},
+		{CAFile: pinnedFile},
+		{PinnedCertsFile: caFile},
+	} {
+		if _, err := NewRedfishConnectorWithTLS(s.Port(), config); err == nil {
+			t.Errorf("NewRedfishConnectorWithTLS(%+v) expected err, got nil", config)
+		}
+	}
+}
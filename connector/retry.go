@@ -0,0 +1,88 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy configures how operations failing with a transient error are
+// retried, with exponential backoff and jitter.
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first one.
+	// Zero or one disable retries.
+	Attempts int
+	// Backoff is the delay before the first retry. It doubles at every
+	// retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// backoff returns the delay before the given retry, starting at 1. It's
+// picked at random between half and all of the exponential backoff, so that
+// concurrent operations don't retry in lockstep.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Retry calls f until it succeeds, it fails with an error for which
+// retryable returns false, the attempts are exhausted or ctx is done. f is
+// passed the attempt number, starting at 1. Retry returns f's last error.
+func (p RetryPolicy) Retry(ctx context.Context, retryable func(error) bool,
+	f func(attempt int) error) error {
+
+	for attempt := 1; ; attempt++ {
+		err := f(attempt)
+		if err == nil || attempt >= p.Attempts || !retryable(err) {
+			return err
+		}
+
+		t := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// IsTransientConnectError reports whether err, returned when connecting,
+// is likely to go away by itself: timeouts, refused or reset connections,
+// and handshakes interrupted by the remote end. BMCs under load often fail
+// this way.
+func IsTransientConnectError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	msg := err.Error()
+	return errors.Is(err, ErrTimeout) ||
+		errors.Is(err, ErrConnectionRefused) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		strings.Contains(msg, "connection reset by peer") ||
+		(strings.Contains(msg, "handshake failed") && strings.HasSuffix(msg, "EOF"))
+}
+
+// IsTransientCommandError reports whether err, returned by a Connection
+// method, can be retried safely. Only failures to open a session are:
+// once a session is open, the command may have been delivered, and running
+// a power command twice is never safe.
+func IsTransientCommandError(err error) bool {
+	return errors.Is(err, ErrSession)
+}
@@ -0,0 +1,136 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{retry: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{retry: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{retry: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{retry: 5, min: 500 * time.Millisecond, max: time.Second},
+		{retry: 100, min: 500 * time.Millisecond, max: time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			if d := p.backoff(tt.retry); d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d) = %v, want between %v and %v", tt.retry,
+					d, tt.min, tt.max)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(1); d != 0 {
+		t.Errorf("backoff() = %v with no backoff", d)
+	}
+}
+
+func TestRetryPolicy_Retry(t *testing.T) {
+	transient := errors.New("transient")
+	retryable := func(err error) bool { return err == transient }
+	p := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "success", errs: []error{nil}, wantCalls: 1},
+		{name: "retried", errs: []error{transient, transient, nil}, wantCalls: 3},
+		{
+			name:      "exhausted",
+			errs:      []error{transient, transient, transient, nil},
+			wantCalls: 3,
+			wantErr:   transient,
+		},
+		{name: "permanent", errs: []error{io.EOF, nil}, wantCalls: 1, wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := p.Retry(context.Background(), retryable, func(attempt int) error {
+				calls++
+				if attempt != calls {
+					t.Errorf("attempt = %d, want %d", attempt, calls)
+				}
+				return tt.errs[calls-1]
+			})
+			if err != tt.wantErr || calls != tt.wantCalls {
+				t.Errorf("Retry() = %v after %d calls, want %v after %d",
+					err, calls, tt.wantErr, tt.wantCalls)
+			}
+		})
+	}
+
+	// Retries stop when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := RetryPolicy{Attempts: 3, Backoff: time.Hour}.Retry(ctx, retryable,
+		func(int) error {
+			calls++
+			return transient
+		})
+	if err != transient || calls != 1 {
+		t.Errorf("Retry() = %v after %d calls", err, calls)
+	}
+
+	// No policy means a single attempt.
+	calls = 0
+	(RetryPolicy{}).Retry(context.Background(), retryable, func(int) error {
+		calls++
+		return transient
+	})
+	if calls != 1 {
+		t.Errorf("Retry() made %d calls with no policy", calls)
+	}
+}
+
+func TestIsTransientConnectError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil},
+		{err: classify(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)), want: true},
+		{err: classify(timeoutError{}), want: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{err: errors.New("ssh: handshake failed: read tcp: connection reset by peer"), want: true},
+		{err: errors.New("ssh: handshake failed: EOF"), want: true},
+		{err: authError("ssh: unable to authenticate")},
+		{err: fmt.Errorf("%w: x", ErrHostKeyMismatch)},
+		{err: contextError(context.DeadlineExceeded)},
+		{err: context.Canceled},
+	}
+	for _, tt := range tests {
+		if got := IsTransientConnectError(tt.err); got != tt.want {
+			t.Errorf("IsTransientConnectError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsTransientCommandError(t *testing.T) {
+	if !IsTransientCommandError(&classifiedError{kind: ErrSession, err: io.EOF}) {
+		t.Errorf("session failures should be transient")
+	}
+	for _, err := range []error{
+		&CommandError{Command: "racadm serveraction powercycle", Err: io.EOF},
+		classify(timeoutError{}),
+		&RACADMError{Message: "ERROR"},
+	} {
+		if IsTransientCommandError(err) {
+			t.Errorf("IsTransientCommandError(%v) = true", err)
+		}
+	}
+}
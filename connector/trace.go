@@ -0,0 +1,57 @@
+package connector
+
+import "context"
+
+// ConnectTrace is a set of hooks called while connecting to a SSH server,
+// to measure how long each step takes. Any hook can be nil. If a connection
+// is retried, the hooks are called at every attempt.
+type ConnectTrace struct {
+	// DialStart and DialDone are called before and after the TCP
+	// connection is established.
+	DialStart func(addr string)
+	DialDone  func(err error)
+	// HandshakeStart and HandshakeDone are called before and after the SSH
+	// handshake, including authentication.
+	HandshakeStart func()
+	HandshakeDone  func(err error)
+}
+
+type connectTraceKey struct{}
+
+// WithConnectTrace returns a context in which connecting calls the hooks of
+// trace. Only SSH connections are traced.
+func WithConnectTrace(ctx context.Context, trace *ConnectTrace) context.Context {
+	return context.WithValue(ctx, connectTraceKey{}, trace)
+}
+
+// contextConnectTrace returns the ConnectTrace of ctx, or an empty one.
+func contextConnectTrace(ctx context.Context) *ConnectTrace {
+	if trace, ok := ctx.Value(connectTraceKey{}).(*ConnectTrace); ok && trace != nil {
+		return trace
+	}
+	return &ConnectTrace{}
+}
+
+func (t *ConnectTrace) dialStart(addr string) {
+	if t.DialStart != nil {
+		t.DialStart(addr)
+	}
+}
+
+func (t *ConnectTrace) dialDone(err error) {
+	if t.DialDone != nil {
+		t.DialDone(err)
+	}
+}
+
+func (t *ConnectTrace) handshakeStart() {
+	if t.HandshakeStart != nil {
+		t.HandshakeStart()
+	}
+}
+
+func (t *ConnectTrace) handshakeDone(err error) {
+	if t.HandshakeDone != nil {
+		t.HandshakeDone(err)
+	}
+}
@@ -0,0 +1,72 @@
+package connector
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_sshDialer_DialContext_trace(t *testing.T) {
+	// A server that isn't a SSH server makes the handshake fail.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	var steps []string
+	ctx := WithConnectTrace(context.Background(), &ConnectTrace{
+		DialStart: func(addr string) {
+			steps = append(steps, "dial "+addr)
+		},
+		DialDone: func(err error) {
+			if err != nil {
+				t.Errorf("DialDone() called with error: %v", err)
+			}
+			steps = append(steps, "dialed")
+		},
+		HandshakeStart: func() {
+			steps = append(steps, "handshake")
+		},
+		HandshakeDone: func(err error) {
+			if err == nil {
+				t.Errorf("HandshakeDone() called with no error")
+			}
+			steps = append(steps, "handshaked")
+		},
+	})
+	d := &sshDialer{}
+	_, err = d.DialContext(ctx, "tcp", l.Addr().String(), &ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err == nil {
+		t.Errorf("DialContext() expected an error")
+	}
+	want := "dial " + l.Addr().String() + ",dialed,handshake,handshaked"
+	if got := strings.Join(steps, ","); got != want {
+		t.Errorf("DialContext() called hooks %s, want %s", got, want)
+	}
+
+	// Hooks are optional.
+	ctx = WithConnectTrace(context.Background(), &ConnectTrace{})
+	if _, err = d.DialContext(ctx, "tcp", l.Addr().String(), &ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		t.Errorf("DialContext() expected an error")
+	}
+}
@@ -3,6 +3,7 @@ package credstest
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/m-lab/reboot-service/creds"
 )
@@ -38,7 +39,7 @@ func (p *FakeProvider) FindCredentials(ctx context.Context,
 		return cred, nil
 	}
 
-	return nil, errors.New("hostname not found")
+	return nil, fmt.Errorf("%w: %s", creds.ErrNotFound, host)
 }
 
 // AddCredentials adds a Credentials to the map.
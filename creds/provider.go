@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"cloud.google.com/go/datastore"
 	"github.com/apex/log"
@@ -13,6 +14,10 @@ import (
 
 const kind = "Credentials"
 
+// ErrNotFound is returned by Provider.FindCredentials when there are no
+// Credentials for the requested hostname.
+var ErrNotFound = errors.New("credentials not found")
+
 // Credentials is a struct holding the credentials for a given hostname,
 // plus some additional metadata such as the IP address and the model (DRAC
 // or otherwise).
@@ -36,13 +41,23 @@ func (c *Credentials) String() string {
 	return buf.String()
 }
 
+// DialAddress returns the address to connect to: the stored Address if
+// any, or else the Hostname, to be resolved via DNS.
+func (c *Credentials) DialAddress() string {
+	if c.Address != "" {
+		return c.Address
+	}
+	return c.Hostname
+}
+
 // Provider is a Credentials provider.
 type Provider interface {
 
 	// ListCredentials lists all the existing Credentials on this Provider.
 	ListCredentials(context.Context) ([]*Credentials, error)
 
-	// FindCredentials gets an existing Credentials from this Provider.
+	// FindCredentials gets an existing Credentials from this Provider. If
+	// there is none, the error wraps ErrNotFound.
 	FindCredentials(context.Context, string) (*Credentials, error)
 
 	// AddCredentials creates a new Credentials entity on this Provider.
@@ -108,7 +123,7 @@ func (d *datastoreProvider) FindCredentials(ctx context.Context, host string) (*
 	}
 
 	if len(creds) == 0 {
-		return nil, errors.New("Hostname not found in Datastore")
+		return nil, fmt.Errorf("%w: hostname %s not in Datastore", ErrNotFound, host)
 	}
 
 	cred := creds[0]
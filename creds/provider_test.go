@@ -172,8 +172,8 @@ func TestFindCredentials(t *testing.T) {
 	// FindCredentials() should fail if there is no result for a known host.
 	mc.skipAppend = true
 	_, err = provider.FindCredentials(context.Background(), "testhost")
-	if err == nil {
-		t.Errorf("FindCredentials() expected error, got nil.")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindCredentials() expected ErrNotFound, got %v", err)
 	}
 	mc.skipAppend = false
 
@@ -290,3 +290,17 @@ func Test_datastoreProvider_Close(t *testing.T) {
 		t.Errorf("Close() expected error, got nil.")
 	}
 }
+
+func TestCredentials_DialAddress(t *testing.T) {
+	c := &Credentials{
+		Hostname: "mlab1d.lga0t.measurement-lab.org",
+		Address:  "192.168.0.1",
+	}
+	if got := c.DialAddress(); got != "192.168.0.1" {
+		t.Errorf("DialAddress() = %s, want the stored address", got)
+	}
+	c.Address = ""
+	if got := c.DialAddress(); got != "mlab1d.lga0t.measurement-lab.org" {
+		t.Errorf("DialAddress() = %s, want the hostname", got)
+	}
+}
@@ -0,0 +1,91 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/m-lab/reboot-service/creds"
+)
+
+// Sources of the address dialed by a probe, as in the source label of
+// reboot_e2e_address_info.
+const (
+	addressCredentials = "credentials"
+	addressDNS         = "dns"
+	addressOverride    = "override"
+)
+
+// dnsTimeout bounds the DNS lookups comparing stored addresses with DNS.
+const dnsTimeout = 5 * time.Second
+
+// lookupHost resolves hostname with the configured lookup function, or with
+// the default resolver.
+func (c *collectorConfig) lookupHost(ctx context.Context, hostname string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	if c.lookup != nil {
+		return c.lookup(ctx, hostname)
+	}
+	return net.DefaultResolver.LookupHost(ctx, hostname)
+}
+
+// dialAddress returns the address to dial to probe the BMC with the given
+// credentials, and its source. As for reboots, the stored address is
+// preferred to the hostname. A non-empty override takes precedence.
+func dialAddress(credentials *creds.Credentials, override string) (string, string) {
+	switch {
+	case override != "":
+		return override, addressOverride
+	case credentials.Address != "":
+		return credentials.Address, addressCredentials
+	}
+	return credentials.Hostname, addressDNS
+}
+
+// checkOverride returns an error unless address is one of the known
+// addresses of the BMC with the given credentials: its hostname, its stored
+// address, or any address its hostname resolves to. Dialing any other
+// address would send the BMC's credentials to whoever answers.
+func (c *collectorConfig) checkOverride(ctx context.Context,
+	credentials *creds.Credentials, address string) error {
+
+	if address == credentials.Hostname || sameAddress(address, credentials.Address) {
+		return nil
+	}
+	addrs, err := c.lookupHost(ctx, credentials.Hostname)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s to check address %s: %v",
+			credentials.Hostname, address, err)
+	}
+	if containsAddress(addrs, address) {
+		return nil
+	}
+	return fmt.Errorf("%s is neither the stored address of %s nor one it resolves to",
+		address, credentials.Hostname)
+}
+
+// containsAddress reports whether addrs contains address.
+func containsAddress(addrs []string, address string) bool {
+	for _, a := range addrs {
+		if sameAddress(a, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameAddress reports whether a and b are the same non-empty address,
+// comparing IP addresses regardless of their notation.
+func sameAddress(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return a == b
+}
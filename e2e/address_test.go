@@ -0,0 +1,150 @@
+package e2e
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// fakeLookup resolves the test BMCs with mlab1d in their hostname to
+// 192.168.0.1, and fails for any other hostname.
+func fakeLookup(ctx context.Context, hostname string) ([]string, error) {
+	if strings.HasPrefix(hostname, "mlab1d") {
+		return []string{"192.168.0.1"}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func Test_dialAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials *creds.Credentials
+		override    string
+		address     string
+		source      string
+	}{
+		{
+			name: "stored",
+			credentials: &creds.Credentials{
+				Hostname: "mlab1d.abc0t.measurement-lab.org",
+				Address:  "192.168.0.1",
+			},
+			address: "192.168.0.1",
+			source:  addressCredentials,
+		},
+		{
+			name: "dns",
+			credentials: &creds.Credentials{
+				Hostname: "mlab1d.abc0t.measurement-lab.org",
+			},
+			address: "mlab1d.abc0t.measurement-lab.org",
+			source:  addressDNS,
+		},
+		{
+			name: "override",
+			credentials: &creds.Credentials{
+				Hostname: "mlab1d.abc0t.measurement-lab.org",
+				Address:  "192.168.0.1",
+			},
+			override: "mlab1d.abc0t.measurement-lab.org",
+			address:  "mlab1d.abc0t.measurement-lab.org",
+			source:   addressOverride,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, source := dialAddress(tt.credentials, tt.override)
+			if address != tt.address || source != tt.source {
+				t.Errorf("dialAddress() = %s, %s, want %s, %s", address, source,
+					tt.address, tt.source)
+			}
+		})
+	}
+}
+
+func Test_collectorConfig_checkOverride(t *testing.T) {
+	config := &collectorConfig{lookup: fakeLookup}
+	credentials := &creds.Credentials{
+		Hostname: "mlab1d.abc0t.measurement-lab.org",
+		Address:  "192.168.0.2",
+	}
+	allowed := []string{
+		"mlab1d.abc0t.measurement-lab.org",
+		"192.168.0.2",
+		"192.168.0.1",
+		"::ffff:192.168.0.1",
+	}
+	for _, address := range allowed {
+		if err := config.checkOverride(context.Background(), credentials, address); err != nil {
+			t.Errorf("checkOverride(%s) unexpected error: %v", address, err)
+		}
+	}
+	for _, address := range []string{"10.0.0.1", "example.com"} {
+		if err := config.checkOverride(context.Background(), credentials, address); err == nil {
+			t.Errorf("checkOverride(%s) expected an error", address)
+		}
+	}
+
+	// If the hostname can't be resolved, only the stored address is allowed.
+	credentials.Hostname = "mlab2d.abc0t.measurement-lab.org"
+	if err := config.checkOverride(context.Background(), credentials, "192.168.0.1"); err == nil {
+		t.Errorf("checkOverride() expected an error")
+	}
+}
+
+func Test_e2eTestCollector_probe_address(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t.measurement-lab.org",
+			Address:  "192.168.0.2",
+		})
+	config := &collectorConfig{
+		bmcPort:   806,
+		connector: &mockConnector{},
+		provider:  provider,
+		lookup:    fakeLookup,
+	}
+	collector := newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+	expected := `# HELP reboot_e2e_address_info Address dialed by the last e2e test for this target, and where it comes from
+# TYPE reboot_e2e_address_info gauge
+reboot_e2e_address_info{address="192.168.0.2",source="credentials",target="mlab1d.abc0t.measurement-lab.org"} 1
+# HELP reboot_e2e_address_dns_mismatch Whether the stored address of this target is not one its hostname resolves to
+# TYPE reboot_e2e_address_dns_mismatch gauge
+reboot_e2e_address_dns_mismatch{target="mlab1d.abc0t.measurement-lab.org"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"reboot_e2e_address_info", "reboot_e2e_address_dns_mismatch")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+
+	// When the stored address matches DNS, there's no mismatch.
+	provider.AddCredentials(context.Background(),
+		"mlab1d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t.measurement-lab.org",
+			Address:  "192.168.0.1",
+		})
+	r := collector.probe(context.Background())
+	if r.AddressMatchesDNS == nil || !*r.AddressMatchesDNS {
+		t.Errorf("probe() AddressMatchesDNS = %v, want true", r.AddressMatchesDNS)
+	}
+
+	// When DNS fails, whether the address matches is unknown.
+	provider.AddCredentials(context.Background(),
+		"mlab2d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab2d.abc0t.measurement-lab.org",
+			Address:  "192.168.0.3",
+		})
+	collector = newE2ETestCollector(context.Background(), "mlab2d.abc0t.measurement-lab.org", config)
+	r = collector.probe(context.Background())
+	if r.AddressMatchesDNS != nil || r.Reason != reasonSuccess {
+		t.Errorf("probe() = %+v, want a success with no DNS check", r)
+	}
+}
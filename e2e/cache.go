@@ -0,0 +1,141 @@
+package e2e
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricCacheLookups = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_e2e_cache_lookups_total",
+		Help: "Total number of e2e results looked up in the cache, by outcome",
+	},
+	[]string{
+		"result",
+	},
+)
+
+// Outcomes of a cache lookup, as in the result label of
+// reboot_e2e_cache_lookups_total.
+const (
+	cacheHit    = "hit"
+	cacheMiss   = "miss"
+	cacheBypass = "bypass"
+)
+
+// CacheConfig is the configuration of the cache of e2e results.
+type CacheConfig struct {
+	// Capacity is the maximum number of cached results.
+	Capacity int
+	// SuccessTTL is how long a successful result is cached.
+	SuccessTTL time.Duration
+	// FailureTTL is how long a failed result is cached. It doubles at every
+	// consecutive failure of the same target, up to MaxFailureTTL. There is
+	// no backoff if MaxFailureTTL isn't greater than FailureTTL.
+	FailureTTL    time.Duration
+	MaxFailureTTL time.Duration
+}
+
+// resultCache caches the results of e2e tests, with different TTLs for
+// successes and failures, so that a target that recovers is noticed
+// quickly while one that keeps failing isn't probed over and over.
+type resultCache struct {
+	config *CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	result  Result
+	expires time.Time
+	// failures is the number of consecutive failed results. It's kept once
+	// the entry expires, to back off from targets that keep failing.
+	failures int
+}
+
+func newResultCache(config *CacheConfig) *resultCache {
+	return &resultCache{
+		config:  config,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// get returns the result cached for key, if it hasn't expired at now. Its
+// Time is when it was cached.
+func (c *resultCache) get(key string, now time.Time) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return Result{}, false
+	}
+	return e.result, true
+}
+
+// put caches r for key from now on, for a duration depending on whether
+// it's a success and on how many times in a row key has failed.
+func (c *resultCache) put(key string, r Result, now time.Time) {
+	if c.config.Capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		if len(c.entries) >= c.config.Capacity {
+			c.evict(now)
+		}
+		e = &cacheEntry{}
+		c.entries[key] = e
+	}
+	e.result = r
+	e.result.Time = now
+	if r.Reason == reasonSuccess {
+		e.failures = 0
+		e.expires = now.Add(c.config.SuccessTTL)
+		return
+	}
+	e.failures++
+	e.expires = now.Add(c.failureTTL(e.failures))
+}
+
+// failureTTL returns how long a result is cached after the given number of
+// consecutive failures.
+func (c *resultCache) failureTTL(failures int) time.Duration {
+	ttl := c.config.FailureTTL
+	for i := 1; i < failures && ttl < c.config.MaxFailureTTL; i++ {
+		ttl *= 2
+	}
+	if ttl > c.config.MaxFailureTTL && c.config.MaxFailureTTL >= c.config.FailureTTL {
+		ttl = c.config.MaxFailureTTL
+	}
+	return ttl
+}
+
+// evict removes the expired entries or, if there are none, the one that
+// expires first. c.mu must be held.
+func (c *resultCache) evict(now time.Time) {
+	var (
+		oldest    string
+		oldestExp time.Time
+	)
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == "" || e.expires.Before(oldestExp) {
+			oldest, oldestExp = key, e.expires
+		}
+	}
+	if len(c.entries) >= c.config.Capacity {
+		delete(c.entries, oldest)
+	}
+}
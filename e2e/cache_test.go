@@ -0,0 +1,95 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_resultCache(t *testing.T) {
+	c := newResultCache(&CacheConfig{
+		Capacity:      10,
+		SuccessTTL:    time.Hour,
+		FailureTTL:    time.Minute,
+		MaxFailureTTL: 5 * time.Minute,
+	})
+	now := time.Now()
+
+	if _, ok := c.get("bmc/target", now); ok {
+		t.Errorf("get() returned a result from an empty cache")
+	}
+
+	// Successes are cached for SuccessTTL.
+	c.put("bmc/target", Result{Reason: reasonSuccess}, now)
+	if r, ok := c.get("bmc/target", now.Add(59*time.Minute)); !ok ||
+		r.Reason != reasonSuccess || !r.Time.Equal(now) {
+		t.Errorf("get() = %+v, %t, want a success cached at %v", r, ok, now)
+	}
+	if _, ok := c.get("bmc/target", now.Add(time.Hour)); ok {
+		t.Errorf("get() returned an expired success")
+	}
+
+	// Failures are cached for FailureTTL, doubling at every consecutive
+	// failure up to MaxFailureTTL.
+	for _, ttl := range []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute,
+	} {
+		c.put("bmc/target", Result{Reason: reasonTimeout}, now)
+		if _, ok := c.get("bmc/target", now.Add(ttl-time.Second)); !ok {
+			t.Errorf("get() did not return a failure cached for %v", ttl)
+		}
+		if _, ok := c.get("bmc/target", now.Add(ttl)); ok {
+			t.Errorf("get() returned a failure cached for more than %v", ttl)
+		}
+	}
+
+	// A success resets the backoff.
+	c.put("bmc/target", Result{Reason: reasonSuccess}, now)
+	c.put("bmc/target", Result{Reason: reasonTimeout}, now)
+	if _, ok := c.get("bmc/target", now.Add(time.Minute)); ok {
+		t.Errorf("get() returned a failure cached for more than FailureTTL")
+	}
+}
+
+func Test_resultCache_failureTTL(t *testing.T) {
+	// There is no backoff unless MaxFailureTTL is greater than FailureTTL.
+	c := newResultCache(&CacheConfig{FailureTTL: time.Minute})
+	if ttl := c.failureTTL(5); ttl != time.Minute {
+		t.Errorf("failureTTL() = %v, want %v", ttl, time.Minute)
+	}
+}
+
+func Test_resultCache_capacity(t *testing.T) {
+	c := newResultCache(&CacheConfig{
+		Capacity:   2,
+		SuccessTTL: time.Hour,
+		FailureTTL: time.Minute,
+	})
+	now := time.Now()
+
+	c.put("bmc/a", Result{Reason: reasonSuccess}, now)
+	c.put("bmc/b", Result{Reason: reasonTimeout}, now)
+	c.put("bmc/c", Result{Reason: reasonSuccess}, now)
+
+	// The entry expiring first is evicted.
+	if _, ok := c.get("bmc/b", now); ok {
+		t.Errorf("get() returned an evicted result")
+	}
+	for _, key := range []string{"bmc/a", "bmc/c"} {
+		if _, ok := c.get(key, now); !ok {
+			t.Errorf("get(%s) did not return a cached result", key)
+		}
+	}
+
+	// Expired entries are evicted first.
+	c.put("bmc/d", Result{Reason: reasonSuccess}, now.Add(2*time.Hour))
+	if len(c.entries) != 1 {
+		t.Errorf("cache has %d entries, want 1", len(c.entries))
+	}
+
+	// Nothing is cached with no capacity.
+	c = newResultCache(&CacheConfig{SuccessTTL: time.Hour})
+	c.put("bmc/a", Result{Reason: reasonSuccess}, now)
+	if _, ok := c.get("bmc/a", now); ok {
+		t.Errorf("get() returned a result with no capacity")
+	}
+}
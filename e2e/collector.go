@@ -2,6 +2,7 @@ package e2e
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,82 +10,371 @@ import (
 	"github.com/m-lab/reboot-service/connector"
 	"github.com/m-lab/reboot-service/creds"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const (
 	reasonSuccess          = "success"
 	reasonCredsNotFound    = "credentials_not_found"
+	reasonCredsError       = "credentials_error"
 	reasonConnectionFailed = "connection_failed"
+	reasonHostKeyMismatch  = "host_key_mismatch"
+	reasonUnknownModel     = "unknown_model"
+	reasonDNSFailed        = "dns_failed"
+	reasonConnRefused      = "connection_refused"
+	reasonTimeout          = "timeout"
+	reasonAuthRejected     = "auth_rejected"
+	reasonCircuitOpen      = "circuit_open"
+	reasonCommandFailed    = "command_failed"
 
-	// Timeout for the e2e test must be shorter than Prometheus' timeout.
+	// Timeouts for the e2e test must add up to less than Prometheus'
+	// timeout. The connection timeout includes retries.
 	connectionTimeout = 45 * time.Second
+	commandTimeout    = 10 * time.Second
+
+	// versionCommand is the harmless command run on DRACs once connected,
+	// since authentication can succeed while racadm is broken.
+	versionCommand = "racadm getversion"
+
+	// maxRetryAttempts is the maximum number of attempts a request can ask
+	// for.
+	maxRetryAttempts = 10
+)
+
+var metricAttempts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_e2e_attempts_total",
+		Help: "Total number of attempts at connecting to BMCs for e2e tests",
+	},
+	[]string{
+		"status",
+	},
 )
 
+// versionKeys are the keys of the firmware version in the output of
+// versionCommand, depending on the iDRAC generation.
+var versionKeys = []string{"iDRAC Version", "RAC Version", "Firmware Version"}
+
 type collectorConfig struct {
 	bmcPort   int32
+	retry     connector.RetryPolicy
 	provider  creds.Provider
 	connector connector.Connector
+
+	// lookup resolves hostnames. If nil, the default resolver is used.
+	lookup func(ctx context.Context, hostname string) ([]string, error)
+
+	// host is the configuration of the host module, if enabled.
+	host *HostConfig
 }
 
 type e2eTestCollector struct {
-	target       string
-	config       *collectorConfig
-	resultMetric *prometheus.Desc
+	target  string
+	config  *collectorConfig
+	metrics *resultDescs
+
+	// probeSuccess and probeDuration are reported as by blackbox_exporter,
+	// so that its dashboards can be used.
+	probeSuccess  *prometheus.Desc
+	probeDuration *prometheus.Desc
+
+	// ctx is the context of the request the collector serves. Collect
+	// gives up probing the target when it's done.
+	ctx context.Context
+	// result, if not nil, is reported instead of probing the target.
+	result *Result
+	// address, if not empty, is dialed instead of the target's address.
+	address string
 }
 
-func newE2ETestCollector(target string, config *collectorConfig) *e2eTestCollector {
+func newE2ETestCollector(ctx context.Context, target string,
+	config *collectorConfig) *e2eTestCollector {
+
 	return &e2eTestCollector{
-		target: target,
-		config: config,
-		resultMetric: prometheus.NewDesc("reboot_e2e_success",
+		ctx:     ctx,
+		target:  target,
+		config:  config,
+		metrics: newResultDescs(),
+		probeSuccess: prometheus.NewDesc("probe_success",
+			"Whether the e2e test succeeded", nil, nil),
+		probeDuration: prometheus.NewDesc("probe_duration_seconds",
+			"How long the e2e test took to complete in seconds", nil, nil),
+	}
+}
+
+// resultDescs are the descriptions of the metrics reporting a Result.
+type resultDescs struct {
+	success     *prometheus.Desc
+	info        *prometheus.Desc
+	duration    *prometheus.Desc
+	address     *prometheus.Desc
+	dnsMismatch *prometheus.Desc
+}
+
+func newResultDescs() *resultDescs {
+	return &resultDescs{
+		success: prometheus.NewDesc("reboot_e2e_success",
 			"E2E test result for this target", []string{"target", "reason"},
 			nil),
+		info: prometheus.NewDesc("reboot_e2e_bmc_info",
+			"Firmware version of this target, as reported by its last e2e test",
+			[]string{"target", "firmware_version"}, nil),
+		duration: prometheus.NewDesc("reboot_e2e_duration_seconds",
+			"Duration of each phase of the last e2e test for this target",
+			[]string{"target", "phase"}, nil),
+		address: prometheus.NewDesc("reboot_e2e_address_info",
+			"Address dialed by the last e2e test for this target, and where it comes from",
+			[]string{"target", "address", "source"}, nil),
+		dnsMismatch: prometheus.NewDesc("reboot_e2e_address_dns_mismatch",
+			"Whether the stored address of this target is not one its hostname resolves to",
+			[]string{"target"}, nil),
 	}
 }
 
+func (d *resultDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.success
+	ch <- d.info
+	ch <- d.duration
+	ch <- d.address
+	ch <- d.dnsMismatch
+}
+
+// collect sends the metrics for r to ch: reboot_e2e_success,
+// reboot_e2e_duration_seconds for every phase the probe reached, and the
+// other metrics if their values are known.
+func (d *resultDescs) collect(ch chan<- prometheus.Metric, r *Result) {
+	ch <- prometheus.MustNewConstMetric(d.success, prometheus.GaugeValue,
+		successValue(r), r.Target, r.Reason)
+	if r.Version != "" {
+		ch <- prometheus.MustNewConstMetric(d.info, prometheus.GaugeValue, 1,
+			r.Target, r.Version)
+	}
+	for phase, duration := range r.Phases {
+		ch <- prometheus.MustNewConstMetric(d.duration, prometheus.GaugeValue,
+			duration.Seconds(), r.Target, phase)
+	}
+	if r.Address != "" {
+		ch <- prometheus.MustNewConstMetric(d.address, prometheus.GaugeValue, 1,
+			r.Target, r.Address, r.AddressSource)
+	}
+	if r.AddressMatchesDNS != nil {
+		mismatch := 0.0
+		if !*r.AddressMatchesDNS {
+			mismatch = 1
+		}
+		ch <- prometheus.MustNewConstMetric(d.dnsMismatch, prometheus.GaugeValue,
+			mismatch, r.Target)
+	}
+}
+
+// successValue returns 1 if r is a success, and 0 otherwise.
+func successValue(r *Result) float64 {
+	if r.Reason == reasonSuccess {
+		return 1
+	}
+	return 0
+}
+
 func (c *e2eTestCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.resultMetric
+	c.metrics.describe(ch)
+	ch <- c.probeSuccess
+	ch <- c.probeDuration
 }
 
 func (c *e2eTestCollector) Collect(ch chan<- prometheus.Metric) {
+	result := c.result
+	if result == nil {
+		r := c.probe(c.ctx)
+		result = &r
+	}
+	c.metrics.collect(ch, result)
+	ch <- prometheus.MustNewConstMetric(c.probeSuccess, prometheus.GaugeValue,
+		successValue(result))
+	ch <- prometheus.MustNewConstMetric(c.probeDuration, prometheus.GaugeValue,
+		result.Duration.Seconds())
+}
+
+// probe tests the connection to the target BMC. The returned Result has no
+// Time.
+func (c *e2eTestCollector) probe(ctx context.Context) Result {
+	trace := newProbeTrace()
+
 	// Get credentials for this BMC using the configured provider.
-	creds, err := c.getCredentials(c.target)
+	trace.logf("Looking up credentials for %s", c.target)
+	start := time.Now()
+	credentials, err := c.getCredentials(ctx, c.target)
+	trace.phase(phaseCredentials, start)
 	if err != nil {
 		log.Errorf("Error while getting credentials for %s: %v", c.target, err)
-		ch <- prometheus.MustNewConstMetric(c.resultMetric,
-			prometheus.GaugeValue, 0, c.target, reasonCredsNotFound)
-		return
+		trace.logf("Cannot get credentials: %v", err)
+		if errors.Is(err, creds.ErrNotFound) {
+			return trace.result(Result{Target: c.target, Reason: reasonCredsNotFound})
+		}
+		return trace.result(Result{Target: c.target, Reason: reasonCredsError})
+	}
+	return c.probeTraced(ctx, trace, credentials)
+}
+
+// probeWith is like probe, with the given credentials.
+func (c *e2eTestCollector) probeWith(ctx context.Context,
+	credentials *creds.Credentials) Result {
+
+	return c.probeTraced(ctx, newProbeTrace(), credentials)
+}
+
+// probeTraced is like probeWith, recording the phases and steps of the
+// probe in trace.
+func (c *e2eTestCollector) probeTraced(ctx context.Context, trace *probeTrace,
+	credentials *creds.Credentials) Result {
+
+	result := Result{Target: c.target}
+	result.Address, result.AddressSource = dialAddress(credentials, c.address)
+	if credentials.Address != "" {
+		result.AddressMatchesDNS = c.checkDNS(ctx, trace, credentials)
 	}
 
 	// We've got credentials, let's try to SSH.
+	trace.logf("Dialing %s (address from %s)", result.Address, result.AddressSource)
 	config := &connector.ConnectionConfig{
 		ConnType: connector.BMCConnection,
-		Hostname: c.target,
+		Hostname: result.Address,
 		Port:     c.config.bmcPort,
-		Username: creds.Username,
-		Password: creds.Password,
+		Username: credentials.Username,
+		Password: credentials.Password,
+		Model:    credentials.Model,
 		Timeout:  connectionTimeout,
 	}
-	conn, err := c.config.connector.NewConnection(config)
+	conn, err := c.config.connect(ctx, trace, c.target, config)
+	if err != nil {
+		log.Errorf("Error while creating connection to %s (%s): %v", c.target,
+			result.Address, err)
+		result.Reason = connectErrorReason(err)
+		trace.logf("Connection failed (%s): %v", result.Reason, err)
+		return trace.result(result)
+	}
+	defer conn.Close()
+	trace.logf("Connected")
+
+	result.Reason = reasonSuccess
+	version, err := c.firmwareVersion(ctx, trace, conn)
+	switch {
+	case errors.Is(err, connector.ErrNotSupported):
+		// Connecting is all that can be tested without racadm.
+		trace.logf("This BMC doesn't support racadm commands")
+	case err != nil:
+		log.Errorf("Error while running %q on %s: %v", versionCommand, c.target, err)
+		trace.logf("Command failed: %v", err)
+		result.Reason = reasonCommandFailed
+	default:
+		trace.logf("Firmware version: %s", version)
+		result.Version = version
+	}
+	return trace.result(result)
+}
+
+// checkDNS reports whether the stored address in credentials is one of the
+// addresses its hostname resolves to, or returns nil if it can't be told.
+func (c *e2eTestCollector) checkDNS(ctx context.Context, trace *probeTrace,
+	credentials *creds.Credentials) *bool {
+
+	addrs, err := c.config.lookupHost(ctx, credentials.Hostname)
+	if err != nil {
+		trace.logf("Cannot resolve %s: %v", credentials.Hostname, err)
+		return nil
+	}
+	match := containsAddress(addrs, credentials.Address)
+	if !match {
+		log.Warnf("Stored address of %s (%s) doesn't match DNS: %v",
+			credentials.Hostname, credentials.Address, addrs)
+		trace.logf("Stored address %s doesn't match DNS: %v", credentials.Address, addrs)
+	}
+	return &match
+}
+
+// firmwareVersion runs versionCommand via conn and returns the firmware
+// version it reports.
+func (c *e2eTestCollector) firmwareVersion(ctx context.Context, trace *probeTrace,
+	conn connector.Connection) (string, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	trace.logf("Running %q", versionCommand)
+	start := time.Now()
+	output, err := connector.ExecDRACShellContext(ctx, conn, versionCommand)
+	trace.phase(phaseCommand, start)
+	if err != nil {
+		return "", err
+	}
+	values, err := connector.ParseRACADMOutput(output)
 	if err != nil {
-		// TODO: here we should be able to distinguish different errors.
-		log.Errorf("Error while creating connection to %s: %v", c.target, err)
-		ch <- prometheus.MustNewConstMetric(c.resultMetric,
-			prometheus.GaugeValue, 0, c.target, reasonConnectionFailed)
-		return
+		return "", err
 	}
+	for _, key := range versionKeys {
+		if version := values[key]; version != "" {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("no firmware version in output: %q", output)
+}
 
-	// TODO: execute a no-op command?
-	conn.Close()
+// connect creates a connection to target with config, retrying transient
+// failures within connectionTimeout.
+func (c *collectorConfig) connect(ctx context.Context, trace *probeTrace,
+	target string, config *connector.ConnectionConfig) (connector.Connection, error) {
 
-	ch <- prometheus.MustNewConstMetric(c.resultMetric, prometheus.GaugeValue,
-		1, c.target, reasonSuccess)
+	ctx, cancel := context.WithTimeout(ctx, connectionTimeout)
+	defer cancel()
+	ctx = connector.WithConnectTrace(ctx, trace.connectTrace())
+
+	var conn connector.Connection
+	err := c.retry.Retry(ctx, connector.IsTransientConnectError,
+		func(attempt int) error {
+			var err error
+			conn, err = connector.NewConnectionContext(ctx, c.connector, config)
+			switch {
+			case err == nil:
+				metricAttempts.WithLabelValues("ok").Inc()
+			case connector.IsTransientConnectError(err):
+				metricAttempts.WithLabelValues("error-transient").Inc()
+				log.Warnf("Attempt %d at connecting to %s failed: %v", attempt,
+					target, err)
+				trace.logf("Attempt %d at connecting failed: %v", attempt, err)
+			default:
+				metricAttempts.WithLabelValues("error").Inc()
+			}
+			return err
+		})
+	return conn, err
 }
 
-func (c *e2eTestCollector) getCredentials(hostname string) (*creds.Credentials, error) {
-	creds, err := c.config.provider.FindCredentials(context.Background(), hostname)
+// connectErrorReason returns the reason label for a connection error.
+func connectErrorReason(err error) string {
+	switch {
+	case errors.Is(err, connector.ErrHostKeyMismatch):
+		return reasonHostKeyMismatch
+	case errors.Is(err, connector.ErrUnknownModel):
+		return reasonUnknownModel
+	case errors.Is(err, connector.ErrCircuitOpen):
+		return reasonCircuitOpen
+	case errors.Is(err, connector.ErrDNS):
+		return reasonDNSFailed
+	case errors.Is(err, connector.ErrConnectionRefused):
+		return reasonConnRefused
+	case errors.Is(err, connector.ErrTimeout):
+		return reasonTimeout
+	case errors.Is(err, connector.ErrAuthRejected):
+		return reasonAuthRejected
+	}
+	return reasonConnectionFailed
+}
+
+func (c *e2eTestCollector) getCredentials(ctx context.Context,
+	hostname string) (*creds.Credentials, error) {
+
+	creds, err := c.config.provider.FindCredentials(ctx, hostname)
 	if err != nil {
-		return nil, fmt.Errorf("Cannot retrieve credentials: %v", err)
+		return nil, fmt.Errorf("Cannot retrieve credentials: %w", err)
 	}
 
 	return creds, nil
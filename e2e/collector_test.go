@@ -3,44 +3,110 @@ package e2e
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	"github.com/m-lab/reboot-service/connector"
 	"github.com/m-lab/reboot-service/creds/credstest"
 
 	"github.com/m-lab/reboot-service/creds"
-	"github.com/prometheus/client_golang/prometheus"
 )
 
+// mockVersionOutput is the output of versionCommand on an iDRAC8.
+const mockVersionOutput = `Bios Version                     = 2.5.4
+iDRAC Version                    = 2.70.70.70
+Lifecycle Controller Version     = 2.70.70.70
+`
+
 // Mock structs for Connector and Connection interfaces.
 type mockConnector struct {
 	mustFail bool
+	err      error
+
+	// calls is the number of connections, which the prober can make
+	// concurrently.
+	mu    sync.Mutex
+	calls int
+	// config and conn are the last connection's config and Connection.
+	config *connector.ConnectionConfig
+	conn   *mockConnection
+
+	// shellOutput and shellErr are returned by ExecDRACShell. If both are
+	// empty, it returns mockVersionOutput.
+	shellOutput string
+	shellErr    error
 }
 
 type mockConnection struct {
-	mustFail bool
+	mustFail    bool
+	shellOutput string
+	shellErr    error
+
+	// commands is the number of commands run, including reboots.
+	commands int
+	closed   bool
 }
 
-func (connector *mockConnector) NewConnection(*connector.ConnectionConfig) (connector.Connection, error) {
+func (connector *mockConnector) NewConnection(config *connector.ConnectionConfig) (connector.Connection, error) {
+	connector.mu.Lock()
+	defer connector.mu.Unlock()
+	connector.calls++
+	connector.config = config
+	if connector.err != nil {
+		return nil, connector.err
+	}
 	if connector.mustFail {
 		return nil, errors.New("method NewConnection() failed")
 	}
-	return &mockConnection{}, nil
+	connector.conn = &mockConnection{
+		shellOutput: connector.shellOutput,
+		shellErr:    connector.shellErr,
+	}
+	return connector.conn, nil
 }
 
 func (connection *mockConnection) ExecDRACShell(string) (string, error) {
-	return "Not implemented", nil
+	connection.commands++
+	if connection.shellOutput == "" && connection.shellErr == nil {
+		return mockVersionOutput, nil
+	}
+	return connection.shellOutput, connection.shellErr
 }
 
 func (connection *mockConnection) Reboot() (string, error) {
+	connection.commands++
+	return "Not implemented", nil
+}
+
+func (connection *mockConnection) PowerOn() (string, error) {
 	return "Not implemented", nil
 }
 
+func (connection *mockConnection) PowerOff() (string, error) {
+	return "Not implemented", nil
+}
+
+func (connection *mockConnection) HardReset() (string, error) {
+	return "Not implemented", nil
+}
+
+func (connection *mockConnection) GracefulShutdown() (string, error) {
+	return "Not implemented", nil
+}
+
+func (connection *mockConnection) PowerStatus() (string, error) {
+	return connector.PowerStatusOn, nil
+}
+
 func (connection *mockConnection) Close() error {
+	connection.closed = true
 	return nil
 }
 
@@ -51,7 +117,7 @@ func Test_newE2ETestCollector(t *testing.T) {
 		provider:  credstest.NewProvider(),
 	}
 
-	collector := newE2ETestCollector("mlab1.abc0t.measurement-lab.org", config)
+	collector := newE2ETestCollector(context.Background(), "mlab1.abc0t.measurement-lab.org", config)
 	if collector == nil {
 		t.Errorf("newE2ETestCollector() returned nil.")
 	}
@@ -59,7 +125,7 @@ func Test_newE2ETestCollector(t *testing.T) {
 
 func Test_e2eTestCollector_Collect(t *testing.T) {
 	provider := credstest.NewProvider()
-	connector := &mockConnector{}
+	mockConnector := &mockConnector{}
 	provider.AddCredentials(context.Background(),
 		"mlab1d.abc0t.measurement-lab.org", &creds.Credentials{
 			Hostname: "mlab1d.abc0t.measurement-lab.org",
@@ -68,10 +134,10 @@ func Test_e2eTestCollector_Collect(t *testing.T) {
 		})
 	config := &collectorConfig{
 		bmcPort:   806,
-		connector: connector,
+		connector: mockConnector,
 		provider:  provider,
 	}
-	collector := newE2ETestCollector("mlab1d.abc0t.measurement-lab.org", config)
+	collector := newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
 
 	// Compare actual vs expected output in the "ok" case.
 	expMetadata := `# HELP reboot_e2e_success E2E test result for this target
@@ -80,9 +146,12 @@ func Test_e2eTestCollector_Collect(t *testing.T) {
 `
 	expMetric := `
 reboot_e2e_success{reason="` + reasonSuccess + `",target="mlab1d.abc0t.measurement-lab.org"} 1
+# HELP reboot_e2e_bmc_info Firmware version of this target, as reported by its last e2e test
+# TYPE reboot_e2e_bmc_info gauge
+reboot_e2e_bmc_info{firmware_version="2.70.70.70",target="mlab1d.abc0t.measurement-lab.org"} 1
 `
 	err := testutil.CollectAndCompare(collector, strings.NewReader(
-		expMetadata+expMetric))
+		expMetadata+expMetric), "reboot_e2e_success", "reboot_e2e_bmc_info")
 	if err != nil {
 		t.Errorf("CollectAndCompare() returned err: %v", err)
 	}
@@ -91,21 +160,49 @@ reboot_e2e_success{reason="` + reasonSuccess + `",target="mlab1d.abc0t.measureme
 	expMetric = `
 reboot_e2e_success{reason="` + reasonConnectionFailed + `",target="mlab1d.abc0t.measurement-lab.org"} 0
 `
-	connector.mustFail = true
-	collector = newE2ETestCollector("mlab1d.abc0t.measurement-lab.org", config)
+	mockConnector.mustFail = true
+	collector = newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
 	err = testutil.CollectAndCompare(collector, strings.NewReader(
-		expMetadata+expMetric))
+		expMetadata+expMetric), "reboot_e2e_success", "reboot_e2e_bmc_info")
 	if err != nil {
 		t.Errorf("CollectAndCompare() returned err: %v", err)
 	}
 
+	mockConnector.mustFail = false
+
+	// Compare actual vs expected output in the "host_key_mismatch" case.
+	expMetric = `
+reboot_e2e_success{reason="` + reasonHostKeyMismatch + `",target="mlab1d.abc0t.measurement-lab.org"} 0
+`
+	mockConnector.err = fmt.Errorf("%w: test", connector.ErrHostKeyMismatch)
+	collector = newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+	err = testutil.CollectAndCompare(collector, strings.NewReader(
+		expMetadata+expMetric), "reboot_e2e_success", "reboot_e2e_bmc_info")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+	mockConnector.err = nil
+
+	// Compare actual vs expected output in the "unknown_model" case.
+	expMetric = `
+reboot_e2e_success{reason="` + reasonUnknownModel + `",target="mlab1d.abc0t.measurement-lab.org"} 0
+`
+	mockConnector.err = fmt.Errorf("%w: test", connector.ErrUnknownModel)
+	collector = newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+	err = testutil.CollectAndCompare(collector, strings.NewReader(
+		expMetadata+expMetric), "reboot_e2e_success", "reboot_e2e_bmc_info")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+	mockConnector.err = nil
+
 	// Compare actual vs expected output in the "credentials_not_found" case.
 	expMetric = `
 reboot_e2e_success{reason="` + reasonCredsNotFound + `",target="mlab2d.abc0t.measurement-lab.org"} 0
 `
-	collector = newE2ETestCollector("mlab2d.abc0t.measurement-lab.org", config)
+	collector = newE2ETestCollector(context.Background(), "mlab2d.abc0t.measurement-lab.org", config)
 	err = testutil.CollectAndCompare(collector, strings.NewReader(
-		expMetadata+expMetric))
+		expMetadata+expMetric), "reboot_e2e_success", "reboot_e2e_bmc_info")
 	if err != nil {
 		t.Errorf("CollectAndCompare() returned err: %v", err)
 	}
@@ -114,9 +211,9 @@ reboot_e2e_success{reason="` + reasonCredsNotFound + `",target="mlab2d.abc0t.mea
 
 func Test_e2eTestCollector_getCredentials(t *testing.T) {
 	type fields struct {
-		target       string
-		config       *collectorConfig
-		resultMetric *prometheus.Desc
+		target  string
+		config  *collectorConfig
+		metrics *resultDescs
 	}
 	type args struct {
 		hostname string
@@ -133,11 +230,11 @@ func Test_e2eTestCollector_getCredentials(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &e2eTestCollector{
-				target:       tt.fields.target,
-				config:       tt.fields.config,
-				resultMetric: tt.fields.resultMetric,
+				target:  tt.fields.target,
+				config:  tt.fields.config,
+				metrics: tt.fields.metrics,
 			}
-			got, err := c.getCredentials(tt.args.hostname)
+			got, err := c.getCredentials(context.Background(), tt.args.hostname)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("e2eTestCollector.getCredentials() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -148,3 +245,236 @@ func Test_e2eTestCollector_getCredentials(t *testing.T) {
 		})
 	}
 }
+
+// ctxProvider records the context credentials are looked up with.
+type ctxProvider struct {
+	creds.Provider
+	ctx context.Context
+}
+
+func (p *ctxProvider) FindCredentials(ctx context.Context, hostname string) (*creds.Credentials, error) {
+	p.ctx = ctx
+	return p.Provider.FindCredentials(ctx, hostname)
+}
+
+func Test_e2eTestCollector_Collect_context(t *testing.T) {
+	provider := &ctxProvider{Provider: credstest.NewProvider()}
+	config := &collectorConfig{
+		bmcPort:   806,
+		connector: &mockConnector{},
+		provider:  provider,
+	}
+
+	// Collect probes the target within the collector's context.
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	collector := newE2ETestCollector(ctx, "mlab1d.abc0t.measurement-lab.org", config)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("Gather() returned err: %v", err)
+	}
+	if provider.ctx == nil || provider.ctx.Value(key{}) != "request" {
+		t.Errorf("Collect() did not use the collector's context")
+	}
+}
+
+func Test_connectErrorReason(t *testing.T) {
+	reasons := map[error]string{
+		errors.New("EOF"):              reasonConnectionFailed,
+		connector.ErrHostKeyMismatch:   reasonHostKeyMismatch,
+		connector.ErrUnknownModel:      reasonUnknownModel,
+		connector.ErrDNS:               reasonDNSFailed,
+		connector.ErrConnectionRefused: reasonConnRefused,
+		connector.ErrTimeout:           reasonTimeout,
+		connector.ErrAuthRejected:      reasonAuthRejected,
+		connector.ErrCircuitOpen:       reasonCircuitOpen,
+	}
+	for err, want := range reasons {
+		if got := connectErrorReason(fmt.Errorf("%w: test", err)); got != want {
+			t.Errorf("connectErrorReason(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+// failingProvider is a creds.Provider whose lookups always fail.
+type failingProvider struct {
+	creds.Provider
+}
+
+func (p *failingProvider) FindCredentials(context.Context, string) (*creds.Credentials, error) {
+	return nil, errors.New("datastore unavailable")
+}
+
+func (p *failingProvider) ListCredentials(context.Context) ([]*creds.Credentials, error) {
+	return nil, errors.New("datastore unavailable")
+}
+
+func Test_e2eTestCollector_Collect_credentialsError(t *testing.T) {
+	config := &collectorConfig{
+		bmcPort:   806,
+		connector: &mockConnector{},
+		provider:  &failingProvider{},
+	}
+	collector := newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+	expected := `# HELP reboot_e2e_success E2E test result for this target
+# TYPE reboot_e2e_success gauge
+reboot_e2e_success{reason="` + reasonCredsError + `",target="mlab1d.abc0t.measurement-lab.org"} 0
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"reboot_e2e_success")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+}
+
+func Test_e2eTestCollector_connect(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t.measurement-lab.org",
+		})
+	mockConnector := &mockConnector{
+		err: fmt.Errorf("%w: test", connector.ErrConnectionRefused),
+	}
+	config := &collectorConfig{
+		bmcPort:   806,
+		retry:     connector.RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+		connector: mockConnector,
+		provider:  provider,
+	}
+	collector := newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+	expected := `# HELP reboot_e2e_success E2E test result for this target
+# TYPE reboot_e2e_success gauge
+reboot_e2e_success{reason="` + reasonConnRefused + `",target="mlab1d.abc0t.measurement-lab.org"} 0
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"reboot_e2e_success")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+	if mockConnector.calls != 3 {
+		t.Errorf("connect() made %d attempts, want 3", mockConnector.calls)
+	}
+
+	// Permanent failures are not retried.
+	mockConnector.calls = 0
+	mockConnector.err = fmt.Errorf("%w: test", connector.ErrAuthRejected)
+	if _, err := config.connect(context.Background(), newProbeTrace(),
+		"mlab1d.abc0t.measurement-lab.org", &connector.ConnectionConfig{}); err == nil {
+		t.Errorf("connect() expected an error")
+	}
+	if mockConnector.calls != 1 {
+		t.Errorf("connect() made %d attempts, want 1", mockConnector.calls)
+	}
+}
+
+func Test_e2eTestCollector_probeWith_command(t *testing.T) {
+	tests := []struct {
+		name        string
+		shellOutput string
+		shellErr    error
+		want        Result
+	}{
+		{
+			name: "success",
+			want: Result{Reason: reasonSuccess, Version: "2.70.70.70"},
+		},
+		{
+			name:        "getsysinfo-style-output",
+			shellOutput: "RAC Information:\nFirmware Version        = 1.98\n",
+			want:        Result{Reason: reasonSuccess, Version: "1.98"},
+		},
+		{
+			name:        "racadm-error",
+			shellOutput: "ERROR: Unable to perform the requested action.\n",
+			want:        Result{Reason: reasonCommandFailed},
+		},
+		{
+			name:        "no-version",
+			shellOutput: "/admin1->\n",
+			want:        Result{Reason: reasonCommandFailed},
+		},
+		{
+			name:     "session-error",
+			shellErr: fmt.Errorf("%w: test", connector.ErrSession),
+			want:     Result{Reason: reasonCommandFailed},
+		},
+		{
+			name:     "not-supported",
+			shellErr: connector.ErrNotSupported,
+			want:     Result{Reason: reasonSuccess},
+		},
+		{
+			// As returned by drivers whose commands don't include racadm.
+			name:     "ilo",
+			shellErr: fmt.Errorf("%w: racadm commands on this BMC", connector.ErrNotSupported),
+			want:     Result{Reason: reasonSuccess},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &collectorConfig{
+				bmcPort: 806,
+				connector: &mockConnector{
+					shellOutput: tt.shellOutput,
+					shellErr:    tt.shellErr,
+				},
+				provider: credstest.NewProvider(),
+			}
+			c := newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+			got := c.probeWith(context.Background(), &creds.Credentials{
+				Hostname: "mlab1d.abc0t.measurement-lab.org",
+			})
+			if got.Reason != tt.want.Reason || got.Version != tt.want.Version {
+				t.Errorf("probeWith() = %+v, want %+v", got, tt.want)
+			}
+			if _, ok := got.Phases[phaseCommand]; !ok {
+				t.Errorf("probeWith() did not time the %s phase", phaseCommand)
+			}
+		})
+	}
+}
+
+func Test_e2eTestCollector_probe_phases(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t.measurement-lab.org",
+		})
+	config := &collectorConfig{
+		bmcPort:   806,
+		connector: &mockConnector{},
+		provider:  provider,
+	}
+	collector := newE2ETestCollector(context.Background(), "mlab1d.abc0t.measurement-lab.org", config)
+	r := collector.probe(context.Background())
+	for _, phase := range []string{phaseCredentials, phaseCommand} {
+		if _, ok := r.Phases[phase]; !ok {
+			t.Errorf("probe() did not time the %s phase", phase)
+		}
+	}
+	if len(r.Trace) == 0 || r.Duration <= 0 {
+		t.Errorf("probe() = %+v, want a trace and a duration", r)
+	}
+
+	// probe_success and probe_duration_seconds are reported as by
+	// blackbox_exporter.
+	expected := `# HELP probe_success Whether the e2e test succeeded
+# TYPE probe_success gauge
+probe_success 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"probe_success")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+
+	// Failures to get the credentials end the probe.
+	collector = newE2ETestCollector(context.Background(), "mlab2d.abc0t.measurement-lab.org", config)
+	r = collector.probe(context.Background())
+	if _, ok := r.Phases[phaseCommand]; ok || r.Reason != reasonCredsNotFound {
+		t.Errorf("probe() = %+v, want %s with no %s phase", r,
+			reasonCredsNotFound, phaseCommand)
+	}
+}
@@ -3,11 +3,15 @@
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
 
 	"github.com/apex/log"
 	"github.com/m-lab/go/host"
@@ -18,22 +22,54 @@ import (
 // Handler is the HTTP handler for /e2e
 type Handler struct {
 	bmcPort int32
+	retry   connector.RetryPolicy
+	prober  *Prober
+	host    *HostConfig
+	cache   *resultCache
 
 	connector connector.Connector
 	provider  creds.Provider
+
+	// lookup resolves hostnames. If nil, the default resolver is used.
+	lookup func(ctx context.Context, hostname string) ([]string, error)
 }
 
-// NewHandler returns a Handler with the specified configuration.
-func NewHandler(bmcPort int32, prov creds.Provider, connector connector.Connector) *Handler {
+// NewHandler returns a Handler with the specified configuration. Connections
+// to BMCs are retried according to retry.
+func NewHandler(bmcPort int32, prov creds.Provider, connector connector.Connector,
+	retry connector.RetryPolicy) *Handler {
 	return &Handler{
 		bmcPort:   bmcPort,
+		retry:     retry,
 		connector: connector,
 		provider:  prov,
 	}
 }
 
+// SetProber makes the Handler serve the results of prober's last round of
+// probes, rather than probing BMCs it has already probed.
+func (h *Handler) SetProber(prober *Prober) {
+	h.prober = prober
+}
+
+// SetCache makes the Handler cache the results of the probes it runs, as
+// configured by config.
+func (h *Handler) SetCache(config *CacheConfig) {
+	h.cache = newResultCache(config)
+}
+
+// SetHostConfig enables the host module, checking that the reboot user can
+// log into the target node rather than probing its BMC.
+func (h *Handler) SetHostConfig(config *HostConfig) {
+	h.host = config
+}
+
 // ServeHTTP handles GET requests to the /e2e endpoint, parsing the target
-// parameter and delegating writing the actual response to promhttp.
+// parameter and delegating writing the actual response to promhttp. With
+// debug=true, a log of the steps of the probe is written before the
+// metrics, as plain text. Results are cached if a cache has been set, unless
+// nocache=true. With module=host, the target is a node whose OS is probed
+// instead of its BMC.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -52,22 +88,165 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// we are reasonably sure this is not a valid M-Lab node's BMC.
 	bmcName, err := host.Parse(target)
 	if err != nil {
-		errStr := fmt.Sprintf(target)
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(errStr))
-		log.Errorf(errStr)
+		w.Write([]byte(target))
+		log.Error(target)
 		return
 	}
 
+	retry := h.retry
+	if r.URL.Query().Get("attempts") != "" {
+		attempts, err := strconv.Atoi(r.URL.Query().Get("attempts"))
+		if err != nil || attempts < 1 || attempts > maxRetryAttempts {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf(
+				"URL parameter 'attempts' must be between 1 and %d", maxRetryAttempts)))
+			return
+		}
+		retry.Attempts = attempts
+	}
+
+	debug := false
+	if r.URL.Query().Get("debug") != "" {
+		debug, err = strconv.ParseBool(r.URL.Query().Get("debug"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'debug' must be a boolean"))
+			return
+		}
+	}
+
+	nocache := false
+	if r.URL.Query().Get("nocache") != "" {
+		nocache, err = strconv.ParseBool(r.URL.Query().Get("nocache"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'nocache' must be a boolean"))
+			return
+		}
+	}
+
 	collectorConfig := &collectorConfig{
 		bmcPort:   h.bmcPort,
+		retry:     retry,
 		connector: h.connector,
 		provider:  h.provider,
+		lookup:    h.lookup,
+		host:      h.host,
+	}
+
+	target = bmcName.String()
+	var (
+		collector prometheus.Collector
+		result    Result
+	)
+	switch module := r.URL.Query().Get("module"); module {
+	case "", moduleBMC:
+		c := newE2ETestCollector(r.Context(), target, collectorConfig)
+
+		// Only the BMC's known addresses can be dialed instead of its
+		// address. If its credentials can't be found, the probe reports it.
+		// Results for other addresses are neither cached nor taken from the
+		// cache.
+		if address := r.URL.Query().Get("address"); address != "" {
+			credentials, err := h.provider.FindCredentials(r.Context(), target)
+			if err == nil {
+				err = collectorConfig.checkOverride(r.Context(), credentials, address)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(fmt.Sprintf("URL parameter 'address' is invalid: %v", err)))
+					return
+				}
+			}
+			c.address = address
+			result = c.probe(r.Context())
+		} else {
+			result = h.result(r.Context(), moduleBMC, target, nocache, c.probe)
+		}
+		c.result = &result
+		collector = c
+	case moduleHost:
+		if h.host == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Module 'host' is not enabled"))
+			return
+		}
+		if r.URL.Query().Get("address") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'address' is not supported by module 'host'"))
+			return
+		}
+		c := newHostCollector(r.Context(), target, collectorConfig)
+		result = h.result(r.Context(), moduleHost, target, nocache, c.probe)
+		c.result = &result
+		collector = c
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Unknown module %q", module)))
+		return
 	}
 
 	registry := prometheus.NewRegistry()
-	collector := newE2ETestCollector(bmcName.String(), collectorConfig)
 	registry.MustRegister(collector)
+	if debug {
+		writeDebug(w, registry, &result)
+		return
+	}
+
 	promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 	promHandler.ServeHTTP(w, r)
 }
+
+// result returns the last result of the background prober for target, or
+// its cached result, or else the result of probing it, which is then
+// cached. With nocache, target is always probed.
+func (h *Handler) result(ctx context.Context, module, target string, nocache bool,
+	probe func(context.Context) Result) Result {
+
+	if h.prober != nil && module == moduleBMC && !nocache {
+		if result, ok := h.prober.Result(target); ok {
+			return result
+		}
+	}
+	if h.cache == nil {
+		return probe(ctx)
+	}
+
+	key := module + "/" + target
+	if nocache {
+		metricCacheLookups.WithLabelValues(cacheBypass).Inc()
+	} else if result, ok := h.cache.get(key, time.Now()); ok {
+		metricCacheLookups.WithLabelValues(cacheHit).Inc()
+		return result
+	} else {
+		metricCacheLookups.WithLabelValues(cacheMiss).Inc()
+	}
+	result := probe(ctx)
+	h.cache.put(key, result, time.Now())
+	return result
+}
+
+// writeDebug writes the log of the probe that produced result, followed by
+// the metrics in registry, in the text exposition format.
+func writeDebug(w http.ResponseWriter, registry *prometheus.Registry,
+	result *Result) {
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Logs for the probe:")
+	if !result.Time.IsZero() {
+		fmt.Fprintf(w, "(result of an earlier probe, at %s)\n",
+			result.Time.UTC().Format(time.RFC3339))
+	}
+	for _, step := range result.Trace {
+		fmt.Fprintln(w, step)
+	}
+
+	fmt.Fprintln(w, "\nMetrics that would have been returned:")
+	families, err := registry.Gather()
+	if err != nil {
+		fmt.Fprintf(w, "Cannot gather metrics: %v\n", err)
+	}
+	for _, mf := range families {
+		expfmt.MetricFamilyToText(w, mf)
+	}
+}
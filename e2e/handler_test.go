@@ -7,14 +7,19 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/m-lab/reboot-service/connector"
 	"github.com/m-lab/reboot-service/creds"
 	"github.com/m-lab/reboot-service/creds/credstest"
 )
 
 func TestNewHandler(t *testing.T) {
-	handler := NewHandler(806, credstest.NewProvider(), &mockConnector{})
+	handler := NewHandler(806, credstest.NewProvider(), &mockConnector{},
+		connector.RetryPolicy{})
 	if handler == nil {
 		t.Errorf("NewHandler() returned nil.")
 	}
@@ -64,6 +69,28 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			req:    httptest.NewRequest("GET", "/v1/e2e?target=thisshouldfail", nil),
 			status: http.StatusBadRequest,
 		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&attempts=2", nil),
+			status: http.StatusOK,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&address=192.168.0.1", nil),
+			status: http.StatusOK,
+			body: `reboot_e2e_address_info{address="192.168.0.1",source="override",target="mlab1d-abc0t.mlab-sandbox.measurement-lab.org"} 1
+`,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&address=10.0.0.1", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&attempts=0", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&attempts=many", nil),
+			status: http.StatusBadRequest,
+		},
 	}
 
 	connector := &mockConnector{}
@@ -83,6 +110,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 		bmcPort:   806,
 		connector: connector,
 		provider:  provider,
+		lookup:    fakeLookup,
 	}
 
 	for _, test := range tests {
@@ -108,10 +136,145 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			if err != nil {
 				t.Errorf("ServeHTTP() - cannot read response: %v", err)
 			}
-			if string(body) != test.body {
-				t.Errorf("ServeHTTP() - expected response:\n%s\ngot:\n%s\n", string(body), test.body)
+			if !strings.Contains(string(body), test.body) {
+				t.Errorf("ServeHTTP() - expected response containing:\n%s\ngot:\n%s\n", test.body, string(body))
 			}
 		}
 	}
 
 }
+
+func TestHandler_ServeHTTP_debug(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+		})
+	h := NewHandler(806, provider, &mockConnector{}, connector.RetryPolicy{})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET",
+		"/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&debug=true", nil))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK ||
+		!strings.HasPrefix(body, "Logs for the probe:\n") ||
+		!strings.Contains(body, "Firmware version: 2.70.70.70") ||
+		!strings.Contains(body, "\nMetrics that would have been returned:\n") ||
+		!strings.Contains(body, "probe_success 1") {
+		t.Errorf("ServeHTTP() = %d:\n%s", rr.Code, body)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("ServeHTTP() returned Content-Type %q", ct)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET",
+		"/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&debug=maybe", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_ServeHTTP_host(t *testing.T) {
+	dir, err := ioutil.TempDir("", "e2e")
+	if err != nil {
+		t.Fatalf("TempDir() returned err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := "mlab1-abc0t.mlab-sandbox.measurement-lab.org"
+	h := NewHandler(806, credstest.NewProvider(), &mockConnector{}, connector.RetryPolicy{})
+
+	// The host module must be enabled.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/e2e?module=host&target="+target, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	h.SetHostConfig(&HostConfig{
+		SSHPort:        22,
+		RebootUser:     "reboot-api",
+		PrivateKeyPath: writePrivateKey(t, dir),
+	})
+	tests := []struct {
+		query  string
+		status int
+		body   string
+	}{
+		{
+			query:  "module=host&target=" + target,
+			status: http.StatusOK,
+			body: `reboot_e2e_host_success{reason="` + reasonSuccess +
+				`",target="` + target + `"} 1`,
+		},
+		{
+			query:  "module=host&debug=true&target=" + target,
+			status: http.StatusOK,
+			body:   "closed the connection without opening a session",
+		},
+		{
+			query:  "module=host&address=192.168.0.1&target=" + target,
+			status: http.StatusBadRequest,
+		},
+		{
+			query:  "module=ping&target=" + target,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/e2e?"+tt.query, nil))
+		if rr.Code != tt.status || !strings.Contains(rr.Body.String(), tt.body) {
+			t.Errorf("ServeHTTP(%s) = %d:\n%s\nwant %d containing %q", tt.query,
+				rr.Code, rr.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestHandler_ServeHTTP_cache(t *testing.T) {
+	target := "mlab1d-abc0t.mlab-sandbox.measurement-lab.org"
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(), target, &creds.Credentials{
+		Hostname: target,
+	})
+	mockConnector := &mockConnector{}
+	h := NewHandler(806, provider, mockConnector, connector.RetryPolicy{})
+	h.SetCache(&CacheConfig{
+		Capacity:   10,
+		SuccessTTL: time.Hour,
+		FailureTTL: time.Hour,
+	})
+
+	tests := []struct {
+		query string
+		calls int
+	}{
+		{query: "target=" + target, calls: 1},
+		// The result is cached.
+		{query: "target=" + target, calls: 1},
+		{query: "debug=true&target=" + target, calls: 1},
+		// nocache=true bypasses the cache.
+		{query: "nocache=true&target=" + target, calls: 2},
+		// Results for other addresses aren't cached.
+		{query: "address=" + target + "&target=" + target, calls: 3},
+		{query: "target=" + target, calls: 3},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/e2e?"+tt.query, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("ServeHTTP(%s) returned %d", tt.query, rr.Code)
+		}
+		if mockConnector.calls != tt.calls {
+			t.Errorf("ServeHTTP(%s) made %d connections in total, want %d",
+				tt.query, mockConnector.calls, tt.calls)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/e2e?nocache=maybe&target="+target, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
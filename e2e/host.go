@@ -0,0 +1,152 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Modules of the e2e endpoint, as in its module parameter.
+const (
+	moduleBMC  = "bmc"
+	moduleHost = "host"
+)
+
+// reasonKeyError is the reason label of reboot_e2e_host_success when the
+// private key used to log into hosts cannot be loaded.
+const reasonKeyError = "key_error"
+
+// HostConfig is the configuration of the host module, which checks that the
+// reboot user can log into a node with its private key.
+type HostConfig struct {
+	SSHPort        int32
+	RebootUser     string
+	PrivateKeyPath string
+}
+
+// hostCollector is a prometheus.Collector probing a node's OS rather than
+// its BMC.
+type hostCollector struct {
+	target string
+	config *collectorConfig
+
+	success       *prometheus.Desc
+	duration      *prometheus.Desc
+	probeSuccess  *prometheus.Desc
+	probeDuration *prometheus.Desc
+
+	// ctx is the context of the request the collector serves. Collect
+	// gives up probing the target when it's done.
+	ctx context.Context
+	// result, if not nil, is reported instead of probing the target.
+	result *Result
+}
+
+func newHostCollector(ctx context.Context, target string,
+	config *collectorConfig) *hostCollector {
+
+	return &hostCollector{
+		ctx:    ctx,
+		target: target,
+		config: config,
+		success: prometheus.NewDesc("reboot_e2e_host_success",
+			"Whether the reboot user can log into this target", []string{"target", "reason"},
+			nil),
+		duration: prometheus.NewDesc("reboot_e2e_duration_seconds",
+			"Duration of each phase of the last e2e test for this target",
+			[]string{"target", "phase"}, nil),
+		probeSuccess: prometheus.NewDesc("probe_success",
+			"Whether the e2e test succeeded", nil, nil),
+		probeDuration: prometheus.NewDesc("probe_duration_seconds",
+			"How long the e2e test took to complete in seconds", nil, nil),
+	}
+}
+
+func (c *hostCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.success
+	ch <- c.duration
+	ch <- c.probeSuccess
+	ch <- c.probeDuration
+}
+
+func (c *hostCollector) Collect(ch chan<- prometheus.Metric) {
+	result := c.result
+	if result == nil {
+		r := c.probe(c.ctx)
+		result = &r
+	}
+	ch <- prometheus.MustNewConstMetric(c.success, prometheus.GaugeValue,
+		successValue(result), result.Target, result.Reason)
+	for phase, duration := range result.Phases {
+		ch <- prometheus.MustNewConstMetric(c.duration, prometheus.GaugeValue,
+			duration.Seconds(), result.Target, phase)
+	}
+	ch <- prometheus.MustNewConstMetric(c.probeSuccess, prometheus.GaugeValue,
+		successValue(result))
+	ch <- prometheus.MustNewConstMetric(c.probeDuration, prometheus.GaugeValue,
+		result.Duration.Seconds())
+}
+
+// probe checks that the host accepts the reboot user's private key. Logging
+// in as the reboot user reboots the host as soon as a session is opened, so
+// the connection is closed right after authentication, without opening any
+// session. The returned Result has no Time.
+func (c *hostCollector) probe(ctx context.Context) Result {
+	trace := newProbeTrace()
+	result := Result{Target: c.target}
+
+	// A key that cannot be loaded would make the connector fall back to
+	// password authentication, which is then reported as auth_rejected.
+	trace.logf("Loading private key %s", c.config.host.PrivateKeyPath)
+	start := time.Now()
+	err := checkPrivateKey(c.config.host.PrivateKeyPath)
+	trace.phase(phaseCredentials, start)
+	if err != nil {
+		log.Errorf("Cannot load private key to probe %s: %v", c.target, err)
+		trace.logf("Cannot load private key: %v", err)
+		result.Reason = reasonKeyError
+		return trace.result(result)
+	}
+
+	trace.logf("Dialing %s as %s", c.target, c.config.host.RebootUser)
+	config := &connector.ConnectionConfig{
+		ConnType:       connector.HostConnection,
+		Hostname:       c.target,
+		Port:           c.config.host.SSHPort,
+		Username:       c.config.host.RebootUser,
+		PrivateKeyFile: c.config.host.PrivateKeyPath,
+		Timeout:        connectionTimeout,
+	}
+	conn, err := c.config.connect(ctx, trace, c.target, config)
+	if err != nil {
+		log.Errorf("Error while creating connection to %s: %v", c.target, err)
+		result.Reason = connectErrorReason(err)
+		trace.logf("Connection failed (%s): %v", result.Reason, err)
+		return trace.result(result)
+	}
+	conn.Close()
+	trace.logf("Private key accepted, closed the connection without opening a session")
+
+	result.Reason = reasonSuccess
+	return trace.result(result)
+}
+
+// checkPrivateKey returns an error if the private key at path cannot be read
+// or parsed.
+func checkPrivateKey(path string) error {
+	privateBytes, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	if _, err := ssh.ParsePrivateKey(privateBytes); err != nil {
+		return fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	return nil
+}
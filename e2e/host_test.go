@@ -0,0 +1,157 @@
+package e2e
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// writePrivateKey writes a new private key to a file in dir and returns its
+// path.
+func writePrivateKey(t *testing.T, dir string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() returned err: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() returned err: %v", err)
+	}
+	path := filepath.Join(dir, "id_ecdsa")
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	}), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+	return path
+}
+
+func Test_hostCollector_probe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "e2e")
+	if err != nil {
+		t.Fatalf("TempDir() returned err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyPath := writePrivateKey(t, dir)
+	invalidKeyPath := filepath.Join(dir, "invalid")
+	if err := ioutil.WriteFile(invalidKeyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("WriteFile() returned err: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		keyPath string
+		err     error
+		want    string
+	}{
+		{
+			name:    "success",
+			keyPath: keyPath,
+			want:    reasonSuccess,
+		},
+		{
+			name:    "auth-rejected",
+			keyPath: keyPath,
+			err:     fmt.Errorf("%w: test", connector.ErrAuthRejected),
+			want:    reasonAuthRejected,
+		},
+		{
+			name:    "timeout",
+			keyPath: keyPath,
+			err:     fmt.Errorf("%w: test", connector.ErrTimeout),
+			want:    reasonTimeout,
+		},
+		{
+			name:    "missing-key",
+			keyPath: filepath.Join(dir, "missing"),
+			want:    reasonKeyError,
+		},
+		{
+			name:    "invalid-key",
+			keyPath: invalidKeyPath,
+			want:    reasonKeyError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockConnector := &mockConnector{err: tt.err}
+			config := &collectorConfig{
+				connector: mockConnector,
+				provider:  credstest.NewProvider(),
+				host: &HostConfig{
+					SSHPort:        22,
+					RebootUser:     "reboot-api",
+					PrivateKeyPath: tt.keyPath,
+				},
+			}
+			c := newHostCollector(context.Background(), "mlab1-abc0t.mlab-sandbox.measurement-lab.org", config)
+			got := c.probe(context.Background())
+			if got.Reason != tt.want {
+				t.Errorf("probe() = %+v, want reason %s", got, tt.want)
+			}
+			if tt.want == reasonKeyError {
+				if mockConnector.calls != 0 {
+					t.Errorf("probe() connected with an invalid key")
+				}
+				return
+			}
+
+			cfg := mockConnector.config
+			if cfg.ConnType != connector.HostConnection || cfg.Username != "reboot-api" ||
+				cfg.PrivateKeyFile != tt.keyPath || cfg.Port != 22 {
+				t.Errorf("probe() connected with %+v", cfg)
+			}
+			if conn := mockConnector.conn; conn != nil &&
+				(conn.commands != 0 || !conn.closed) {
+				t.Errorf("probe() ran %d commands, closed: %t; want 0 commands and closed",
+					conn.commands, conn.closed)
+			}
+		})
+	}
+}
+
+func Test_hostCollector_Collect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "e2e")
+	if err != nil {
+		t.Fatalf("TempDir() returned err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	config := &collectorConfig{
+		connector: &mockConnector{},
+		provider:  credstest.NewProvider(),
+		host: &HostConfig{
+			SSHPort:        22,
+			RebootUser:     "reboot-api",
+			PrivateKeyPath: writePrivateKey(t, dir),
+		},
+	}
+	c := newHostCollector(context.Background(), "mlab1-abc0t.mlab-sandbox.measurement-lab.org", config)
+	expected := `# HELP reboot_e2e_host_success Whether the reboot user can log into this target
+# TYPE reboot_e2e_host_success gauge
+reboot_e2e_host_success{reason="` + reasonSuccess + `",target="mlab1-abc0t.mlab-sandbox.measurement-lab.org"} 1
+# HELP probe_success Whether the e2e test succeeded
+# TYPE probe_success gauge
+probe_success 1
+`
+	err = testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"reboot_e2e_host_success", "probe_success")
+	if err != nil {
+		t.Errorf("CollectAndCompare() returned err: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package e2e
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricProberRounds = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_e2e_prober_rounds_total",
+		Help: "Total number of rounds of probes of the whole fleet",
+	},
+	[]string{
+		"status",
+	},
+)
+
+// ProberConfig is the configuration of a Prober.
+type ProberConfig struct {
+	BMCPort int32
+	Retry   connector.RetryPolicy
+
+	// Interval is the time between the start of two rounds of probes.
+	Interval time.Duration
+	// Concurrency is the maximum number of BMCs probed at once.
+	Concurrency int
+	// Jitter is the maximum random delay before each probe, so that BMCs
+	// at the same site aren't probed at the same time.
+	Jitter time.Duration
+}
+
+// Result is the outcome of probing a BMC.
+type Result struct {
+	Target string
+	// Reason is the reason label of reboot_e2e_success.
+	Reason string
+	// Version is the firmware version reported by the BMC, if known.
+	Version string
+	Time    time.Time
+
+	// Address is the address dialed, and AddressSource where it comes from.
+	Address       string
+	AddressSource string
+	// AddressMatchesDNS is whether the BMC's stored address is one its
+	// hostname resolves to, or nil if that's unknown.
+	AddressMatchesDNS *bool
+
+	// Duration is how long the whole probe took, and Phases how long each
+	// of its phases took. Phases that weren't reached are missing.
+	Duration time.Duration
+	Phases   map[string]time.Duration
+	// Trace is a human-readable log of the steps of the probe.
+	Trace []string
+}
+
+// Prober periodically probes every BMC in the credentials store. It's a
+// prometheus.Collector exporting reboot_e2e_success for the whole fleet,
+// based on the last round of probes.
+type Prober struct {
+	config          *ProberConfig
+	collectorConfig *collectorConfig
+
+	metrics    *resultDescs
+	timeMetric *prometheus.Desc
+
+	mu      sync.RWMutex
+	results map[string]*Result
+}
+
+// NewProber returns a Prober probing the BMCs listed by prov, via c.
+func NewProber(config *ProberConfig, prov creds.Provider, c connector.Connector) *Prober {
+	return &Prober{
+		config: config,
+		collectorConfig: &collectorConfig{
+			bmcPort:   config.BMCPort,
+			retry:     config.Retry,
+			provider:  prov,
+			connector: c,
+		},
+		metrics: newResultDescs(),
+		timeMetric: prometheus.NewDesc("reboot_e2e_probe_timestamp_seconds",
+			"Time of the last probe of this target", []string{"target"}, nil),
+		results: make(map[string]*Result),
+	}
+}
+
+// Run probes every BMC right away, and then every config.Interval, until
+// ctx is canceled.
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.probeAll(ctx); err != nil {
+			log.WithError(err).Error("Cannot list BMCs to probe")
+			metricProberRounds.WithLabelValues("error").Inc()
+		} else {
+			metricProberRounds.WithLabelValues("ok").Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes every BMC in the credentials store, and forgets about
+// the ones that aren't there anymore.
+func (p *Prober) probeAll(ctx context.Context) error {
+	list, err := p.collectorConfig.provider.ListCredentials(ctx)
+	if err != nil {
+		return err
+	}
+
+	concurrency := p.config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	targets := make(map[string]bool, len(list))
+	for _, credentials := range list {
+		targets[credentials.Hostname] = true
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(credentials *creds.Credentials) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			p.probe(ctx, credentials)
+		}(credentials)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for target := range p.results {
+		if !targets[target] {
+			delete(p.results, target)
+		}
+	}
+	return nil
+}
+
+// probe probes a BMC after a random delay, and stores the result.
+func (p *Prober) probe(ctx context.Context, credentials *creds.Credentials) {
+	if p.config.Jitter > 0 {
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(p.config.Jitter))))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+
+	c := newE2ETestCollector(ctx, credentials.Hostname, p.collectorConfig)
+	result := c.probeWith(ctx, credentials)
+	if ctx.Err() != nil {
+		return
+	}
+	result.Time = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[credentials.Hostname] = &result
+}
+
+// Result returns the last result for target, if it has been probed.
+func (p *Prober) Result(target string) (Result, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	r, ok := p.results[target]
+	if !ok {
+		return Result{}, false
+	}
+	return *r, true
+}
+
+// Describe implements prometheus.Collector.
+func (p *Prober) Describe(ch chan<- *prometheus.Desc) {
+	p.metrics.describe(ch)
+	ch <- p.timeMetric
+}
+
+// Collect implements prometheus.Collector, reporting the last result for
+// every BMC.
+func (p *Prober) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, r := range p.results {
+		p.metrics.collect(ch, r)
+		ch <- prometheus.MustNewConstMetric(p.timeMetric, prometheus.GaugeValue,
+			float64(r.Time.UnixNano())/1e9, r.Target)
+	}
+}
@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newTestProber(provider creds.Provider, c connector.Connector) *Prober {
+	return NewProber(&ProberConfig{
+		BMCPort:     806,
+		Interval:    time.Hour,
+		Concurrency: 2,
+		Jitter:      time.Millisecond,
+	}, provider, c)
+}
+
+func TestProber_probeAll(t *testing.T) {
+	provider := credstest.NewProvider()
+	for _, hostname := range []string{
+		"mlab1d.abc0t.measurement-lab.org",
+		"mlab2d.abc0t.measurement-lab.org",
+		"mlab3d.abc0t.measurement-lab.org",
+	} {
+		provider.AddCredentials(context.Background(), hostname, &creds.Credentials{
+			Hostname: hostname,
+		})
+	}
+	mockConnector := &mockConnector{}
+	p := newTestProber(provider, mockConnector)
+
+	if err := p.probeAll(context.Background()); err != nil {
+		t.Fatalf("probeAll() unexpected error: %v", err)
+	}
+	if mockConnector.calls != 3 {
+		t.Errorf("probeAll() made %d connections, want 3", mockConnector.calls)
+	}
+	r, ok := p.Result("mlab2d.abc0t.measurement-lab.org")
+	if !ok || r.Reason != reasonSuccess || r.Version != "2.70.70.70" || r.Time.IsZero() {
+		t.Errorf("Result() = %+v, %v", r, ok)
+	}
+
+	// Every BMC is exported, with the time it was probed.
+	ch := make(chan prometheus.Metric, 100)
+	p.Collect(ch)
+	close(ch)
+	counts := make(map[string]int)
+	for m := range ch {
+		for _, name := range []string{"reboot_e2e_success", "reboot_e2e_probe_timestamp_seconds"} {
+			if strings.Contains(m.Desc().String(), `"`+name+`"`) {
+				counts[name]++
+			}
+		}
+	}
+	if counts["reboot_e2e_success"] != 3 || counts["reboot_e2e_probe_timestamp_seconds"] != 3 {
+		t.Errorf("Collect() returned %v, want 3 of each", counts)
+	}
+
+	// BMCs removed from the credentials store are forgotten.
+	provider.DeleteCredentials(context.Background(), "mlab3d.abc0t.measurement-lab.org")
+	mockConnector.mustFail = true
+	if err := p.probeAll(context.Background()); err != nil {
+		t.Fatalf("probeAll() unexpected error: %v", err)
+	}
+	if _, ok := p.Result("mlab3d.abc0t.measurement-lab.org"); ok {
+		t.Errorf("Result() returned a result for a removed BMC")
+	}
+	if r, _ := p.Result("mlab1d.abc0t.measurement-lab.org"); r.Reason != reasonConnectionFailed {
+		t.Errorf("Result() = %+v, want %s", r, reasonConnectionFailed)
+	}
+
+	// Results are kept if the BMCs can't be listed.
+	p.collectorConfig.provider = &failingProvider{}
+	if err := p.probeAll(context.Background()); err == nil {
+		t.Errorf("probeAll() expected an error")
+	}
+	if _, ok := p.Result("mlab1d.abc0t.measurement-lab.org"); !ok {
+		t.Errorf("Result() did not return the last result")
+	}
+}
+
+func TestProber_Run(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(), "mlab1d.abc0t.measurement-lab.org",
+		&creds.Credentials{Hostname: "mlab1d.abc0t.measurement-lab.org"})
+	p := newTestProber(provider, &mockConnector{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	// The first round starts right away.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := p.Result("mlab1d.abc0t.measurement-lab.org"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Run() did not probe the BMC")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Run() did not return after the context was canceled")
+	}
+}
+
+func TestHandler_ServeHTTP_prober(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+		})
+	mockConnector := &mockConnector{}
+	h := NewHandler(806, provider, mockConnector, connector.RetryPolicy{})
+	p := newTestProber(provider, mockConnector)
+	h.SetProber(p)
+	if err := p.probeAll(context.Background()); err != nil {
+		t.Fatalf("probeAll() unexpected error: %v", err)
+	}
+
+	// The last result is served without connecting again.
+	mockConnector.mustFail = true
+	mockConnector.calls = 0
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET",
+		"/v1/e2e?target=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil))
+	if rr.Code != http.StatusOK || mockConnector.calls != 0 ||
+		!strings.Contains(rr.Body.String(), `reason="`+reasonSuccess+`"`) {
+		t.Errorf("ServeHTTP() = %d after %d connections: %s", rr.Code,
+			mockConnector.calls, rr.Body.String())
+	}
+
+	// BMCs that haven't been probed are probed on demand.
+	provider.AddCredentials(context.Background(),
+		"mlab2d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab2d-abc0t.mlab-sandbox.measurement-lab.org",
+		})
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET",
+		"/v1/e2e?target=mlab2d-abc0t.mlab-sandbox.measurement-lab.org", nil))
+	if mockConnector.calls != 1 ||
+		!strings.Contains(rr.Body.String(), `reason="`+reasonConnectionFailed+`"`) {
+		t.Errorf("ServeHTTP() = %d after %d connections: %s", rr.Code,
+			mockConnector.calls, rr.Body.String())
+	}
+}
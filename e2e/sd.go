@@ -0,0 +1,89 @@
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/creds"
+)
+
+// TargetGroup is a group of targets in the format of Prometheus' HTTP
+// service discovery.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// SDHandler is the HTTP handler for /v1/e2e/targets.
+type SDHandler struct {
+	provider creds.Provider
+}
+
+// NewSDHandler returns a SDHandler listing the BMCs in prov.
+func NewSDHandler(prov creds.Provider) *SDHandler {
+	return &SDHandler{
+		provider: prov,
+	}
+}
+
+// targetGroup returns the TargetGroup for the BMC with the given
+// credentials. Labels with no value are omitted.
+func targetGroup(c *creds.Credentials) (TargetGroup, error) {
+	name, err := host.Parse(c.Hostname)
+	if err != nil {
+		return TargetGroup{}, err
+	}
+	labels := map[string]string{
+		"site":    name.Site,
+		"machine": name.Machine,
+		"model":   c.Model,
+		"address": c.Address,
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(labels, k)
+		}
+	}
+	return TargetGroup{
+		Targets: []string{c.Hostname},
+		Labels:  labels,
+	}, nil
+}
+
+// ServeHTTP handles GET requests, writing a TargetGroup for every BMC in the
+// credentials store as JSON, sorted by hostname.
+func (h *SDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	all, err := h.provider.ListCredentials(r.Context())
+	if err != nil {
+		log.WithError(err).Error("Cannot list credentials")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("Cannot list credentials: %v", err)))
+		return
+	}
+
+	groups := make([]TargetGroup, 0, len(all))
+	for _, c := range all {
+		group, err := targetGroup(c)
+		if err != nil {
+			log.WithError(err).Warnf("Skipping invalid hostname in credentials: %s",
+				c.Hostname)
+			continue
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Targets[0] < groups[j].Targets[0]
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
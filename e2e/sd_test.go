@@ -0,0 +1,72 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+func TestSDHandler_ServeHTTP(t *testing.T) {
+	provider := credstest.NewProvider()
+	for _, c := range []*creds.Credentials{
+		{
+			Hostname: "mlab2d.abc0t.measurement-lab.org",
+			Address:  "192.168.0.2",
+		},
+		{
+			Hostname: "mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+			Model:    "drac",
+			Address:  "192.168.0.1",
+		},
+		{
+			Hostname: "thisshouldbeskipped",
+		},
+	} {
+		provider.AddCredentials(context.Background(), c.Hostname, c)
+	}
+	h := NewSDHandler(provider)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/e2e/targets", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("ServeHTTP() returned Content-Type %q", ct)
+	}
+	want := `[{"targets":["mlab1d-abc0t.mlab-sandbox.measurement-lab.org"],` +
+		`"labels":{"address":"192.168.0.1","machine":"mlab1d","model":"drac","site":"abc0t"}},` +
+		`{"targets":["mlab2d.abc0t.measurement-lab.org"],` +
+		`"labels":{"address":"192.168.0.2","machine":"mlab2d","site":"abc0t"}}]`
+	if got := strings.TrimSpace(rr.Body.String()); got != want {
+		t.Errorf("ServeHTTP() returned:\n%s\nwant:\n%s", got, want)
+	}
+
+	// An empty credentials store gives an empty list, not null.
+	rr = httptest.NewRecorder()
+	NewSDHandler(credstest.NewProvider()).ServeHTTP(rr,
+		httptest.NewRequest("GET", "/v1/e2e/targets", nil))
+	if got := strings.TrimSpace(rr.Body.String()); got != "[]" {
+		t.Errorf("ServeHTTP() returned %s, want []", got)
+	}
+
+	rr = httptest.NewRecorder()
+	NewSDHandler(&failingProvider{}).ServeHTTP(rr,
+		httptest.NewRequest("GET", "/v1/e2e/targets", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code,
+			http.StatusInternalServerError)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/e2e/targets", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code,
+			http.StatusMethodNotAllowed)
+	}
+}
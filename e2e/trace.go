@@ -0,0 +1,97 @@
+package e2e
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector"
+)
+
+// Phases of a probe, as in the phase label of reboot_e2e_duration_seconds.
+const (
+	phaseCredentials = "credentials"
+	phaseConnect     = "connect"
+	phaseHandshake   = "handshake"
+	phaseCommand     = "command"
+)
+
+// probeTrace records how long each phase of a probe takes, and a
+// human-readable log of its steps.
+type probeTrace struct {
+	start time.Time
+	now   func() time.Time
+
+	mu     sync.Mutex
+	phases map[string]time.Duration
+	steps  []string
+}
+
+func newProbeTrace() *probeTrace {
+	return &probeTrace{
+		start:  time.Now(),
+		now:    time.Now,
+		phases: make(map[string]time.Duration),
+	}
+}
+
+// logf adds a step to the log, with the time elapsed since the probe
+// started.
+func (t *probeTrace) logf(format string, args ...interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.steps = append(t.steps, fmt.Sprintf("[%8.3fs] ", t.now().Sub(t.start).Seconds())+
+		fmt.Sprintf(format, args...))
+}
+
+// phase records that a phase started at start is over. If the phase is run
+// more than once, e.g. when retrying, the last run is kept.
+func (t *probeTrace) phase(name string, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phases[name] = t.now().Sub(start)
+}
+
+// connectTrace returns a ConnectTrace recording the connect and handshake
+// phases.
+func (t *probeTrace) connectTrace() *connector.ConnectTrace {
+	var dialStart, handshakeStart time.Time
+	return &connector.ConnectTrace{
+		DialStart: func(addr string) {
+			dialStart = t.now()
+			t.logf("Connecting to %s", addr)
+		},
+		DialDone: func(err error) {
+			t.phase(phaseConnect, dialStart)
+			if err != nil {
+				t.logf("TCP connection failed: %v", err)
+				return
+			}
+			t.logf("TCP connection established")
+		},
+		HandshakeStart: func() {
+			handshakeStart = t.now()
+		},
+		HandshakeDone: func(err error) {
+			t.phase(phaseHandshake, handshakeStart)
+			if err != nil {
+				t.logf("SSH handshake failed: %v", err)
+				return
+			}
+			t.logf("SSH handshake and authentication succeeded")
+		},
+	}
+}
+
+// result fills the duration, phases and log of r.
+func (t *probeTrace) result(r Result) Result {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.Duration = t.now().Sub(t.start)
+	r.Phases = make(map[string]time.Duration, len(t.phases))
+	for name, d := range t.phases {
+		r.Phases[name] = d
+	}
+	r.Trace = append([]string(nil), t.steps...)
+	return r
+}
@@ -0,0 +1,51 @@
+package e2e
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_probeTrace(t *testing.T) {
+	now := time.Date(2020, 10, 15, 10, 0, 0, 0, time.UTC)
+	trace := newProbeTrace()
+	trace.start = now
+	trace.now = func() time.Time { return now }
+
+	ct := trace.connectTrace()
+	ct.DialStart("mlab1d.abc0t.measurement-lab.org:806")
+	now = now.Add(100 * time.Millisecond)
+	ct.DialDone(nil)
+	ct.HandshakeStart()
+	now = now.Add(2 * time.Second)
+	ct.HandshakeDone(errors.New("unable to authenticate"))
+
+	r := trace.result(Result{Target: "mlab1d.abc0t.measurement-lab.org",
+		Reason: reasonAuthRejected})
+	if r.Duration != 2100*time.Millisecond {
+		t.Errorf("result() Duration = %v, want 2.1s", r.Duration)
+	}
+	wantPhases := map[string]time.Duration{
+		phaseConnect:   100 * time.Millisecond,
+		phaseHandshake: 2 * time.Second,
+	}
+	if !reflect.DeepEqual(r.Phases, wantPhases) {
+		t.Errorf("result() Phases = %v, want %v", r.Phases, wantPhases)
+	}
+	wantTrace := []string{
+		"[   0.000s] Connecting to mlab1d.abc0t.measurement-lab.org:806",
+		"[   0.100s] TCP connection established",
+		"[   2.100s] SSH handshake failed: unable to authenticate",
+	}
+	if !reflect.DeepEqual(r.Trace, wantTrace) {
+		t.Errorf("result() Trace = %q, want %q", r.Trace, wantTrace)
+	}
+
+	// Later steps don't change a result already returned.
+	trace.logf("Done")
+	trace.phase(phaseCommand, now)
+	if len(r.Trace) != 3 || len(r.Phases) != 2 {
+		t.Errorf("result() shares state with the trace")
+	}
+}
@@ -9,10 +9,8 @@ import (
 	"net/http"
 	"time"
 
-	cache "github.com/victorspringer/http-cache"
-	"github.com/victorspringer/http-cache/adapter/memory"
-
 	"github.com/goji/httpauth"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/apex/log"
@@ -39,6 +37,27 @@ var (
 	sshPort = flag.Int("reboot.sshport", defaultSSHPort, "SSH port to use")
 	bmcPort = flag.Int("reboot.bmcport", defaultBMCPort, "DRAC port to use")
 
+	redfishPort = flag.Int("reboot.redfishport", defaultRedfishPort,
+		"HTTPS port to use for Redfish BMCs")
+	redfishCAFile = flag.String("redfish.ca-file", "",
+		"PEM file with the CAs signing Redfish BMC certificates (system CAs if empty)")
+	redfishPinnedCerts = flag.String("redfish.pinned-certs", "",
+		"File with the only certificate fingerprints accepted for the listed Redfish BMCs")
+	redfishInsecure = flag.Bool("redfish.insecure-skip-verify", false,
+		"Accept any certificate from Redfish BMCs without pinned certificates. "+
+			"INSECURE: BMC passwords can then be intercepted")
+	ipmiPort = flag.Int("reboot.ipmiport", defaultIPMIPort,
+		"UDP port to use for IPMI BMCs")
+	defaultModel = flag.String("reboot.default-model", defaultBMCModel,
+		"Driver to use for BMCs with no model in their credentials")
+
+	knownHosts = flag.String("ssh.known-hosts", "",
+		"File where learned SSH host keys are stored (in-memory only if empty)")
+	pinnedHostKeys = flag.String("ssh.pinned-host-keys", "",
+		"known_hosts file with the only keys accepted for the listed hosts")
+	hostKeyMode = flag.String("ssh.host-key-mode", defaultHostKeyMode,
+		"Host key verification mode: tofu, strict or warn")
+
 	username = flag.String("auth.username", "", "Username for HTTP basic auth")
 	password = flag.String("auth.password", "", "Password for HTTP basic auth")
 
@@ -47,10 +66,65 @@ var (
 	tlsCertsDir = flag.String("tls.certs-dir", defaultCertsDir,
 		"Folder where to cache TLS certificates")
 
+	jobWorkers = flag.Int("jobs.workers", defaultJobWorkers,
+		"Number of workers running asynchronous reboots")
+	jobQueueSize = flag.Int("jobs.queue-size", defaultJobQueueSize,
+		"Maximum number of asynchronous reboots waiting for a worker")
+	jobRetention = flag.Duration("jobs.retention", defaultJobRetention,
+		"How long finished asynchronous reboots can be queried for")
+
+	verifyInterval = flag.Duration("verify.interval", defaultVerifyInterval,
+		"How often to check a node when verifying a reboot")
+	verifyDownTimeout = flag.Duration("verify.down-timeout", defaultVerifyDownTimeout,
+		"How long to wait for a node to go down when verifying a reboot")
+	verifyUpTimeout = flag.Duration("verify.up-timeout", defaultVerifyUpTimeout,
+		"How long to wait for a node to come back when verifying a reboot")
+
+	cooldown = flag.Duration("limits.cooldown", defaultCooldown,
+		"Minimum time between two reboots of the same node (0 to disable)")
+	maxNodeReboots = flag.Int("limits.max-node-reboots", defaultMaxNodeReboots,
+		"Maximum reboots per node within -limits.window (0 to disable)")
+	maxSiteReboots = flag.Int("limits.max-site-reboots", defaultMaxSiteReboots,
+		"Maximum reboots per site within -limits.window (0 to disable)")
+	budgetWindow = flag.Duration("limits.window", defaultBudgetWindow,
+		"Time window for -limits.max-node-reboots and -limits.max-site-reboots")
+
+	batchParallelism = flag.Int("batch.parallelism", defaultBatchParallelism,
+		"Default and maximum number of nodes rebooted in parallel by a batch reboot")
+
+	autoGracePeriod = flag.Duration("reboot.auto-grace-period", defaultAutoGracePeriod,
+		"How long method=auto waits for a host to go down before rebooting it via the BMC")
+
+	dryRun = flag.Bool("reboot.dry-run", false,
+		"Make every reboot and power action a dry run, as with dry_run=true")
+
+	retryAttempts = flag.Int("retry.attempts", defaultRetryAttempts,
+		"Maximum # of attempts at connecting to a node or BMC, including the first one")
+	retryBackoff = flag.Duration("retry.backoff", defaultRetryBackoff,
+		"Delay before the first retry, doubling at every retry")
+	retryMaxBackoff = flag.Duration("retry.max-backoff", defaultRetryMaxBackoff,
+		"Maximum delay between two retries")
+
+	breakerThreshold = flag.Int("breaker.threshold", defaultBreakerThreshold,
+		"# of consecutive failed connections after which a BMC isn't tried for a while (0 to disable)")
+	breakerCooldown = flag.Duration("breaker.cooldown", defaultBreakerCooldown,
+		"How long a BMC isn't tried after too many failed connections")
+
 	e2eCacheCapacity = flag.Int("e2e.cache-capacity", defaultCacheCapacity,
-		"Maximum # of cached responses for the e2e endpoint")
+		"Maximum # of cached results for the e2e endpoint (0 to disable)")
 	e2eCacheTTL = flag.Duration("e2e.cache-ttl", defaultCacheTTL,
-		"TTL of cached responses for the e2e endpoint")
+		"TTL of cached successful results for the e2e endpoint")
+	e2eCacheFailureTTL = flag.Duration("e2e.cache-failure-ttl", defaultCacheFailureTTL,
+		"TTL of cached failed results for the e2e endpoint, doubling at every consecutive failure")
+	e2eCacheMaxFailureTTL = flag.Duration("e2e.cache-max-failure-ttl", defaultCacheMaxFailureTTL,
+		"Maximum TTL of cached failed results for the e2e endpoint")
+
+	e2eProbeInterval = flag.Duration("e2e.probe-interval", 0,
+		"How often to probe every BMC in the background (0 to disable)")
+	e2eProbeConcurrency = flag.Int("e2e.probe-concurrency", defaultProbeConcurrency,
+		"Maximum # of BMCs probed at once in the background")
+	e2eProbeJitter = flag.Duration("e2e.probe-jitter", defaultProbeJitter,
+		"Maximum random delay before each background probe")
 
 	// Context for the whole program.
 	ctx, cancel = context.WithCancel(context.Background())
@@ -66,11 +140,45 @@ const (
 	defaultRebootUser = "reboot-api"
 	defaultCertsDir   = "/var/tls/"
 
+	defaultRedfishPort = 443
+	defaultIPMIPort    = 623
+	defaultBMCModel    = "drac"
+	defaultHostKeyMode = "tofu"
+
+	defaultJobWorkers   = 10
+	defaultJobQueueSize = 100
+	defaultJobRetention = 24 * time.Hour
+
+	defaultVerifyInterval    = 10 * time.Second
+	defaultVerifyDownTimeout = 5 * time.Minute
+	defaultVerifyUpTimeout   = 15 * time.Minute
+
+	defaultCooldown       = 15 * time.Minute
+	defaultMaxNodeReboots = 4
+	defaultMaxSiteReboots = 8
+	defaultBudgetWindow   = 24 * time.Hour
+
+	defaultBatchParallelism = 4
+
+	defaultAutoGracePeriod = 2 * time.Minute
+
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 10 * time.Second
+
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 5 * time.Minute
+
 	// The default cache capacity has been chosen based on the current amount
 	// of BMCs on the platform, plus some significant headroom for future
 	// expansion.
-	defaultCacheCapacity = 2000
-	defaultCacheTTL      = 60 * time.Minute
+	defaultCacheCapacity      = 2000
+	defaultCacheTTL           = 60 * time.Minute
+	defaultCacheFailureTTL    = 2 * time.Minute
+	defaultCacheMaxFailureTTL = 30 * time.Minute
+
+	defaultProbeConcurrency = 20
+	defaultProbeJitter      = 30 * time.Second
 )
 
 func init() {
@@ -87,6 +195,31 @@ func createRebootConfig() *reboot.Config {
 
 		RebootUser:     *rebootUser,
 		PrivateKeyPath: *keyPath,
+
+		VerifyInterval:    *verifyInterval,
+		VerifyDownTimeout: *verifyDownTimeout,
+		VerifyUpTimeout:   *verifyUpTimeout,
+
+		Cooldown:       *cooldown,
+		MaxNodeReboots: *maxNodeReboots,
+		MaxSiteReboots: *maxSiteReboots,
+		BudgetWindow:   *budgetWindow,
+
+		BatchParallelism: *batchParallelism,
+
+		AutoGracePeriod: *autoGracePeriod,
+
+		DryRun: *dryRun,
+
+		Retry: retryPolicy(),
+	}
+}
+
+func retryPolicy() connector.RetryPolicy {
+	return connector.RetryPolicy{
+		Attempts:   *retryAttempts,
+		Backoff:    *retryBackoff,
+		MaxBackoff: *retryMaxBackoff,
 	}
 }
 
@@ -109,30 +242,109 @@ func main() {
 		*projectID, *namespace)
 	rtx.Must(err, "Cannot initialize Datastore connection")
 
-	connector := connector.NewConnector()
+	mode, err := connector.ParseHostKeyMode(*hostKeyMode)
+	rtx.Must(err, "Invalid host key verification mode")
+	hostKeys, err := connector.NewHostKeyStore(*knownHosts, mode)
+	rtx.Must(err, "Cannot load known host keys")
+	if *pinnedHostKeys != "" {
+		rtx.Must(hostKeys.LoadPinned(*pinnedHostKeys),
+			"Cannot load pinned host keys")
+	}
 
-	var (
-		rebootHandler http.Handler
-		e2eHandler    http.Handler
-	)
-	rebootHandler = reboot.NewHandler(rebootConfig, credentials, connector)
-	e2eHandler = e2e.NewHandler(int32(*bmcPort), credentials, connector)
-
-	// Create an in-memory cache to avoid querying the BMCs tool often in e2e
-	// tests.
-	memcache, err := memory.NewAdapter(
-		memory.AdapterWithAlgorithm(memory.MRU),
-		memory.AdapterWithCapacity(*e2eCacheCapacity),
-	)
-	rtx.Must(err, "Cannot initialize in-memory cache.")
+	// Register a driver for every supported BMC model. The right one is
+	// chosen based on the model field of each BMC's credentials.
+	sshConnector := connector.NewConnectorWithHostKeyCallback(
+		hostKeys.HostKeyCallback())
+	registry := connector.NewRegistry(*defaultModel, sshConnector)
+	registry.Register(&connector.Driver{
+		Name:      "Dell iDRAC (racadm over SSH)",
+		Connector: sshConnector,
+	}, "drac", "idrac")
+	registry.Register(&connector.Driver{
+		Name: "HPE iLO (SMASH CLP over SSH)",
+		Connector: connector.NewSSHConnector(connector.ILOCommands,
+			hostKeys.HostKeyCallback()),
+	}, "ilo")
+	redfishConnector, err := connector.NewRedfishConnectorWithTLS(
+		int32(*redfishPort), &connector.RedfishTLSConfig{
+			CAFile:             *redfishCAFile,
+			PinnedCertsFile:    *redfishPinnedCerts,
+			InsecureSkipVerify: *redfishInsecure,
+		})
+	rtx.Must(err, "Cannot load Redfish TLS configuration")
+	if *redfishInsecure {
+		log.Warn("Redfish BMC certificates are not verified")
+	}
+	registry.Register(&connector.Driver{
+		Name:      "Redfish",
+		Connector: redfishConnector,
+	}, "redfish")
+	registry.Register(&connector.Driver{
+		Name:      "IPMI v2.0 over LAN",
+		Connector: connector.NewIPMIConnector(int32(*ipmiPort)),
+	}, "ipmi")
+	_, err = registry.Driver("")
+	rtx.Must(err, "Invalid default BMC model")
+
+	// Stop trying BMCs that keep failing for a while, rather than waiting
+	// for every connection to time out.
+	breaker := connector.NewBreaker(registry, *breakerThreshold, *breakerCooldown)
+
+	// Asynchronous reboots are run in the background and can be polled via
+	// the jobs endpoint.
+	jobStore := reboot.NewMemoryJobStore(*jobRetention)
+	handler := reboot.NewHandler(rebootConfig, credentials, breaker)
+	handler.StartJobs(ctx, jobStore, *jobWorkers, *jobQueueSize)
 
-	cacheClient, err := cache.NewClient(
-		cache.ClientWithAdapter(memcache),
-		cache.ClientWithTTL(*e2eCacheTTL),
+	var (
+		rebootHandler   http.Handler
+		jobsHandler     http.Handler
+		powerHandler    http.Handler
+		inflightHandler http.Handler
+		batchHandler    http.Handler
+		e2eHandler      http.Handler
+		breakerHandler  http.Handler
+		sdHandler       http.Handler
 	)
-	rtx.Must(err, "Cannot initialize in-memory cache client.")
-
-	e2eHandler = cacheClient.Middleware(e2eHandler)
+	rebootHandler = handler
+	jobsHandler = reboot.NewJobsHandler(jobStore)
+	powerHandler = reboot.NewPowerHandler(handler)
+	inflightHandler = reboot.NewInflightHandler(handler)
+	batchHandler = reboot.NewBatchHandler(handler)
+	e2eTests := e2e.NewHandler(int32(*bmcPort), credentials, breaker,
+		retryPolicy())
+	e2eTests.SetHostConfig(&e2e.HostConfig{
+		SSHPort:        int32(*sshPort),
+		RebootUser:     *rebootUser,
+		PrivateKeyPath: *keyPath,
+	})
+	// Cache e2e results to avoid querying the BMCs too often. Failures are
+	// cached for less time, so that recoveries are noticed quickly.
+	e2eTests.SetCache(&e2e.CacheConfig{
+		Capacity:      *e2eCacheCapacity,
+		SuccessTTL:    *e2eCacheTTL,
+		FailureTTL:    *e2eCacheFailureTTL,
+		MaxFailureTTL: *e2eCacheMaxFailureTTL,
+	})
+	e2eHandler = e2eTests
+
+	// Optionally probe every BMC in the background, so that the whole
+	// fleet's status is exported on the metrics port and /v1/e2e can serve
+	// the last results.
+	if *e2eProbeInterval > 0 {
+		prober := e2e.NewProber(&e2e.ProberConfig{
+			BMCPort:     int32(*bmcPort),
+			Retry:       retryPolicy(),
+			Interval:    *e2eProbeInterval,
+			Concurrency: *e2eProbeConcurrency,
+			Jitter:      *e2eProbeJitter,
+		}, credentials, breaker)
+		prometheus.MustRegister(prober)
+		go prober.Run(ctx)
+		e2eTests.SetProber(prober)
+	}
+	breakerHandler = breaker
+	sdHandler = e2e.NewSDHandler(credentials)
 
 	// Initialize HTTP server.
 	// TODO(roberto): add promhttp instruments for handlers.
@@ -143,7 +355,13 @@ func main() {
 			Password: *password,
 		}
 		rebootHandler = httpauth.BasicAuth(authOpts)(rebootHandler)
+		jobsHandler = httpauth.BasicAuth(authOpts)(jobsHandler)
+		powerHandler = httpauth.BasicAuth(authOpts)(powerHandler)
+		inflightHandler = httpauth.BasicAuth(authOpts)(inflightHandler)
+		batchHandler = httpauth.BasicAuth(authOpts)(batchHandler)
 		e2eHandler = httpauth.BasicAuth(authOpts)(e2eHandler)
+		breakerHandler = httpauth.BasicAuth(authOpts)(breakerHandler)
+		sdHandler = httpauth.BasicAuth(authOpts)(sdHandler)
 	} else {
 		log.Warn("Username and password have not been specified!")
 		log.Warn("Make sure you add -auth.username and -auth.password before " +
@@ -152,7 +370,13 @@ func main() {
 
 	rebootMux := http.NewServeMux()
 	rebootMux.Handle("/v1/reboot", rebootHandler)
+	rebootMux.Handle("/v1/jobs/", jobsHandler)
+	rebootMux.Handle("/v1/power", powerHandler)
+	rebootMux.Handle("/v1/inflight", inflightHandler)
+	rebootMux.Handle("/v1/batch", batchHandler)
 	rebootMux.Handle("/v1/e2e", e2eHandler)
+	rebootMux.Handle("/v1/e2e/targets", sdHandler)
+	rebootMux.Handle("/v1/debug/breakers", breakerHandler)
 
 	s := makeHTTPServer(rebootMux)
 	// Setup TLS and autocert
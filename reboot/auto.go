@@ -0,0 +1,120 @@
+package reboot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultAutoGracePeriod = 2 * time.Minute
+
+// Paths taken by method=auto.
+const (
+	// pathHost means the host rebooted itself.
+	pathHost = "host"
+	// pathBMCHostFailed means the host reboot failed, e.g. because the host
+	// is unreachable, and the node was rebooted via the BMC.
+	pathBMCHostFailed = "bmc-host-failed"
+	// pathBMCHostNotDown means the host accepted the reboot command but did
+	// not go down within the grace period, and the node was rebooted via
+	// the BMC.
+	pathBMCHostNotDown = "bmc-host-not-down"
+)
+
+var metricAutoReboots = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_auto_total",
+		Help: "Total number of method=auto reboots, by path taken",
+	},
+	[]string{
+		"path",
+		"status",
+	},
+)
+
+// sshUp reports whether the SSH server at addr is up.
+func (h *Handler) sshUp(ctx context.Context, addr string) bool {
+	if h.sshProbe != nil {
+		return h.sshProbe(ctx, addr)
+	}
+	return sshBannerUp(ctx, addr)
+}
+
+// waitHostDown waits up to the configured grace period for node's SSH
+// server to go away. It returns errVerifyTimeout if it never does.
+func (h *Handler) waitHostDown(ctx context.Context, node host.Name) error {
+	interval := durationOrDefault(h.config.VerifyInterval, defaultVerifyInterval)
+	grace := durationOrDefault(h.config.AutoGracePeriod, defaultAutoGracePeriod)
+	addr := net.JoinHostPort(node.String(), strconv.Itoa(int(h.config.SSHPort)))
+
+	_, err := poll(ctx, interval, grace, func(ctx context.Context) (bool, error) {
+		return !h.sshUp(ctx, addr), nil
+	})
+	return err
+}
+
+// rebootAuto reboots node via the host and escalates to the BMC if the host
+// can't be reached or doesn't go down within the grace period. The output
+// starts with a line telling which path was taken. In a dry run, the host is
+// assumed to go down.
+func (h *Handler) rebootAuto(ctx context.Context, node host.Name) (string, error) {
+	node = hostNode(node)
+	via := "Rebooted via"
+	if isDryRun(ctx) {
+		via = "Would have rebooted via"
+	}
+
+	var path, reason string
+	output, err := h.rebootHost(ctx, node)
+	var coded *codedError
+	if err != nil && !(errors.As(err, &coded) && coded.code == CodeCommandFailed) {
+		path = pathBMCHostFailed
+		reason = fmt.Sprintf("host reboot failed: %v", err)
+	} else {
+		// Logging in is what reboots the host, and the session often ends
+		// with an error as the host goes down, so a failed command is only
+		// escalated if the host doesn't go down.
+		hostErr := err
+		if hostErr != nil {
+			log.WithError(hostErr).Warnf("Reboot command failed on %s, waiting for it to go down",
+				node.String())
+			output = fmt.Sprintf("Reboot command failed (%v), but the host went down", hostErr)
+		}
+		err = nil
+		if !isDryRun(ctx) {
+			err = h.waitHostDown(ctx, node)
+		}
+		if err == nil {
+			metricAutoReboots.WithLabelValues(pathHost, metricStatus(ctx, "ok")).Inc()
+			return via + " host\n" + output, nil
+		}
+		if err != errVerifyTimeout {
+			metricAutoReboots.WithLabelValues(pathHost, "error").Inc()
+			return "", err
+		}
+		path = pathBMCHostNotDown
+		reason = fmt.Sprintf("host did not go down within %v",
+			durationOrDefault(h.config.AutoGracePeriod, defaultAutoGracePeriod))
+		if hostErr != nil {
+			reason = fmt.Sprintf("%s after reboot command failed: %v", reason, hostErr)
+		}
+	}
+
+	log.Warnf("Escalating reboot of %s to the BMC: %s", node.String(), reason)
+	output, err = h.rebootBMC(ctx, node)
+	if err != nil {
+		metricAutoReboots.WithLabelValues(path, metricStatus(ctx, "error")).Inc()
+		return "", fmt.Errorf("%s, then BMC reboot failed: %w", reason, err)
+	}
+
+	metricAutoReboots.WithLabelValues(path, metricStatus(ctx, "ok")).Inc()
+	return fmt.Sprintf("%s bmc (%s)\n%s", via, reason, output), nil
+}
@@ -0,0 +1,183 @@
+package reboot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+	"golang.org/x/crypto/ssh"
+)
+
+// methodConnector records the connection types requested, and fails those
+// listed in fail. Reboots over the connection types listed in rebootFail
+// fail, or return the error listed in rebootErr.
+type methodConnector struct {
+	fail       map[connector.ConnType]bool
+	rebootFail map[connector.ConnType]bool
+	rebootErr  map[connector.ConnType]error
+	connTypes  []connector.ConnType
+}
+
+func (c *methodConnector) NewConnection(config *connector.ConnectionConfig) (connector.Connection, error) {
+	c.connTypes = append(c.connTypes, config.ConnType)
+	if c.fail[config.ConnType] {
+		return nil, errors.New("method NewConnection() failed")
+	}
+	return &mockConnection{
+		mustFail:  c.rebootFail[config.ConnType],
+		rebootErr: c.rebootErr[config.ConnType],
+	}, nil
+}
+
+func TestHandler_rebootAuto(t *testing.T) {
+	tests := []struct {
+		name       string
+		fail       map[connector.ConnType]bool
+		rebootFail map[connector.ConnType]bool
+		rebootErr  map[connector.ConnType]error
+		sshUp      bool
+		status     int
+		body       string
+		connTypes  []connector.ConnType
+	}{
+		{
+			name:      "host",
+			status:    http.StatusOK,
+			body:      "Rebooted via host\nSystem reboot successful",
+			connTypes: []connector.ConnType{connector.HostConnection},
+		},
+		{
+			name: "host-failed",
+			fail: map[connector.ConnType]bool{
+				connector.HostConnection: true,
+			},
+			status: http.StatusOK,
+			body:   "Rebooted via bmc (host reboot failed: method NewConnection() failed)",
+			connTypes: []connector.ConnType{
+				connector.HostConnection, connector.BMCConnection,
+			},
+		},
+		{
+			// The session ended with an error, but the host went down, so
+			// the BMC must not be touched.
+			name: "host-command-failed",
+			rebootFail: map[connector.ConnType]bool{
+				connector.HostConnection: true,
+			},
+			status:    http.StatusOK,
+			body:      "Rebooted via host\nReboot command failed",
+			connTypes: []connector.ConnType{connector.HostConnection},
+		},
+		{
+			// The login session exited with a non-zero status, which is
+			// still a failed command rather than a connection failure.
+			name: "host-command-exit-status",
+			rebootErr: map[connector.ConnType]error{
+				connector.HostConnection: &connector.CommandError{
+					Err: &ssh.ExitError{},
+				},
+			},
+			status:    http.StatusOK,
+			body:      "Rebooted via host\nReboot command failed",
+			connTypes: []connector.ConnType{connector.HostConnection},
+		},
+		{
+			name: "host-command-failed-not-down",
+			rebootFail: map[connector.ConnType]bool{
+				connector.HostConnection: true,
+			},
+			sshUp:  true,
+			status: http.StatusOK,
+			body:   "Rebooted via bmc (host did not go down within 10ms after reboot command failed",
+			connTypes: []connector.ConnType{
+				connector.HostConnection, connector.BMCConnection,
+			},
+		},
+		{
+			name:   "host-not-down",
+			sshUp:  true,
+			status: http.StatusOK,
+			body:   "Rebooted via bmc (host did not go down within 10ms)",
+			connTypes: []connector.ConnType{
+				connector.HostConnection, connector.BMCConnection,
+			},
+		},
+		{
+			name: "both-failed",
+			fail: map[connector.ConnType]bool{
+				connector.HostConnection: true,
+				connector.BMCConnection:  true,
+			},
+			status: http.StatusInternalServerError,
+			body:   "host reboot failed: method NewConnection() failed, then BMC reboot failed",
+			connTypes: []connector.ConnType{
+				connector.HostConnection, connector.BMCConnection,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		provider := credstest.NewProvider()
+		provider.AddCredentials(context.Background(),
+			"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+				Address: "testaddr",
+			})
+		c := &methodConnector{
+			fail:       tt.fail,
+			rebootFail: tt.rebootFail,
+			rebootErr:  tt.rebootErr,
+		}
+		h := NewHandler(&Config{
+			VerifyInterval:  time.Millisecond,
+			AutoGracePeriod: 10 * time.Millisecond,
+		}, provider, c)
+		sshUp := tt.sshUp
+		h.sshProbe = func(context.Context, string) bool {
+			return sshUp
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST",
+			"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&method=auto", nil))
+		if rr.Code != tt.status {
+			t.Errorf("ServeHTTP() %s - expected %d, got %d", tt.name, tt.status, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tt.body) {
+			t.Errorf("ServeHTTP() %s - unexpected response: %s", tt.name, rr.Body.String())
+		}
+		if len(c.connTypes) != len(tt.connTypes) {
+			t.Errorf("ServeHTTP() %s - connections = %v, want %v", tt.name,
+				c.connTypes, tt.connTypes)
+			continue
+		}
+		for i := range c.connTypes {
+			if c.connTypes[i] != tt.connTypes[i] {
+				t.Errorf("ServeHTTP() %s - connections = %v, want %v", tt.name,
+					c.connTypes, tt.connTypes)
+			}
+		}
+	}
+}
+
+func Test_methodParam(t *testing.T) {
+	tests := map[string]string{
+		"":       "bmc",
+		"bmc":    "bmc",
+		"host":   "host",
+		"auto":   "auto",
+		"reboot": "bmc",
+	}
+	for value, want := range tests {
+		r := httptest.NewRequest("POST", "/v1/reboot?method="+value, nil)
+		if got := methodParam(r); got != want {
+			t.Errorf("methodParam(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package reboot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultBatchParallelism = 1
+
+// Per-node statuses in a BatchReport.
+const (
+	BatchOK      = "ok"
+	BatchFailed  = "failed"
+	BatchRefused = "refused"
+	BatchSkipped = "skipped"
+)
+
+var metricBatches = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_batch_total",
+		Help: "Total number of batch reboots, by whether they were stopped early",
+	},
+	[]string{
+		"status",
+	},
+)
+
+// BatchResult is the outcome of rebooting one node in a batch.
+type BatchResult struct {
+	Node   string `json:"node"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BatchReport is the aggregated outcome of a batch reboot.
+type BatchReport struct {
+	Site   string `json:"site,omitempty"`
+	Method string `json:"method"`
+	DryRun bool   `json:"dry_run,omitempty"`
+
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Refused   int `json:"refused"`
+	Skipped   int `json:"skipped"`
+
+	// Stopped is whether the batch was stopped after too many failures.
+	Stopped bool `json:"stopped"`
+
+	Results []BatchResult `json:"results"`
+}
+
+// siteNodes returns the nodes at site, according to the credentials
+// inventory. The inventory lists BMCs, so their names are turned into the
+// names of the nodes they manage.
+func (h *Handler) siteNodes(ctx context.Context, site string) ([]host.Name, error) {
+	all, err := h.credsProvider.ListCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []host.Name
+	for _, c := range all {
+		node, err := host.Parse(c.Hostname)
+		if err != nil {
+			log.WithError(err).Warnf("Skipping invalid hostname in credentials: %s",
+				c.Hostname)
+			continue
+		}
+		if node.Site == site {
+			nodes = append(nodes, hostNode(node))
+		}
+	}
+	return nodes, nil
+}
+
+// parseHosts parses a comma-separated list of hostnames.
+func parseHosts(hosts string) ([]host.Name, error) {
+	var nodes []host.Name
+	for _, target := range strings.Split(hosts, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		node, err := host.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"the specified hostname is not a valid M-Lab node: %s", target)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// uniqueNodes sorts nodes and removes duplicates, i.e. different names for
+// the same node.
+func uniqueNodes(nodes []host.Name) []host.Name {
+	seen := make(map[string]bool)
+	var unique []host.Name
+	for _, n := range nodes {
+		key := nodeKey(n)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, n)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return hostNode(unique[i]).String() < hostNode(unique[j]).String()
+	})
+	return unique
+}
+
+// rebootBatch reboots nodes with at most parallelism reboots at a time. Once
+// maxFailures reboots have failed, the nodes that haven't been started yet
+// are skipped. If maxFailures is zero, all the nodes are rebooted.
+func (h *Handler) rebootBatch(ctx context.Context, nodes []host.Name, method string,
+	force bool, parallelism, maxFailures int) *BatchReport {
+
+	report := &BatchReport{
+		Method:  method,
+		Results: make([]BatchResult, len(nodes)),
+	}
+
+	var (
+		mu       sync.Mutex
+		failures int
+		wg       sync.WaitGroup
+	)
+	indexes := make(chan int)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				node := nodes[i]
+				result := BatchResult{Node: hostNode(node).String()}
+
+				mu.Lock()
+				stopped := maxFailures > 0 && failures >= maxFailures
+				mu.Unlock()
+				if stopped {
+					result.Status = BatchSkipped
+					report.Results[i] = result
+					continue
+				}
+
+				output, err := h.rebootNode(ctx, node, method, force)
+				var (
+					conflict *ConflictError
+					rle      *RateLimitError
+				)
+				switch {
+				case err == nil:
+					result.Status = BatchOK
+					result.Output = output
+				case errors.As(err, &conflict) || errors.As(err, &rle):
+					result.Status = BatchRefused
+					result.Code = errorCode(err)
+					result.Error = err.Error()
+				default:
+					result.Status = BatchFailed
+					result.Code = errorCode(err)
+					result.Error = err.Error()
+					mu.Lock()
+					failures++
+					mu.Unlock()
+				}
+				report.Results[i] = result
+			}
+		}()
+	}
+	for i := range nodes {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, r := range report.Results {
+		switch r.Status {
+		case BatchOK:
+			report.Succeeded++
+		case BatchFailed:
+			report.Failed++
+		case BatchRefused:
+			report.Refused++
+		case BatchSkipped:
+			report.Skipped++
+		}
+	}
+	report.Stopped = report.Skipped > 0
+	return report
+}
+
+// intParam parses an optional non-negative integer URL parameter, returning
+// def if it's missing.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("URL parameter '%s' must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// BatchHandler is the HTTP handler for /v1/batch.
+type BatchHandler struct {
+	h *Handler
+}
+
+// NewBatchHandler returns a BatchHandler rebooting nodes via h, so that
+// batch reboots are subject to the same locks and limits as single ones.
+func NewBatchHandler(h *Handler) *BatchHandler {
+	return &BatchHandler{
+		h: h,
+	}
+}
+
+// ServeHTTP handles POST requests to reboot a list of hosts or all the
+// nodes at a site, replying with a BatchReport.
+func (b *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	hosts := r.URL.Query().Get("hosts")
+	site := r.URL.Query().Get("site")
+	if (hosts == "") == (site == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Exactly one of URL parameters 'hosts' and 'site' is required"))
+		return
+	}
+
+	method := methodParam(r)
+
+	maxParallelism := b.h.config.BatchParallelism
+	if maxParallelism <= 0 {
+		maxParallelism = defaultBatchParallelism
+	}
+	parallelism, err := intParam(r, "parallelism", maxParallelism)
+	if err == nil && (parallelism == 0 || parallelism > maxParallelism) {
+		err = fmt.Errorf("URL parameter 'parallelism' must be between 1 and %d",
+			maxParallelism)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	maxFailures, err := intParam(r, "max_failures", 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	force, err := boolParam(r, "force")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	dryRun, err := b.h.dryRunParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	attempts, err := attemptsParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ctx := r.Context()
+	if dryRun {
+		ctx = withDryRun(ctx)
+	}
+	if attempts > 0 {
+		ctx = withRetryAttempts(ctx, attempts)
+	}
+
+	var nodes []host.Name
+	if site != "" {
+		nodes, err = b.h.siteNodes(ctx, site)
+		if err != nil {
+			log.WithError(err).Errorf("Cannot list nodes at site %s", site)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("Cannot list nodes at site %s: %v", site, err)))
+			return
+		}
+	} else {
+		nodes, err = parseHosts(hosts)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	nodes = uniqueNodes(nodes)
+	if len(nodes) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("No nodes to reboot"))
+		return
+	}
+
+	log.Infof("Rebooting %d nodes via %s (parallelism: %d, max failures: %d)",
+		len(nodes), method, parallelism, maxFailures)
+	report := b.h.rebootBatch(ctx, nodes, method, force, parallelism, maxFailures)
+	report.Site = site
+	report.DryRun = dryRun
+
+	status := "completed"
+	if report.Stopped {
+		status = "stopped"
+	}
+	metricBatches.WithLabelValues(metricStatus(ctx, status)).Inc()
+	log.Infof("Batch reboot %s: %d succeeded, %d failed, %d refused, %d skipped",
+		status, report.Succeeded, report.Failed, report.Refused, report.Skipped)
+
+	writeJSON(w, http.StatusOK, report)
+}
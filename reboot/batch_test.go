@@ -0,0 +1,180 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+func newBatchTestHandler() *Handler {
+	provider := credstest.NewProvider()
+	for _, hostname := range []string{
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+		"mlab3d-abc0t.mlab-sandbox.measurement-lab.org",
+		"mlab4d-abc0t.mlab-sandbox.measurement-lab.org",
+		"mlab1d-xyz0t.mlab-sandbox.measurement-lab.org",
+	} {
+		provider.AddCredentials(context.Background(), hostname, &creds.Credentials{
+			Hostname: hostname,
+			Address:  "testaddr",
+		})
+	}
+	provider.AddCredentials(context.Background(), "invalid", &creds.Credentials{
+		Hostname: "invalid",
+	})
+	return NewHandler(&Config{BatchParallelism: 4}, provider, &mockConnector{})
+}
+
+func TestBatchHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		url       string
+		status    int
+		succeeded int
+		failed    int
+		skipped   int
+	}{
+		{
+			url:       "/v1/batch?site=abc0t",
+			status:    http.StatusOK,
+			succeeded: 3,
+		},
+		{
+			url: "/v1/batch?hosts=mlab1-abc0t.mlab-sandbox.measurement-lab.org," +
+				"mlab2-abc0t.mlab-sandbox.measurement-lab.org," +
+				"mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+			status:    http.StatusOK,
+			succeeded: 1,
+			failed:    1,
+		},
+		{
+			// mlab2 has no credentials, so the batch stops there.
+			url: "/v1/batch?parallelism=1&max_failures=1&hosts=" +
+				"mlab2-abc0t.mlab-sandbox.measurement-lab.org," +
+				"mlab3-abc0t.mlab-sandbox.measurement-lab.org," +
+				"mlab4-abc0t.mlab-sandbox.measurement-lab.org",
+			status:  http.StatusOK,
+			failed:  1,
+			skipped: 2,
+		},
+		{url: "/v1/batch", status: http.StatusBadRequest},
+		{url: "/v1/batch?site=abc0t&hosts=mlab1.abc0t", status: http.StatusBadRequest},
+		{url: "/v1/batch?hosts=thisshouldfail", status: http.StatusBadRequest},
+		{url: "/v1/batch?site=abc0t&parallelism=0", status: http.StatusBadRequest},
+		{url: "/v1/batch?site=abc0t&parallelism=5", status: http.StatusBadRequest},
+		{url: "/v1/batch?site=abc0t&max_failures=-1", status: http.StatusBadRequest},
+		{url: "/v1/batch?site=abc0t&force=maybe", status: http.StatusBadRequest},
+		{url: "/v1/batch?site=def0t", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		b := NewBatchHandler(newBatchTestHandler())
+		rr := httptest.NewRecorder()
+		b.ServeHTTP(rr, httptest.NewRequest("POST", tt.url, nil))
+		if rr.Code != tt.status {
+			t.Errorf("ServeHTTP(%s) - expected %d, got %d: %s", tt.url,
+				tt.status, rr.Code, rr.Body.String())
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		report := &BatchReport{}
+		if err := json.Unmarshal(rr.Body.Bytes(), report); err != nil {
+			t.Fatalf("cannot unmarshal response: %v", err)
+		}
+		if report.Succeeded != tt.succeeded || report.Failed != tt.failed ||
+			report.Skipped != tt.skipped || report.Stopped != (tt.skipped > 0) {
+			t.Errorf("ServeHTTP(%s) - unexpected report: %+v", tt.url, report)
+		}
+		if len(report.Results) != tt.succeeded+tt.failed+tt.skipped {
+			t.Errorf("ServeHTTP(%s) - unexpected results: %+v", tt.url, report.Results)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	NewBatchHandler(newBatchTestHandler()).ServeHTTP(rr,
+		httptest.NewRequest("GET", "/v1/batch?site=abc0t", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandler_rebootBatch(t *testing.T) {
+	h := newBatchTestHandler()
+	h.config.Cooldown = time.Hour
+	nodes, err := h.siteNodes(context.Background(), "abc0t")
+	if err != nil || len(nodes) != 3 {
+		t.Fatalf("siteNodes() = %v, %v", nodes, err)
+	}
+	nodes = uniqueNodes(nodes)
+
+	report := h.rebootBatch(context.Background(), nodes, "bmc", false, 2, 0)
+	if report.Succeeded != 3 {
+		t.Errorf("rebootBatch() - unexpected report: %+v", report)
+	}
+	want := "mlab1-abc0t.mlab-sandbox.measurement-lab.org"
+	if report.Results[0].Node != want || report.Results[0].Output == "" {
+		t.Errorf("rebootBatch() - unexpected result: %+v", report.Results[0])
+	}
+
+	// The nodes have just been rebooted, so the limits apply.
+	report = h.rebootBatch(context.Background(), nodes, "bmc", false, 2, 1)
+	if report.Refused != 3 || report.Stopped {
+		t.Errorf("rebootBatch() - unexpected report: %+v", report)
+	}
+	report = h.rebootBatch(context.Background(), nodes, "bmc", true, 2, 1)
+	if report.Succeeded != 3 {
+		t.Errorf("rebootBatch() - unexpected report: %+v", report)
+	}
+}
+
+// hostnameConnector records the hostnames it connects to.
+type hostnameConnector struct {
+	mockConnector
+
+	mu        sync.Mutex
+	hostnames []string
+}
+
+func (c *hostnameConnector) NewConnection(config *connector.ConnectionConfig) (connector.Connection, error) {
+	c.mu.Lock()
+	c.hostnames = append(c.hostnames, config.Hostname)
+	c.mu.Unlock()
+	return c.mockConnector.NewConnection(config)
+}
+
+func TestBatchHandler_ServeHTTP_siteHost(t *testing.T) {
+	h := newBatchTestHandler()
+	c := &hostnameConnector{}
+	h.connector = c
+
+	rr := httptest.NewRecorder()
+	NewBatchHandler(h).ServeHTTP(rr,
+		httptest.NewRequest("POST", "/v1/batch?site=abc0t&method=host", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() - expected %d, got %d: %s", http.StatusOK, rr.Code,
+			rr.Body.String())
+	}
+
+	// The credentials list BMCs, but the nodes themselves must be dialed.
+	sort.Strings(c.hostnames)
+	want := []string{
+		"mlab1-abc0t.mlab-sandbox.measurement-lab.org",
+		"mlab3-abc0t.mlab-sandbox.measurement-lab.org",
+		"mlab4-abc0t.mlab-sandbox.measurement-lab.org",
+	}
+	if !reflect.DeepEqual(c.hostnames, want) {
+		t.Errorf("ServeHTTP() dialed %v, want %v", c.hostnames, want)
+	}
+}
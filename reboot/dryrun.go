@@ -0,0 +1,39 @@
+package reboot
+
+import (
+	"context"
+
+	"github.com/m-lab/reboot-service/connector"
+)
+
+type dryRunKey struct{}
+
+// withDryRun returns a context in which operations that would change the
+// state of a node stop right before doing so.
+func withDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// isDryRun returns whether ctx is a dry run context.
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// metricStatus returns the metric status label for an operation, which is
+// prefixed with "dryrun-" for dry runs so that they're counted separately.
+func metricStatus(ctx context.Context, status string) string {
+	if isDryRun(ctx) {
+		return "dryrun-" + status
+	}
+	return status
+}
+
+// describe returns what calling method on conn would have done.
+func describe(conn connector.Connection, method string) string {
+	what := method + "()"
+	if d, ok := conn.(connector.Describer); ok {
+		what = d.Describe(method)
+	}
+	return "Dry run, would have executed: " + what
+}
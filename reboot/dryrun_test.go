@@ -0,0 +1,153 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// describingConnection is a mockConnection that can describe its methods.
+type describingConnection struct {
+	mockConnection
+}
+
+func (c *describingConnection) Describe(method string) string {
+	return "mock " + method
+}
+
+func Test_metricStatus(t *testing.T) {
+	ctx := context.Background()
+	if isDryRun(ctx) || metricStatus(ctx, "ok") != "ok" {
+		t.Errorf("unexpected dry run context")
+	}
+	ctx = withDryRun(ctx)
+	if !isDryRun(ctx) || metricStatus(ctx, "ok") != "dryrun-ok" {
+		t.Errorf("expected a dry run context")
+	}
+}
+
+func Test_describe(t *testing.T) {
+	got := describe(&describingConnection{}, connector.MethodReboot)
+	if got != "Dry run, would have executed: mock Reboot" {
+		t.Errorf("describe() = %q", got)
+	}
+	got = describe(&mockConnection{}, connector.MethodPowerOff)
+	if got != "Dry run, would have executed: PowerOff()" {
+		t.Errorf("describe() = %q", got)
+	}
+}
+
+func TestHandler_dryRun(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	// Every destructive command fails, so dry runs only succeed if they
+	// don't run any.
+	mockConnector := &mockConnector{connMustFail: true}
+	config := &Config{Cooldown: time.Hour}
+	h := NewHandler(config, provider, mockConnector)
+	p := NewPowerHandler(h)
+
+	post := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST",
+			"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org"+query, nil))
+		return rr
+	}
+
+	for _, query := range []string{"&dry_run=true", "&dry_run=true&method=host"} {
+		rr := post(query)
+		if rr.Code != http.StatusOK {
+			t.Errorf("ServeHTTP() %s - expected %d, got %d", query, http.StatusOK, rr.Code)
+		}
+		if rr.Body.String() != "Dry run, would have executed: Reboot()" {
+			t.Errorf("ServeHTTP() %s - unexpected response: %s", query, rr.Body.String())
+		}
+	}
+	rr := post("&dry_run=true&method=auto")
+	if !strings.HasPrefix(rr.Body.String(), "Would have rebooted via host\nDry run") {
+		t.Errorf("ServeHTTP() - unexpected response: %s", rr.Body.String())
+	}
+	if rr := post("&dry_run=maybe"); rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// Connection failures are still reported.
+	mockConnector.mustFail = true
+	if rr := post("&dry_run=true"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+	mockConnector.mustFail = false
+
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&action=powerdown&dry_run=true", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "Dry run, would have executed: PowerOff()" {
+		t.Errorf("ServeHTTP() - unexpected response: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Dry runs don't count towards the limits, but are refused by them.
+	mockConnector.connMustFail = false
+	if rr := post(""); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := post("&dry_run=true"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr := post("&dry_run=true&force=true"); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// The configuration can make every request a dry run.
+	mockConnector.connMustFail = true
+	config.Cooldown = 0
+	config.DryRun = true
+	if rr := post("&dry_run=false"); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestHandler_dryRunAsync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	h := NewHandler(&Config{}, provider, &mockConnector{connMustFail: true})
+	store := NewMemoryJobStore(time.Hour)
+	h.StartJobs(ctx, store, 1, 1)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true&verify=true&dry_run=true", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() - expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	job := &Job{}
+	if err := json.Unmarshal(rr.Body.Bytes(), job); err != nil {
+		t.Fatalf("cannot unmarshal job: %v", err)
+	}
+	if !job.DryRun {
+		t.Errorf("expected a dry run job: %+v", job)
+	}
+
+	// There is nothing to verify after a dry run.
+	job = waitForJob(t, store, job.ID)
+	if job.Status != JobSucceeded || job.Verification != nil ||
+		!strings.HasPrefix(job.Output, "Dry run") {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
@@ -2,8 +2,10 @@ package reboot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -57,6 +59,38 @@ type Config struct {
 
 	RebootUser     string
 	PrivateKeyPath string
+
+	// VerifyInterval is how often a node is checked when verifying a
+	// reboot. VerifyDownTimeout and VerifyUpTimeout are how long to wait
+	// for the node to go down and to come back. Zero means the default.
+	VerifyInterval    time.Duration
+	VerifyDownTimeout time.Duration
+	VerifyUpTimeout   time.Duration
+
+	// Cooldown is the minimum time between two reboots of the same node.
+	// MaxNodeReboots and MaxSiteReboots limit the number of reboots per
+	// node and per site within BudgetWindow. Zero values disable a limit.
+	Cooldown       time.Duration
+	MaxNodeReboots int
+	MaxSiteReboots int
+	BudgetWindow   time.Duration
+
+	// BatchParallelism is the default and maximum number of nodes rebooted
+	// in parallel by a batch reboot.
+	BatchParallelism int
+
+	// AutoGracePeriod is how long method=auto waits for a node to go down
+	// after a host reboot before escalating to the BMC. Zero means the
+	// default.
+	AutoGracePeriod time.Duration
+
+	// DryRun makes every reboot and power action a dry run, as if
+	// dry_run=true had been requested.
+	DryRun bool
+
+	// Retry is how connections to nodes and BMCs, and commands that could
+	// not be delivered, are retried. Requests can override its Attempts.
+	Retry connector.RetryPolicy
 }
 
 // NewHandler creates a new Handler for the /v1/reboot endpoint.
@@ -76,6 +110,72 @@ type Handler struct {
 
 	credsProvider creds.Provider
 	connector     connector.Connector
+
+	jobs     *jobQueue
+	limiter  rebootLimiter
+	inflight inflightOps
+
+	// sshProbe reports whether a node's SSH server is up. If nil,
+	// sshBannerUp is used.
+	sshProbe func(ctx context.Context, addr string) bool
+}
+
+// StartJobs makes reboots asynchronous unless async=false. Jobs are kept in
+// store and run by the given number of workers until ctx is canceled. At
+// most queueSize jobs can wait for a worker at any time.
+func (h *Handler) StartJobs(ctx context.Context, store JobStore, workers, queueSize int) {
+	h.jobs = newJobQueue(store, queueSize)
+	h.jobs.start(ctx, workers)
+}
+
+// connectErrorStatus returns the metric status for a failed connection.
+func connectErrorStatus(err error) string {
+	switch {
+	case errors.Is(err, connector.ErrHostKeyMismatch):
+		return "error-host-key-mismatch"
+	case errors.Is(err, connector.ErrUnknownModel):
+		return "error-unknown-model"
+	case errors.Is(err, connector.ErrDNS):
+		return "error-dns"
+	case errors.Is(err, connector.ErrConnectionRefused):
+		return "error-connection-refused"
+	case errors.Is(err, connector.ErrTimeout):
+		return "error-timeout"
+	case errors.Is(err, connector.ErrAuthRejected):
+		return "error-auth"
+	case errors.Is(err, connector.ErrCircuitOpen):
+		return "error-circuit-open"
+	case errors.Is(err, context.Canceled):
+		return "error-canceled"
+	}
+	return "error-connect"
+}
+
+// commandErrorStatus returns the metric status for a failed command, or def
+// if the failure has no specific category.
+func commandErrorStatus(err error, def string) string {
+	switch {
+	case errors.Is(err, connector.ErrSession):
+		return "error-session"
+	case errors.Is(err, connector.ErrNonZeroExit):
+		return "error-exit"
+	case errors.Is(err, connector.ErrRACADM):
+		return "error-racadm"
+	case errors.Is(err, connector.ErrTimeout):
+		return "error-timeout"
+	case errors.Is(err, context.Canceled):
+		return "error-canceled"
+	}
+	return def
+}
+
+// wrapConnectError prefixes host key mismatches with a fixed string, so
+// that clients can tell them apart from other connection failures.
+func wrapConnectError(err error) error {
+	if errors.Is(err, connector.ErrHostKeyMismatch) {
+		err = fmt.Errorf("host_key_mismatch: %w", err)
+	}
+	return withCode(CodeConnectFailed, err)
 }
 
 func (h *Handler) rebootHost(ctx context.Context, node host.Name) (string, error) {
@@ -89,67 +189,101 @@ func (h *Handler) rebootHost(ctx context.Context, node host.Name) (string, error
 		ConnType:       connector.HostConnection,
 	}
 
-	conn, err := h.connector.NewConnection(connectionConfig)
+	conn, err := h.connect(ctx, connectionConfig)
 	if err != nil {
 		log.WithError(err).
 			Errorf("Cannot connect to host: %s:%d with username %s",
 				connectionConfig.Hostname, connectionConfig.Port, connectionConfig.Username)
-		metricHostReboots.WithLabelValues(node.Site, node.Machine, "error-connect").Inc()
-		return "", err
+		metricHostReboots.WithLabelValues(node.Site, node.Machine,
+			metricStatus(ctx, connectErrorStatus(err))).Inc()
+		return "", wrapConnectError(err)
 	}
 	defer conn.Close()
 
-	_, err = conn.Reboot()
+	if isDryRun(ctx) {
+		metricHostReboots.WithLabelValues(node.Site, node.Machine, metricStatus(ctx, "ok")).Inc()
+		return describe(conn, connector.MethodReboot), nil
+	}
+
+	_, err = h.run(ctx, "rebooting "+node.String(), func() (string, error) {
+		return connector.RebootContext(ctx, conn)
+	})
 	if err != nil {
 		log.WithError(err).Errorf("Cannot issue reboot command (type: %v)", connectionConfig.ConnType)
-		metricHostReboots.WithLabelValues(node.Site, node.Machine, "error-reboot").Inc()
-		return "", err
+		metricHostReboots.WithLabelValues(node.Site, node.Machine,
+			commandErrorStatus(err, "error-reboot")).Inc()
+		return "", withCode(CodeCommandFailed, err)
 	}
 
 	metricHostReboots.WithLabelValues(node.Site, node.Machine, "ok").Inc()
 	return "System reboot successful", nil
 }
 
-func (h *Handler) rebootBMC(ctx context.Context, node host.Name) (string, error) {
-	// BMC machine names are always suffixed with 'd'.
+// bmcNode returns the name of node's BMC. BMC machine names are always
+// suffixed with 'd'.
+func bmcNode(node host.Name) host.Name {
 	if !strings.HasSuffix(node.Machine, "d") {
 		node.Machine = node.Machine + "d"
 	}
+	return node
+}
 
+// bmcConnectionConfig returns the ConnectionConfig for the BMC of node,
+// based on its credentials. The BMC's stored address is dialed, or its
+// hostname if there is none.
+func (h *Handler) bmcConnectionConfig(ctx context.Context, node host.Name) (*connector.ConnectionConfig, error) {
 	// Retrieve credentials from the credentials provider.
 	creds, err := h.credsProvider.FindCredentials(ctx, node.String())
 	if err != nil {
 		log.WithError(err).Errorf("Cannot retrieve credentials for host: %v", node.String())
-		return "", err
+		return nil, withCode(CodeCredentialsError, err)
 	}
 
-	// Make a connection to the host
-	connectionConfig := &connector.ConnectionConfig{
-		Hostname:       creds.Address,
+	return &connector.ConnectionConfig{
+		Hostname:       creds.DialAddress(),
 		Username:       creds.Username,
 		Password:       creds.Password,
 		Port:           h.config.BMCPort,
 		PrivateKeyFile: h.config.PrivateKeyPath,
+		Model:          creds.Model,
 		ConnType:       connector.BMCConnection,
 		Timeout:        bmcTimeout,
+	}, nil
+}
+
+func (h *Handler) rebootBMC(ctx context.Context, node host.Name) (string, error) {
+	node = bmcNode(node)
+	connectionConfig, err := h.bmcConnectionConfig(ctx, node)
+	if err != nil {
+		return "", err
 	}
 
-	conn, err := h.connector.NewConnection(connectionConfig)
+	// Make a connection to the host
+	conn, err := h.connect(ctx, connectionConfig)
 	if err != nil {
 		log.WithError(err).
 			Errorf("Cannot connect to DRAC: %s:%d with username %s",
 				connectionConfig.Hostname, connectionConfig.Port, connectionConfig.Username)
-		metricBMCReboots.WithLabelValues(node.Site, node.Machine, "error-connect").Inc()
-		return "", err
+		metricBMCReboots.WithLabelValues(node.Site, node.Machine,
+			metricStatus(ctx, connectErrorStatus(err))).Inc()
+		return "", wrapConnectError(err)
 	}
 	defer conn.Close()
 
+	if isDryRun(ctx) {
+		metricBMCReboots.WithLabelValues(node.Site, node.Machine, metricStatus(ctx, "ok")).Inc()
+		return describe(conn, connector.MethodReboot), nil
+	}
+
 	start := time.Now()
-	output, err := conn.Reboot()
+	output, err := h.run(ctx, "rebooting "+node.String(), func() (string, error) {
+		return connector.RebootContext(ctx, conn)
+	})
 	if err != nil {
 		log.WithError(err).Errorf("Cannot issue reboot command")
-		metricBMCReboots.WithLabelValues(node.Site, node.Machine, "error-reboot").Inc()
-		return "", err
+		metricBMCReboots.WithLabelValues(node.Site, node.Machine,
+			commandErrorStatus(err, "error-reboot")).Inc()
+		return "", withCode(CodeCommandFailed, err)
 	}
 
 	metricBMCReboots.WithLabelValues(node.Site, node.Machine, "ok").Inc()
@@ -157,17 +291,194 @@ func (h *Handler) rebootBMC(ctx context.Context, node host.Name) (string, error)
 	return output, nil
 }
 
-// ServeHTTP handles POST requests to the /reboot endpoint
+// reboot reboots node with the requested method. The default method is
+// the BMC.
+func (h *Handler) reboot(ctx context.Context, node host.Name, method string) (string, error) {
+	switch method {
+	case "host":
+		return h.rebootHost(ctx, node)
+	case "auto":
+		return h.rebootAuto(ctx, node)
+	}
+	return h.rebootBMC(ctx, node)
+}
+
+// methodParam returns the reboot method requested by r: "host", "auto" or
+// "bmc", which is the default.
+func methodParam(r *http.Request) string {
+	switch method := r.URL.Query().Get("method"); method {
+	case "host", "auto":
+		return method
+	}
+	return "bmc"
+}
+
+// rebootErrorStatus returns the HTTP status code for a failed reboot.
+func rebootErrorStatus(err error) int {
+	// Unknown BMC models are a problem with the request's target rather
+	// than with the BMC, so they are reported as a client error.
+	if errors.Is(err, connector.ErrUnknownModel) {
+		return http.StatusBadRequest
+	}
+	// The BMC has been unreachable recently and hasn't been tried again.
+	if errors.Is(err, connector.ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// reserve checks the reboot limits for node and records a new reboot. If
+// force is true, the limits are ignored and the reboot is only logged. Dry
+// runs check the limits without recording anything.
+func (h *Handler) reserve(ctx context.Context, node host.Name, force bool) (func(), error) {
+	if isDryRun(ctx) {
+		if err := h.limiter.check(h.config, node); err != nil && !force {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+
+	release, reason, err := h.limiter.reserve(h.config, node, force)
+	if err != nil {
+		log.WithError(err).Warnf("Refusing to reboot %s", node.String())
+		return nil, err
+	}
+	if force {
+		if reason == "" {
+			reason = "none"
+		}
+		log.Warnf("Forced reboot of %s (bypassed limit: %s)", node.String(), reason)
+		metricForced.WithLabelValues(node.Site, hostNode(node).Machine, reason).Inc()
+	}
+	return release, nil
+}
+
+// submitJob queues job, an asynchronous reboot of node, and replies with
+// it. If any checks are given, the job also verifies that the node came
+// back. finish is called once the job is over, with whether the node has
+// been rebooted.
+func (h *Handler) submitJob(rep *rebootReply, job *Job, node host.Name,
+	checks []string, finish func(rebooted bool)) {
+
+	err := h.jobs.submit(job, func(ctx context.Context, job *Job) (string, error) {
+		if job.DryRun {
+			ctx = withDryRun(ctx)
+		}
+		if job.Attempts > 0 {
+			ctx = withRetryAttempts(ctx, job.Attempts)
+		}
+		start := time.Now()
+		output, err := h.reboot(ctx, node, job.Method)
+		if err != nil {
+			finish(false)
+			return "", err
+		}
+		defer finish(true)
+		// Nothing has been rebooted in a dry run, so there's nothing to
+		// verify.
+		if len(checks) > 0 && !job.DryRun {
+			return output, h.verifyJob(ctx, job, node, start, checks)
+		}
+		return output, nil
+	})
+	if err != nil {
+		finish(false)
+		log.WithError(err).Error("Cannot queue reboot job")
+		rep.failErr(err)
+		return
+	}
+
+	log.Infof("Queued %s reboot of %s as job %s", job.Method, node.String(), job.ID)
+	rep.w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(rep.w, http.StatusAccepted, job)
+}
+
+// lockNode registers op as in flight on node and reserves a reboot, unless
+// another operation is in flight or the reboot limits have been reached.
+// The returned function must be called once the operation is over, with
+// whether the node has actually been rebooted.
+func (h *Handler) lockNode(ctx context.Context, node host.Name, op Operation,
+	force bool) (func(rebooted bool), error) {
+
+	op.DryRun = isDryRun(ctx)
+	unlock, err := h.inflight.acquire(node, op)
+	if err != nil {
+		log.WithError(err).Warnf("Refusing to reboot %s", node.String())
+		return nil, err
+	}
+	release, err := h.reserve(ctx, node, force)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return func(rebooted bool) {
+		if !rebooted {
+			release()
+		}
+		unlock()
+	}, nil
+}
+
+// rebootNode synchronously reboots node with the requested method, unless
+// refused by lockNode.
+func (h *Handler) rebootNode(ctx context.Context, node host.Name, method string, force bool) (string, error) {
+	finish, err := h.lockNode(ctx, node, Operation{Operation: "reboot", Method: method}, force)
+	if err != nil {
+		return "", err
+	}
+	output, err := h.reboot(ctx, node, method)
+	finish(err == nil)
+	return output, err
+}
+
+// writeRefused replies to an operation refused by lockNode: with 429 Too
+// Many Requests and a Retry-After header if the reboot limits have been
+// reached, or with 409 Conflict and the details of the operation in flight.
+func writeRefused(w http.ResponseWriter, err error) {
+	var rle *RateLimitError
+	if errors.As(err, &rle) {
+		w.Header().Set("Retry-After", rle.retryAfterHeader())
+		w.WriteHeader(http.StatusTooManyRequests)
+	} else {
+		w.WriteHeader(http.StatusConflict)
+	}
+	w.Write([]byte(fmt.Sprintf("Operation refused: %v", err)))
+}
+
+// boolParam parses an optional boolean URL parameter.
+func boolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("URL parameter '%s' must be a boolean", name)
+	}
+	return b, nil
+}
+
+// dryRunParam returns whether r requests a dry run, which is always the
+// case if the handler is configured for dry runs only.
+func (h *Handler) dryRunParam(r *http.Request) (bool, error) {
+	dryRun, err := boolParam(r, "dry_run")
+	return dryRun || h.config.DryRun, err
+}
+
+// ServeHTTP handles POST requests to the /reboot endpoint. Once jobs are
+// started, the reboot is queued and the response is the new Job, unless
+// async=false. The response to a synchronous reboot is a RebootResponse if
+// the client accepts application/json, and plain text otherwise.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rep := newRebootReply(w, r)
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		rep.fail(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "")
 		return
 	}
 
 	target := r.URL.Query().Get("host")
 	if len(target) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("URL parameter 'host' is missing"))
+		rep.fail(http.StatusBadRequest, CodeBadRequest, "URL parameter 'host' is missing")
 		log.Info("URL parameter 'host' is missing")
 		return
 	}
@@ -178,28 +489,109 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		errStr := fmt.Sprintf(
 			"The specified hostname is not a valid M-Lab node: %s", target)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(errStr))
+		rep.fail(http.StatusBadRequest, CodeInvalidHost, errStr)
 		log.Errorf(errStr)
 		return
 	}
+	rep.resp.Node = node.String()
 
-	method := r.URL.Query().Get("method")
-	var output string
-	if method == "host" {
-		output, err = h.rebootHost(context.Background(), node)
-	} else { // default method is DRAC
-		output, err = h.rebootBMC(context.Background(), node)
+	method := methodParam(r)
+	rep.resp.Method = method
+
+	checks, err := ParseChecks(r.URL.Query().Get("verify"))
+	if err != nil {
+		rep.fail(http.StatusBadRequest, CodeBadRequest,
+			fmt.Sprintf("URL parameter 'verify' is invalid: %v", err))
+		return
+	}
+
+	// Reboots are queued as jobs whenever jobs are enabled, unless the
+	// client asks for a synchronous reboot with async=false.
+	async := h.jobs != nil
+	if r.URL.Query().Get("async") != "" {
+		async, err = boolParam(r, "async")
+	}
+	if err != nil {
+		rep.fail(http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	// Verification can take several minutes, so it is only supported for
+	// asynchronous reboots.
+	if len(checks) > 0 && !async {
+		rep.fail(http.StatusBadRequest, CodeBadRequest,
+			"URL parameter 'verify' requires an asynchronous reboot")
+		return
+	}
+	if async && h.jobs == nil {
+		rep.fail(http.StatusBadRequest, CodeBadRequest,
+			"Asynchronous reboots are not enabled")
+		return
+	}
+
+	force, err := boolParam(r, "force")
+	if err != nil {
+		rep.fail(http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	dryRun, err := h.dryRunParam(r)
+	if err != nil {
+		rep.fail(http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	rep.resp.DryRun = dryRun
+	attempts, err := attemptsParam(r)
+	if err != nil {
+		rep.fail(http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	ctx := r.Context()
+	if dryRun {
+		ctx = withDryRun(ctx)
+	}
+	if attempts > 0 {
+		ctx = withRetryAttempts(ctx, attempts)
 	}
 
+	if async {
+		id, err := newJobID()
+		if err != nil {
+			log.WithError(err).Error("Cannot generate job ID")
+			rep.fail(http.StatusInternalServerError, CodeInternal,
+				"Cannot generate job ID")
+			return
+		}
+		job := &Job{
+			ID:       id,
+			Node:     node.String(),
+			Method:   method,
+			DryRun:   dryRun,
+			Attempts: attempts,
+		}
+		finish, err := h.lockNode(ctx, node, Operation{
+			Operation: "reboot",
+			Method:    method,
+			JobID:     id,
+		}, force)
+		if err != nil {
+			rep.failErr(err)
+			return
+		}
+		h.submitJob(rep, job, node, checks, finish)
+		return
+	}
+
+	output, err := h.rebootNode(ctx, node, method, force)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprintf("Reboot failed: %v", err)))
-		log.WithError(err).Error("Reboot failed")
+		rep.failErr(err)
 		return
 	}
 
-	log.WithField("output", output).Infof("%v rebooted successfully.",
-		node.String())
-	w.Write([]byte(output))
+	if dryRun {
+		log.WithField("output", output).Infof("Dry run of %v reboot successful.",
+			node.String())
+	} else {
+		log.WithField("output", output).Infof("%v rebooted successfully.",
+			node.String())
+	}
+	rep.ok(output)
 }
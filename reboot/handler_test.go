@@ -2,12 +2,15 @@ package reboot
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/m-lab/go/host"
 	"github.com/m-lab/reboot-service/creds"
 	"github.com/m-lab/reboot-service/creds/credstest"
 
@@ -18,13 +21,19 @@ import (
 type mockConnector struct {
 	mustFail     bool
 	connMustFail bool
+	err          error
 }
 
 type mockConnection struct {
 	mustFail bool
+	// rebootErr, if not nil, is returned by Reboot.
+	rebootErr error
 }
 
 func (connector *mockConnector) NewConnection(*connector.ConnectionConfig) (connector.Connection, error) {
+	if connector.err != nil {
+		return nil, connector.err
+	}
 	if connector.mustFail {
 		return nil, errors.New("method NewConnection() failed")
 	}
@@ -38,12 +47,45 @@ func (connection *mockConnection) ExecDRACShell(string) (string, error) {
 }
 
 func (connection *mockConnection) Reboot() (string, error) {
+	if connection.rebootErr != nil {
+		return "", connection.rebootErr
+	}
 	if connection.mustFail {
 		return "", errors.New("method Reboot() failed")
 	}
 	return "Server power operation successful", nil
 }
 
+func (connection *mockConnection) power(method string) (string, error) {
+	if connection.mustFail {
+		return "", fmt.Errorf("method %s() failed", method)
+	}
+	return "Server power operation successful", nil
+}
+
+func (connection *mockConnection) PowerOn() (string, error) {
+	return connection.power("PowerOn")
+}
+
+func (connection *mockConnection) PowerOff() (string, error) {
+	return connection.power("PowerOff")
+}
+
+func (connection *mockConnection) HardReset() (string, error) {
+	return connection.power("HardReset")
+}
+
+func (connection *mockConnection) GracefulShutdown() (string, error) {
+	return connection.power("GracefulShutdown")
+}
+
+func (connection *mockConnection) PowerStatus() (string, error) {
+	if connection.mustFail {
+		return "", errors.New("method PowerStatus() failed")
+	}
+	return connector.PowerStatusOn, nil
+}
+
 func (connection *mockConnection) Close() error {
 	return nil
 }
@@ -60,6 +102,7 @@ func TestServeHTTP(t *testing.T) {
 		body               string
 		connectorMustFail  bool
 		connectionMustFail bool
+		connectorErr       error
 	}{
 		{
 			req: httptest.NewRequest("POST",
@@ -115,6 +158,20 @@ func TestServeHTTP(t *testing.T) {
 			status:             http.StatusInternalServerError,
 			body:               "",
 		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil),
+			connectorErr: fmt.Errorf("%w: testaddr", connector.ErrHostKeyMismatch),
+			status:       http.StatusInternalServerError,
+			body:         "Reboot failed: host_key_mismatch: host key mismatch: testaddr",
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil),
+			connectorErr: fmt.Errorf("%w: \"hp\"", connector.ErrUnknownModel),
+			status:       http.StatusBadRequest,
+			body:         "Reboot failed: unknown BMC model: \"hp\"",
+		},
 		{
 			req:    httptest.NewRequest("POST", "/v1/reboot?host=thisshouldfail", nil),
 			status: http.StatusBadRequest,
@@ -122,7 +179,7 @@ func TestServeHTTP(t *testing.T) {
 		},
 	}
 
-	connector := &mockConnector{}
+	mockConnector := &mockConnector{}
 
 	// Create a FakeProvider and populate it with fake Credentials.
 	provider := credstest.NewProvider()
@@ -143,20 +200,22 @@ func TestServeHTTP(t *testing.T) {
 			SSHPort:        22,
 			Namespace:      "test",
 		},
-		connector:     connector,
+		connector:     mockConnector,
 		credsProvider: provider,
 	}
 
 	for _, test := range tests {
 		rr := httptest.NewRecorder()
 
-		connector.mustFail = test.connectorMustFail
-		connector.connMustFail = test.connectionMustFail
+		mockConnector.mustFail = test.connectorMustFail
+		mockConnector.connMustFail = test.connectionMustFail
+		mockConnector.err = test.connectorErr
 
 		h.ServeHTTP(rr, test.req)
 
-		connector.mustFail = false
-		connector.connMustFail = false
+		mockConnector.mustFail = false
+		mockConnector.connMustFail = false
+		mockConnector.err = nil
 
 		resp := rr.Result()
 
@@ -183,3 +242,109 @@ func TestServeHTTP(t *testing.T) {
 func TestNewHandler(t *testing.T) {
 	NewHandler(&Config{}, credstest.NewProvider(), &mockConnector{})
 }
+
+func Test_errorStatuses(t *testing.T) {
+	connectErrors := map[error]string{
+		errors.New("EOF"):              "error-connect",
+		connector.ErrHostKeyMismatch:   "error-host-key-mismatch",
+		connector.ErrUnknownModel:      "error-unknown-model",
+		connector.ErrDNS:               "error-dns",
+		connector.ErrConnectionRefused: "error-connection-refused",
+		connector.ErrTimeout:           "error-timeout",
+		connector.ErrAuthRejected:      "error-auth",
+		connector.ErrCircuitOpen:       "error-circuit-open",
+		context.Canceled:               "error-canceled",
+	}
+	for err, want := range connectErrors {
+		if got := connectErrorStatus(fmt.Errorf("wrapped: %w", err)); got != want {
+			t.Errorf("connectErrorStatus(%v) = %s, want %s", err, got, want)
+		}
+	}
+
+	commandErrors := map[error]string{
+		errors.New("EOF"):        "error-reboot",
+		connector.ErrSession:     "error-session",
+		connector.ErrNonZeroExit: "error-exit",
+		connector.ErrRACADM:      "error-racadm",
+		connector.ErrTimeout:     "error-timeout",
+		context.Canceled:         "error-canceled",
+	}
+	for err, want := range commandErrors {
+		if got := commandErrorStatus(fmt.Errorf("wrapped: %w", err), "error-reboot"); got != want {
+			t.Errorf("commandErrorStatus(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestHandler_canceledRequest(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	h := NewHandler(&Config{}, provider, &mockConnector{})
+
+	// A client that went away doesn't get anything rebooted.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil).WithContext(ctx)
+	r.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	var got RebootResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("cannot unmarshal response: %v", err)
+	}
+	if got.Status != ResponseFailed || got.Code != CodeCanceled {
+		t.Errorf("unexpected response: %+v", got)
+	}
+}
+
+func Test_rebootErrorStatus(t *testing.T) {
+	statuses := map[error]int{
+		errors.New("EOF"):         http.StatusInternalServerError,
+		connector.ErrUnknownModel: http.StatusBadRequest,
+		connector.ErrCircuitOpen:  http.StatusServiceUnavailable,
+	}
+	for err, want := range statuses {
+		if got := rebootErrorStatus(wrapConnectError(err)); got != want {
+			t.Errorf("rebootErrorStatus(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
+
+func TestHandler_bmcConnectionConfig(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t.measurement-lab.org",
+			Address:  "192.168.0.1",
+		})
+	provider.AddCredentials(context.Background(),
+		"mlab2d.abc0t.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab2d.abc0t.measurement-lab.org",
+		})
+	h := NewHandler(&Config{BMCPort: 806}, provider, &mockConnector{})
+
+	want := map[string]string{
+		"mlab1.abc0t.measurement-lab.org": "192.168.0.1",
+		// With no stored address, the hostname is resolved via DNS.
+		"mlab2.abc0t.measurement-lab.org": "mlab2d.abc0t.measurement-lab.org",
+	}
+	for hostname, address := range want {
+		node, err := host.Parse(hostname)
+		if err != nil {
+			t.Fatalf("host.Parse() failed: %v", err)
+		}
+		config, err := h.bmcConnectionConfig(context.Background(), bmcNode(node))
+		if err != nil {
+			t.Fatalf("bmcConnectionConfig() unexpected error: %v", err)
+		}
+		if config.Hostname != address {
+			t.Errorf("bmcConnectionConfig(%s) dials %s, want %s", hostname,
+				config.Hostname, address)
+		}
+	}
+}
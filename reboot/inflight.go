@@ -0,0 +1,135 @@
+package reboot
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricConflicts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reboot_conflicts_total",
+			Help: "Total number of requests refused because of an operation in flight on the same node",
+		},
+		[]string{
+			"site",
+			"operation",
+		},
+	)
+	metricInflight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "reboot_inflight_operations",
+		Help: "Number of operations currently in flight",
+	})
+)
+
+// Operation is an operation in flight on a node.
+type Operation struct {
+	Node string `json:"node"`
+	// Operation is "reboot" or a power action.
+	Operation string `json:"operation"`
+	// Method is "bmc", "host" or "auto".
+	Method  string    `json:"method"`
+	JobID   string    `json:"job_id,omitempty"`
+	DryRun  bool      `json:"dry_run,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// ConflictError is returned when an operation is requested for a node that
+// already has one in flight.
+type ConflictError struct {
+	InFlight Operation
+}
+
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("%s via %s already in progress on %s since %s",
+		e.InFlight.Operation, e.InFlight.Method, e.InFlight.Node,
+		e.InFlight.Started.UTC().Format(time.RFC3339))
+	if e.InFlight.JobID != "" {
+		msg += " (job " + e.InFlight.JobID + ")"
+	}
+	return msg
+}
+
+// inflightOps allows at most one operation in flight per node. The zero
+// value is ready to use.
+type inflightOps struct {
+	mu  sync.Mutex
+	ops map[string]Operation
+}
+
+// acquire registers op as in flight on node. If node already has an
+// operation in flight, a ConflictError is returned. Otherwise, the returned
+// function must be called once the operation is over.
+func (o *inflightOps) acquire(node host.Name, op Operation) (func(), error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.ops == nil {
+		o.ops = make(map[string]Operation)
+	}
+
+	key := nodeKey(node)
+	if existing, ok := o.ops[key]; ok {
+		metricConflicts.WithLabelValues(node.Site, op.Operation).Inc()
+		return nil, &ConflictError{InFlight: existing}
+	}
+
+	op.Node = hostNode(node).String()
+	op.Started = time.Now()
+	o.ops[key] = op
+	metricInflight.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			delete(o.ops, key)
+			metricInflight.Dec()
+		})
+	}, nil
+}
+
+// list returns the operations in flight, sorted by node.
+func (o *inflightOps) list() []Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ops := make([]Operation, 0, len(o.ops))
+	for _, op := range o.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Node < ops[j].Node
+	})
+	return ops
+}
+
+// InflightHandler is the HTTP handler for /v1/inflight.
+type InflightHandler struct {
+	h *Handler
+}
+
+// NewInflightHandler returns an InflightHandler listing the operations in
+// flight on the reboot and power endpoints served by h.
+func NewInflightHandler(h *Handler) *InflightHandler {
+	return &InflightHandler{
+		h: h,
+	}
+}
+
+// ServeHTTP handles GET requests, writing the operations in flight as JSON.
+func (i *InflightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, i.h.inflight.list())
+}
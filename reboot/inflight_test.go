@@ -0,0 +1,204 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// blockingConnector returns connections whose Reboot blocks until release
+// is closed.
+type blockingConnector struct {
+	release chan struct{}
+}
+
+type blockingConnection struct {
+	mockConnection
+	release chan struct{}
+}
+
+func (c *blockingConnector) NewConnection(*connector.ConnectionConfig) (connector.Connection, error) {
+	return &blockingConnection{release: c.release}, nil
+}
+
+func (c *blockingConnection) Reboot() (string, error) {
+	<-c.release
+	return c.mockConnection.Reboot()
+}
+
+func Test_inflightOps(t *testing.T) {
+	o := &inflightOps{}
+	mlab1, _ := host.Parse("mlab1-abc0t.mlab-sandbox.measurement-lab.org")
+	mlab1d, _ := host.Parse("mlab1d-abc0t.mlab-sandbox.measurement-lab.org")
+	mlab2, _ := host.Parse("mlab2-abc0t.mlab-sandbox.measurement-lab.org")
+
+	unlock, err := o.acquire(mlab1d, Operation{Operation: "reboot", Method: "bmc", JobID: "abc"})
+	if err != nil {
+		t.Fatalf("acquire() returned err: %v", err)
+	}
+	unlock2, err := o.acquire(mlab2, Operation{Operation: ActionPowerDown, Method: "bmc"})
+	if err != nil {
+		t.Fatalf("acquire() returned err: %v", err)
+	}
+
+	// The host and its BMC are the same node.
+	_, err = o.acquire(mlab1, Operation{Operation: "reboot", Method: "host"})
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("acquire() expected ConflictError, got %v", err)
+	}
+	if conflict.InFlight.JobID != "abc" || conflict.InFlight.Method != "bmc" {
+		t.Errorf("unexpected operation in flight: %+v", conflict.InFlight)
+	}
+	if !strings.Contains(err.Error(), "reboot via bmc already in progress on mlab1-abc0t") ||
+		!strings.Contains(err.Error(), "(job abc)") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ops := o.list()
+	if len(ops) != 2 || ops[0].Node != mlab1.String() || ops[1].Operation != ActionPowerDown {
+		t.Errorf("unexpected operations: %+v", ops)
+	}
+
+	// Unlocking twice is harmless.
+	unlock()
+	unlock()
+	unlock2()
+	if len(o.list()) != 0 {
+		t.Errorf("unexpected operations: %+v", o.list())
+	}
+	if _, err = o.acquire(mlab1, Operation{Operation: "reboot", Method: "host"}); err != nil {
+		t.Errorf("acquire() returned err: %v", err)
+	}
+}
+
+func TestHandler_conflicts(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	release := make(chan struct{})
+	h := NewHandler(&Config{}, provider, &blockingConnector{release: release})
+	p := NewPowerHandler(h)
+	inflight := NewInflightHandler(h)
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST",
+			"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil))
+		done <- rr.Code
+	}()
+
+	// Wait for the first reboot to be in flight.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.inflight.list()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the reboot is not in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&method=host", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusConflict, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "reboot via bmc already in progress") {
+		t.Errorf("ServeHTTP() - unexpected response: %s", rr.Body.String())
+	}
+
+	// Power actions conflict as well, but reading the power status doesn't.
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&action=powerdown", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusConflict, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET",
+		"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	inflight.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/inflight", nil))
+	var ops []Operation
+	if err := json.Unmarshal(rr.Body.Bytes(), &ops); err != nil {
+		t.Fatalf("cannot unmarshal response: %v", err)
+	}
+	if len(ops) != 1 || ops[0].Operation != "reboot" || ops[0].Method != "bmc" {
+		t.Errorf("unexpected operations: %+v", ops)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, code)
+	}
+	if len(h.inflight.list()) != 0 {
+		t.Errorf("unexpected operations: %+v", h.inflight.list())
+	}
+
+	rr = httptest.NewRecorder()
+	inflight.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/inflight", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandler_conflictsAsync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	release := make(chan struct{})
+	h := NewHandler(&Config{}, provider, &blockingConnector{release: release})
+	store := NewMemoryJobStore(time.Hour)
+	h.StartJobs(ctx, store, 1, 1)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() - expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	job := &Job{}
+	json.Unmarshal(rr.Body.Bytes(), job)
+
+	// The node is locked until the job is over.
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true", nil))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusConflict, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "(job "+job.ID+")") {
+		t.Errorf("ServeHTTP() - unexpected response: %s", rr.Body.String())
+	}
+
+	close(release)
+	waitForJob(t, store, job.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.inflight.list()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the node is still locked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,345 @@
+package reboot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ErrJobNotFound is returned by a JobStore when a job does not exist.
+	ErrJobNotFound = errors.New("job not found")
+
+	// ErrQueueFull is returned when a job cannot be queued because all the
+	// workers are busy and the queue is full.
+	ErrQueueFull = errors.New("job queue is full")
+
+	// ErrShuttingDown is the error of jobs that were still queued when the
+	// workers stopped, and of jobs submitted afterwards.
+	ErrShuttingDown = errors.New("service shutting down")
+)
+
+var (
+	metricJobs = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reboot_jobs_total",
+			Help: "Total number of asynchronous reboot jobs, by final status",
+		},
+		[]string{
+			"method",
+			"status",
+		},
+	)
+	metricJobsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "reboot_jobs_queued",
+		Help: "Number of reboot jobs waiting for a worker",
+	})
+)
+
+// JobStatus is the status of a reboot Job.
+type JobStatus string
+
+// Possible job statuses.
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an asynchronous reboot request.
+type Job struct {
+	ID     string    `json:"id"`
+	Node   string    `json:"node"`
+	Method string    `json:"method"`
+	DryRun bool      `json:"dry_run,omitempty"`
+	Status JobStatus `json:"status"`
+
+	// Attempts overrides the configured number of attempts, if not zero.
+	Attempts int `json:"attempts,omitempty"`
+
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Verification is the outcome of the post-reboot verification, if it
+	// was requested.
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// done returns whether the job has finished running.
+func (j *Job) done() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// JobStore stores reboot Jobs. Implementations must be safe for concurrent
+// use and must not retain or return the caller's *Job, so that callers can
+// keep modifying it.
+type JobStore interface {
+	// Put creates or replaces a Job.
+	Put(*Job) error
+	// Get returns the Job with the given ID, or ErrJobNotFound.
+	Get(id string) (*Job, error)
+}
+
+type memoryJobStore struct {
+	retention time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewMemoryJobStore returns an in-memory JobStore. Finished jobs are
+// removed after the given retention period.
+func NewMemoryJobStore(retention time.Duration) JobStore {
+	return &memoryJobStore{
+		retention: retention,
+		jobs:      make(map[string]*Job),
+	}
+}
+
+func (s *memoryJobStore) Put(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, j := range s.jobs {
+		if j.done() && time.Since(*j.Finished) > s.retention {
+			delete(s.jobs, id)
+		}
+	}
+
+	j := *job
+	s.jobs[job.ID] = &j
+	return nil
+}
+
+func (s *memoryJobStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := *j
+	return &job, nil
+}
+
+// newJobID returns a random job ID.
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// runFunc runs a job, returning its output. It can record additional
+// results in job.
+type runFunc func(ctx context.Context, job *Job) (string, error)
+
+type queuedJob struct {
+	job *Job
+	run runFunc
+}
+
+// jobQueue runs jobs with a bounded pool of workers.
+type jobQueue struct {
+	store JobStore
+	jobs  chan queuedJob
+
+	// mu serializes submissions with draining the queue on shutdown, so
+	// that no job is left queued once the workers have stopped.
+	mu      sync.Mutex
+	stopped bool
+}
+
+func newJobQueue(store JobStore, size int) *jobQueue {
+	return &jobQueue{
+		store: store,
+		jobs:  make(chan queuedJob, size),
+	}
+}
+
+// start starts the workers, which stop when ctx is canceled. Jobs that are
+// still queued then fail with ErrShuttingDown.
+func (q *jobQueue) start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go q.work(ctx)
+	}
+}
+
+func (q *jobQueue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			q.drain()
+			return
+		case qj := <-q.jobs:
+			metricJobsQueued.Dec()
+			// A job received as the workers stop isn't started either.
+			if ctx.Err() != nil {
+				q.fail(qj.job, ErrShuttingDown)
+				q.drain()
+				return
+			}
+			q.runJob(ctx, qj)
+		}
+	}
+}
+
+// drain stops accepting jobs and fails the ones still queued, since no
+// worker is going to run them.
+func (q *jobQueue) drain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopped = true
+	for {
+		select {
+		case qj := <-q.jobs:
+			metricJobsQueued.Dec()
+			q.fail(qj.job, ErrShuttingDown)
+		default:
+			return
+		}
+	}
+}
+
+func (q *jobQueue) runJob(ctx context.Context, qj queuedJob) {
+	job := qj.job
+
+	started := time.Now()
+	job.Started = &started
+	job.Status = JobRunning
+	q.put(job)
+
+	output, err := qj.run(ctx, job)
+
+	finished := time.Now()
+	job.Finished = &finished
+	job.Output = output
+	job.Status = JobSucceeded
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	}
+	q.put(job)
+
+	status := string(job.Status)
+	if job.DryRun {
+		status = "dryrun-" + status
+	}
+	metricJobs.WithLabelValues(job.Method, status).Inc()
+}
+
+// fail records that job failed without running.
+func (q *jobQueue) fail(job *Job, err error) {
+	finished := time.Now()
+	job.Finished = &finished
+	job.Status = JobFailed
+	job.Error = err.Error()
+	q.put(job)
+}
+
+func (q *jobQueue) put(job *Job) {
+	err := q.store.Put(job)
+	if err != nil {
+		log.WithError(err).Errorf("Cannot store job %s", job.ID)
+	}
+}
+
+// submit stores a new queued job and schedules run to be executed by a
+// worker. The worker updates its own copy of job, so the caller can keep
+// using it.
+func (q *jobQueue) submit(job *Job, run runFunc) error {
+	job.Status = JobQueued
+	job.Created = time.Now()
+	err := q.store.Put(job)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		q.fail(job, ErrShuttingDown)
+		return ErrShuttingDown
+	}
+
+	queued := *job
+	metricJobsQueued.Inc()
+	select {
+	case q.jobs <- queuedJob{job: &queued, run: run}:
+		return nil
+	default:
+		metricJobsQueued.Dec()
+		q.fail(job, ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// JobsHandler is the HTTP handler for /v1/jobs/{id}.
+type JobsHandler struct {
+	store JobStore
+}
+
+// NewJobsHandler returns a JobsHandler reading jobs from the provided store.
+func NewJobsHandler(store JobStore) *JobsHandler {
+	return &JobsHandler{
+		store: store,
+	}
+}
+
+// ServeHTTP handles GET requests for a job, writing it as JSON.
+func (h *JobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := path.Base(r.URL.Path)
+	if id == "jobs" || id == "/" || id == "." {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Job ID is missing"))
+		return
+	}
+
+	job, err := h.store.Get(id)
+	if err == ErrJobNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Job not found"))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Cannot retrieve job %s", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// writeJSON writes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.WithError(err).Error("Cannot write JSON response")
+	}
+}
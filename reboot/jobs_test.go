@@ -0,0 +1,283 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+func Test_memoryJobStore(t *testing.T) {
+	store := NewMemoryJobStore(time.Hour)
+
+	if _, err := store.Get("missing"); err != ErrJobNotFound {
+		t.Errorf("Get() expected ErrJobNotFound, got %v", err)
+	}
+
+	job := &Job{ID: "test", Status: JobQueued}
+	if err := store.Put(job); err != nil {
+		t.Fatalf("Put() returned err: %v", err)
+	}
+
+	// The store must keep its own copy of the job.
+	job.Status = JobRunning
+	got, err := store.Get("test")
+	if err != nil {
+		t.Fatalf("Get() returned err: %v", err)
+	}
+	if got.Status != JobQueued {
+		t.Errorf("Get() returned status %s, want %s", got.Status, JobQueued)
+	}
+
+	// Finished jobs are removed once the retention period is over.
+	finished := time.Now().Add(-2 * time.Hour)
+	store.Put(&Job{ID: "old", Status: JobSucceeded, Finished: &finished})
+	store.Put(&Job{ID: "new"})
+	if _, err := store.Get("old"); err != ErrJobNotFound {
+		t.Errorf("Get() expected ErrJobNotFound, got %v", err)
+	}
+	if _, err := store.Get("test"); err != nil {
+		t.Errorf("Get() returned err: %v", err)
+	}
+}
+
+// waitForJob polls store until the job is finished.
+func waitForJob(t *testing.T, store JobStore, id string) *Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := store.Get(id)
+		if err != nil {
+			t.Fatalf("Get() returned err: %v", err)
+		}
+		if job.done() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish in time", id)
+	return nil
+}
+
+func Test_jobQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryJobStore(time.Hour)
+	q := newJobQueue(store, 1)
+
+	// Without workers, only one job fits in the queue.
+	release := make(chan struct{})
+	err := q.submit(&Job{ID: "ok"}, func(context.Context, *Job) (string, error) {
+		<-release
+		return "done", nil
+	})
+	if err != nil {
+		t.Fatalf("submit() returned err: %v", err)
+	}
+	err = q.submit(&Job{ID: "full"}, func(context.Context, *Job) (string, error) {
+		return "", nil
+	})
+	if err != ErrQueueFull {
+		t.Errorf("submit() expected ErrQueueFull, got %v", err)
+	}
+	if job, _ := store.Get("full"); job.Status != JobFailed {
+		t.Errorf("rejected job has status %s, want %s", job.Status, JobFailed)
+	}
+
+	q.start(ctx, 1)
+	close(release)
+	job := waitForJob(t, store, "ok")
+	if job.Status != JobSucceeded || job.Output != "done" || job.Started == nil {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	err = q.submit(&Job{ID: "fail"}, func(context.Context, *Job) (string, error) {
+		return "", errors.New("reboot failed")
+	})
+	if err != nil {
+		t.Fatalf("submit() returned err: %v", err)
+	}
+	job = waitForJob(t, store, "fail")
+	if job.Status != JobFailed || job.Error != "reboot failed" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
+
+func Test_jobQueue_shutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryJobStore(time.Hour)
+	q := newJobQueue(store, 2)
+	q.start(ctx, 1)
+
+	release := make(chan struct{})
+	err := q.submit(&Job{ID: "running"}, func(context.Context, *Job) (string, error) {
+		<-release
+		return "done", nil
+	})
+	if err != nil {
+		t.Fatalf("submit() returned err: %v", err)
+	}
+	for job, _ := store.Get("running"); job.Status != JobRunning; job, _ = store.Get("running") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = q.submit(&Job{ID: "queued"}, func(context.Context, *Job) (string, error) {
+		t.Errorf("queued job run after shutdown")
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("submit() returned err: %v", err)
+	}
+
+	// The running job completes, but the queued one never starts.
+	cancel()
+	close(release)
+	if job := waitForJob(t, store, "running"); job.Status != JobSucceeded {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	job := waitForJob(t, store, "queued")
+	if job.Status != JobFailed || job.Error != ErrShuttingDown.Error() || job.Started != nil {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	err = q.submit(&Job{ID: "late"}, func(context.Context, *Job) (string, error) {
+		t.Errorf("job submitted after shutdown run")
+		return "", nil
+	})
+	if err != ErrShuttingDown {
+		t.Errorf("submit() expected ErrShuttingDown, got %v", err)
+	}
+	if job, _ := store.Get("late"); job.Status != JobFailed {
+		t.Errorf("rejected job has status %s, want %s", job.Status, JobFailed)
+	}
+}
+
+func TestHandler_async(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t",
+			Username: "testuser",
+			Password: "testpass",
+			Address:  "testaddr",
+		})
+	h := NewHandler(&Config{}, provider, &mockConnector{})
+
+	// Asynchronous reboots must be enabled explicitly.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	store := NewMemoryJobStore(time.Hour)
+	h.StartJobs(ctx, store, 2, 10)
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=notabool", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() - expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	job := &Job{}
+	if err := json.Unmarshal(rr.Body.Bytes(), job); err != nil {
+		t.Fatalf("cannot unmarshal response: %v", err)
+	}
+	if job.ID == "" || job.Method != "bmc" || job.Status != JobQueued {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if rr.Header().Get("Location") != "/v1/jobs/"+job.ID {
+		t.Errorf("unexpected Location: %s", rr.Header().Get("Location"))
+	}
+
+	job = waitForJob(t, store, job.ID)
+	if job.Status != JobSucceeded || job.Output != "Server power operation successful" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	// Failures are reported in the job rather than in the response. Once
+	// jobs are enabled, reboots are asynchronous by default.
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc1t.mlab-sandbox.measurement-lab.org", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() - expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	json.Unmarshal(rr.Body.Bytes(), job)
+	job = waitForJob(t, store, job.ID)
+	if job.Status != JobFailed || job.Error == "" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	// Synchronous reboots can still be requested.
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=false", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "Server power operation successful" {
+		t.Errorf("ServeHTTP() = %d, %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestJobsHandler(t *testing.T) {
+	store := NewMemoryJobStore(time.Hour)
+	store.Put(&Job{ID: "abc", Node: "mlab1d.abc0t", Status: JobRunning})
+	h := NewJobsHandler(store)
+
+	tests := []struct {
+		req    *http.Request
+		status int
+	}{
+		{
+			req:    httptest.NewRequest("GET", "/v1/jobs/abc", nil),
+			status: http.StatusOK,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/jobs/def", nil),
+			status: http.StatusNotFound,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/jobs/", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			req:    httptest.NewRequest("POST", "/v1/jobs/abc", nil),
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, tt.req)
+		if rr.Code != tt.status {
+			t.Errorf("ServeHTTP(%s) - expected %d, got %d", tt.req.URL,
+				tt.status, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/jobs/abc", nil))
+	job := &Job{}
+	if err := json.Unmarshal(rr.Body.Bytes(), job); err != nil {
+		t.Fatalf("cannot unmarshal response: %v", err)
+	}
+	if job.ID != "abc" || job.Status != JobRunning {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
@@ -0,0 +1,211 @@
+package reboot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons for refusing a reboot.
+const (
+	reasonCooldown   = "cooldown"
+	reasonNodeBudget = "node_budget"
+	reasonSiteBudget = "site_budget"
+)
+
+var (
+	metricRefused = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reboot_refused_total",
+			Help: "Total number of reboots refused because of rate limits",
+		},
+		[]string{
+			"site",
+			"reason",
+		},
+	)
+	metricForced = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reboot_forced_total",
+			Help: "Total number of reboots requested with force=true, by the limit they bypassed",
+		},
+		[]string{
+			"site",
+			"machine",
+			"reason",
+		},
+	)
+)
+
+// RateLimitError is returned when a reboot is refused because the node or
+// its site has been rebooted too recently or too often.
+type RateLimitError struct {
+	// Reason is the limit that has been hit.
+	Reason string
+	// RetryAfter is how long until the reboot would be allowed.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	var what string
+	switch e.Reason {
+	case reasonCooldown:
+		what = "node rebooted too recently"
+	case reasonNodeBudget:
+		what = "too many reboots for this node"
+	case reasonSiteBudget:
+		what = "too many reboots for this site"
+	}
+	return fmt.Sprintf("%s, retry in %v", what, e.RetryAfter.Round(time.Second))
+}
+
+// retryAfterHeader returns the value of the Retry-After header, in whole
+// seconds rounded up.
+func (e *RateLimitError) retryAfterHeader() string {
+	return strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// rebootLimiter keeps track of recent reboots per node and per site. The
+// zero value is ready to use.
+type rebootLimiter struct {
+	mu    sync.Mutex
+	nodes map[string][]time.Time
+	sites map[string][]time.Time
+}
+
+// nodeKey identifies a node regardless of whether its host or BMC name, and
+// which naming scheme, has been used.
+func nodeKey(node host.Name) string {
+	return hostNode(node).Machine + "." + node.Site
+}
+
+// prune removes the timestamps before cutoff from history.
+func prune(history []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(history) && history[i].Before(cutoff) {
+		i++
+	}
+	return history[i:]
+}
+
+// checkLimits returns a RateLimitError if rebooting a node with the given
+// histories at now would exceed the limits in config, or nil.
+func checkLimits(config *Config, nodeHistory, siteHistory []time.Time,
+	now time.Time) *RateLimitError {
+
+	if config.Cooldown > 0 && len(nodeHistory) > 0 {
+		last := nodeHistory[len(nodeHistory)-1]
+		if wait := last.Add(config.Cooldown).Sub(now); wait > 0 {
+			return &RateLimitError{Reason: reasonCooldown, RetryAfter: wait}
+		}
+	}
+
+	window := config.BudgetWindow
+	if config.MaxNodeReboots > 0 && window > 0 {
+		recent := prune(nodeHistory, now.Add(-window))
+		if len(recent) >= config.MaxNodeReboots {
+			wait := recent[len(recent)-config.MaxNodeReboots].Add(window).Sub(now)
+			return &RateLimitError{Reason: reasonNodeBudget, RetryAfter: wait}
+		}
+	}
+	if config.MaxSiteReboots > 0 && window > 0 {
+		recent := prune(siteHistory, now.Add(-window))
+		if len(recent) >= config.MaxSiteReboots {
+			wait := recent[len(recent)-config.MaxSiteReboots].Add(window).Sub(now)
+			return &RateLimitError{Reason: reasonSiteBudget, RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// reserve records a reboot of node, unless it would exceed the limits in
+// config. If force is true, the reboot is recorded regardless and the
+// limit that would have been hit, if any, is returned as the reason.
+//
+// The returned function removes the reservation, and should be called if
+// the node has not actually been rebooted.
+func (l *rebootLimiter) reserve(config *Config, node host.Name, force bool) (func(), string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.nodes == nil {
+		l.nodes = make(map[string][]time.Time)
+		l.sites = make(map[string][]time.Time)
+	}
+
+	now := time.Now()
+	key := nodeKey(node)
+
+	// History older than both the cooldown and the budget window is no
+	// longer needed.
+	keep := config.Cooldown
+	if config.BudgetWindow > keep {
+		keep = config.BudgetWindow
+	}
+	nodeHistory := prune(l.nodes[key], now.Add(-keep))
+	siteHistory := prune(l.sites[node.Site], now.Add(-keep))
+
+	reason := ""
+	if err := checkLimits(config, nodeHistory, siteHistory, now); err != nil {
+		if !force {
+			metricRefused.WithLabelValues(node.Site, err.Reason).Inc()
+			l.store(key, node.Site, nodeHistory, siteHistory)
+			return nil, "", err
+		}
+		reason = err.Reason
+	}
+
+	l.store(key, node.Site, append(nodeHistory, now), append(siteHistory, now))
+	return func() { l.release(key, node.Site, now) }, reason, nil
+}
+
+// check returns the error reserve would return for node, without recording
+// a reboot or counting a refusal.
+func (l *rebootLimiter) check(config *Config, node host.Name) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// checkLimits returns a *RateLimitError, which must not be returned as
+	// a non-nil error when it's nil.
+	err := checkLimits(config, l.nodes[nodeKey(node)], l.sites[node.Site], time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// store replaces the histories of a node and a site, removing empty ones.
+func (l *rebootLimiter) store(key, site string, nodeHistory, siteHistory []time.Time) {
+	if len(nodeHistory) == 0 {
+		delete(l.nodes, key)
+	} else {
+		l.nodes[key] = nodeHistory
+	}
+	if len(siteHistory) == 0 {
+		delete(l.sites, site)
+	} else {
+		l.sites[site] = siteHistory
+	}
+}
+
+// remove returns history without the first occurrence of t.
+func remove(history []time.Time, t time.Time) []time.Time {
+	for i := range history {
+		if history[i].Equal(t) {
+			return append(history[:i:i], history[i+1:]...)
+		}
+	}
+	return history
+}
+
+func (l *rebootLimiter) release(key, site string, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.store(key, site, remove(l.nodes[key], t), remove(l.sites[site], t))
+}
@@ -0,0 +1,245 @@
+package reboot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+func Test_checkLimits(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) time.Time {
+		return now.Add(-d)
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		node   []time.Time
+		site   []time.Time
+		reason string
+		retry  time.Duration
+	}{
+		{
+			name:   "no-limits",
+			config: &Config{},
+			node:   []time.Time{ago(time.Second)},
+		},
+		{
+			name:   "cooldown",
+			config: &Config{Cooldown: time.Hour},
+			node:   []time.Time{ago(2 * time.Hour), ago(10 * time.Minute)},
+			reason: reasonCooldown,
+			retry:  50 * time.Minute,
+		},
+		{
+			name:   "cooldown-expired",
+			config: &Config{Cooldown: time.Hour},
+			node:   []time.Time{ago(2 * time.Hour)},
+		},
+		{
+			name:   "node-budget",
+			config: &Config{MaxNodeReboots: 2, BudgetWindow: 24 * time.Hour},
+			node:   []time.Time{ago(30 * time.Hour), ago(20 * time.Hour), ago(time.Hour)},
+			reason: reasonNodeBudget,
+			retry:  4 * time.Hour,
+		},
+		{
+			name:   "node-budget-available",
+			config: &Config{MaxNodeReboots: 2, BudgetWindow: 24 * time.Hour},
+			node:   []time.Time{ago(30 * time.Hour), ago(time.Hour)},
+		},
+		{
+			name:   "site-budget",
+			config: &Config{MaxSiteReboots: 3, BudgetWindow: time.Hour},
+			site:   []time.Time{ago(40 * time.Minute), ago(30 * time.Minute), ago(time.Minute)},
+			reason: reasonSiteBudget,
+			retry:  20 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		err := checkLimits(tt.config, tt.node, tt.site, now)
+		if tt.reason == "" {
+			if err != nil {
+				t.Errorf("checkLimits() %s returned err: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("checkLimits() %s expected err, got nil.", tt.name)
+			continue
+		}
+		if err.Reason != tt.reason || err.RetryAfter != tt.retry {
+			t.Errorf("checkLimits() %s = %s/%v, want %s/%v", tt.name,
+				err.Reason, err.RetryAfter, tt.reason, tt.retry)
+		}
+	}
+}
+
+func Test_rebootLimiter_reserve(t *testing.T) {
+	config := &Config{
+		Cooldown:       time.Hour,
+		MaxSiteReboots: 2,
+		BudgetWindow:   time.Hour,
+	}
+	l := &rebootLimiter{}
+	mlab1, _ := host.Parse("mlab1-abc0t.mlab-sandbox.measurement-lab.org")
+	mlab1d, _ := host.Parse("mlab1d.abc0t.measurement-lab.org")
+	mlab2, _ := host.Parse("mlab2-abc0t.mlab-sandbox.measurement-lab.org")
+	mlab3, _ := host.Parse("mlab3-abc0t.mlab-sandbox.measurement-lab.org")
+
+	if _, _, err := l.reserve(config, mlab1, false); err != nil {
+		t.Fatalf("reserve() returned err: %v", err)
+	}
+
+	// The BMC and the host are the same node, regardless of the naming
+	// scheme used.
+	_, _, err := l.reserve(config, mlab1d, false)
+	if rle, ok := err.(*RateLimitError); !ok || rle.Reason != reasonCooldown {
+		t.Errorf("reserve() expected cooldown error, got %v", err)
+	}
+
+	// A reservation that is released doesn't count.
+	release, _, err := l.reserve(config, mlab2, false)
+	if err != nil {
+		t.Fatalf("reserve() returned err: %v", err)
+	}
+	release()
+	release, _, err = l.reserve(config, mlab2, false)
+	if err != nil {
+		t.Fatalf("reserve() returned err: %v", err)
+	}
+
+	// The site budget is now exhausted, unless forced.
+	_, _, err = l.reserve(config, mlab3, false)
+	if rle, ok := err.(*RateLimitError); !ok || rle.Reason != reasonSiteBudget {
+		t.Errorf("reserve() expected site budget error, got %v", err)
+	}
+	_, reason, err := l.reserve(config, mlab3, true)
+	if err != nil || reason != reasonSiteBudget {
+		t.Errorf("reserve() with force = %q, %v", reason, err)
+	}
+
+	// Without limits, history is not kept.
+	l = &rebootLimiter{}
+	l.reserve(&Config{}, mlab1, false)
+	l.reserve(&Config{}, mlab1, false)
+	if len(l.nodes) != 1 || len(l.nodes[nodeKey(mlab1)]) != 1 {
+		t.Errorf("unexpected history: %v", l.nodes)
+	}
+}
+
+func TestHandler_limits(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	mockConnector := &mockConnector{}
+	h := NewHandler(&Config{Cooldown: time.Hour}, provider, mockConnector)
+
+	post := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST",
+			"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org"+query, nil))
+		return rr
+	}
+
+	// Failed reboots don't count towards the limits.
+	mockConnector.connMustFail = true
+	if rr := post(""); rr.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+	mockConnector.connMustFail = false
+
+	if rr := post(""); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr := post("")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 0 || retryAfter > 3600 {
+		t.Errorf("unexpected Retry-After: %q", rr.Header().Get("Retry-After"))
+	}
+
+	if rr := post("&force=notabool"); rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := post("&force=true"); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// Asynchronous reboots are limited as well.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.StartJobs(ctx, NewMemoryJobStore(time.Hour), 1, 1)
+	if rr := post("&async=true"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+}
+
+func TestPowerHandler_limits(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	mockConnector := &mockConnector{}
+	h := NewHandler(&Config{Cooldown: time.Hour}, provider, mockConnector)
+	p := NewPowerHandler(h)
+
+	power := func(method, query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, httptest.NewRequest(method,
+			"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org"+query, nil))
+		return rr
+	}
+
+	// Failed power actions don't count towards the limits.
+	mockConnector.connMustFail = true
+	if rr := power("POST", "&action=hardreset"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+	mockConnector.connMustFail = false
+
+	if rr := power("POST", "&action=hardreset"); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// Power actions and reboots share the same limits.
+	rr := power("POST", "&action=powerdown")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Errorf("missing Retry-After header")
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	// Reading the power status is never limited.
+	if rr := power("GET", ""); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	if rr := power("POST", "&action=powerdown&force=notabool"); rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := power("POST", "&action=powerdown&force=true"); rr.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusOK, rr.Code)
+	}
+}
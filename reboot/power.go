@@ -0,0 +1,238 @@
+package reboot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Power actions supported by the /v1/power endpoint.
+const (
+	ActionPowerUp       = "powerup"
+	ActionPowerDown     = "powerdown"
+	ActionHardReset     = "hardreset"
+	ActionGraceShutdown = "graceshutdown"
+
+	// actionPowerStatus is used for GET requests.
+	actionPowerStatus = "powerstatus"
+)
+
+var metricPowerActions = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_power_total",
+		Help: "Total number of BMC power actions and power status reads",
+	},
+	[]string{
+		"site",
+		"machine",
+		"action",
+		"status",
+	},
+)
+
+// powerAction returns the function running action via conn, giving up when
+// ctx is done, or nil if there is no such action.
+func powerAction(ctx context.Context, conn connector.Connection,
+	action string) func() (string, error) {
+
+	var f func(context.Context, connector.Connection) (string, error)
+	switch action {
+	case ActionPowerUp:
+		f = connector.PowerOnContext
+	case ActionPowerDown:
+		f = connector.PowerOffContext
+	case ActionHardReset:
+		f = connector.HardResetContext
+	case ActionGraceShutdown:
+		f = connector.GracefulShutdownContext
+	case actionPowerStatus:
+		f = connector.PowerStatusContext
+	default:
+		return nil
+	}
+	return func() (string, error) {
+		return f(ctx, conn)
+	}
+}
+
+// powerMethods maps power actions to the corresponding Connection methods,
+// as described in dry runs.
+var powerMethods = map[string]string{
+	ActionPowerUp:       connector.MethodPowerOn,
+	ActionPowerDown:     connector.MethodPowerOff,
+	ActionHardReset:     connector.MethodHardReset,
+	ActionGraceShutdown: connector.MethodGracefulShutdown,
+}
+
+// validPowerAction returns whether action can be requested via POST.
+func validPowerAction(action string) bool {
+	switch action {
+	case ActionPowerUp, ActionPowerDown, ActionHardReset, ActionGraceShutdown:
+		return true
+	}
+	return false
+}
+
+// power runs a power action, or reads the power status, via node's BMC. In
+// a dry run, power actions are described instead.
+func (h *Handler) power(ctx context.Context, node host.Name, action string) (string, error) {
+	node = bmcNode(node)
+	connectionConfig, err := h.bmcConnectionConfig(ctx, node)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := h.connect(ctx, connectionConfig)
+	if err != nil {
+		log.WithError(err).
+			Errorf("Cannot connect to BMC: %s:%d with username %s",
+				connectionConfig.Hostname, connectionConfig.Port, connectionConfig.Username)
+		metricPowerActions.WithLabelValues(node.Site, node.Machine, action,
+			metricStatus(ctx, connectErrorStatus(err))).Inc()
+		return "", wrapConnectError(err)
+	}
+	defer conn.Close()
+
+	if isDryRun(ctx) && action != actionPowerStatus {
+		metricPowerActions.WithLabelValues(node.Site, node.Machine, action,
+			metricStatus(ctx, "ok")).Inc()
+		return describe(conn, powerMethods[action]), nil
+	}
+
+	output, err := h.run(ctx, action+" on "+node.String(),
+		powerAction(ctx, conn, action))
+	if err != nil {
+		log.WithError(err).Errorf("Cannot run power action %s", action)
+		metricPowerActions.WithLabelValues(node.Site, node.Machine, action,
+			commandErrorStatus(err, "error-"+action)).Inc()
+		return "", withCode(CodeCommandFailed, err)
+	}
+
+	metricPowerActions.WithLabelValues(node.Site, node.Machine, action, "ok").Inc()
+	return output, nil
+}
+
+// powerErrorStatus returns the HTTP status code for a failed power action.
+func powerErrorStatus(err error) int {
+	if errors.Is(err, connector.ErrNotSupported) {
+		return http.StatusNotImplemented
+	}
+	return rebootErrorStatus(err)
+}
+
+// PowerHandler is the HTTP handler for /v1/power. It shares configuration,
+// credentials and connector with the reboot Handler it's created from.
+type PowerHandler struct {
+	h *Handler
+}
+
+// NewPowerHandler returns a PowerHandler using the same configuration,
+// credential provider and connector as h.
+func NewPowerHandler(h *Handler) *PowerHandler {
+	return &PowerHandler{
+		h: h,
+	}
+}
+
+// ServeHTTP returns the power status of a node on GET, and runs the
+// requested power action on POST.
+func (p *PowerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var action string
+	switch r.Method {
+	case http.MethodGet:
+		action = actionPowerStatus
+	case http.MethodPost:
+		action = r.URL.Query().Get("action")
+		if !validPowerAction(action) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf(
+				"URL parameter 'action' must be one of %s, %s, %s or %s",
+				ActionPowerUp, ActionPowerDown, ActionHardReset,
+				ActionGraceShutdown)))
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("host")
+	if len(target) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("URL parameter 'host' is missing"))
+		return
+	}
+
+	node, err := host.Parse(target)
+	if err != nil {
+		errStr := fmt.Sprintf(
+			"The specified hostname is not a valid M-Lab node: %s", target)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errStr))
+		log.Errorf(errStr)
+		return
+	}
+
+	force, err := boolParam(r, "force")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	dryRun, err := p.h.dryRunParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	attempts, err := attemptsParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ctx := r.Context()
+	if dryRun {
+		ctx = withDryRun(ctx)
+	}
+	if attempts > 0 {
+		ctx = withRetryAttempts(ctx, attempts)
+	}
+
+	// Reading the power status is harmless, but power actions can't run
+	// concurrently with other operations on the same node, and count
+	// towards the node's reboot limits.
+	finish := func(bool) {}
+	if action != actionPowerStatus {
+		finish, err = p.h.lockNode(ctx, node, Operation{
+			Operation: action,
+			Method:    "bmc",
+		}, force)
+		if err != nil {
+			log.WithError(err).Warnf("Refusing power action %s on %s", action,
+				node.String())
+			writeRefused(w, err)
+			return
+		}
+	}
+
+	output, err := p.h.power(ctx, node, action)
+	finish(err == nil)
+	if err != nil {
+		w.WriteHeader(powerErrorStatus(err))
+		w.Write([]byte(fmt.Sprintf("Power action %s failed: %v", action, err)))
+		log.WithError(err).Errorf("Power action %s failed", action)
+		return
+	}
+
+	log.WithField("output", output).Infof("Power action %s on %v successful",
+		action, node.String())
+	w.Write([]byte(output))
+}
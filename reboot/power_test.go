@@ -0,0 +1,140 @@
+package reboot
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+func TestPowerHandler_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		req       *http.Request
+		connector connector.Connector
+		status    int
+		body      string
+	}{
+		{
+			req: httptest.NewRequest("GET",
+				"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org", nil),
+			status: http.StatusOK,
+			body:   "on",
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&action=powerup", nil),
+			status: http.StatusOK,
+			body:   "Server power operation successful",
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&action=powerdown", nil),
+			status: http.StatusOK,
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&action=hardreset", nil),
+			status: http.StatusOK,
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&action=graceshutdown", nil),
+			status: http.StatusOK,
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&action=explode", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			req:    httptest.NewRequest("PUT", "/v1/power?host=mlab1.lga0t", nil),
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/power", nil),
+			status: http.StatusBadRequest,
+		},
+		{
+			req:    httptest.NewRequest("GET", "/v1/power?host=thisshouldfail", nil),
+			status: http.StatusBadRequest,
+			body:   "The specified hostname is not a valid M-Lab node: thisshouldfail",
+		},
+		{
+			// No credentials for this node.
+			req: httptest.NewRequest("GET",
+				"/v1/power?host=mlab1-abc1t.mlab-sandbox.measurement-lab.org", nil),
+			status: http.StatusInternalServerError,
+		},
+		{
+			req: httptest.NewRequest("GET",
+				"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org", nil),
+			connector: &mockConnector{connMustFail: true},
+			status:    http.StatusInternalServerError,
+			body:      "Power action powerstatus failed: method PowerStatus() failed",
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&action=powerup", nil),
+			connector: &mockConnector{mustFail: true},
+			status:    http.StatusInternalServerError,
+		},
+		{
+			req: httptest.NewRequest("POST",
+				"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&action=powerup", nil),
+			connector: &mockConnector{err: fmt.Errorf("%w: \"hp\"", connector.ErrUnknownModel)},
+			status:    http.StatusBadRequest,
+		},
+		{
+			req: httptest.NewRequest("GET",
+				"/v1/power?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org", nil),
+			connector: &powerConnector{state: "unsupported"},
+			status:    http.StatusNotImplemented,
+		},
+	}
+
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Hostname: "mlab1d.abc0t",
+			Username: "testuser",
+			Password: "testpass",
+			Model:    "drac",
+			Address:  "testaddr",
+		})
+
+	for _, test := range tests {
+		conn := test.connector
+		if conn == nil {
+			conn = &mockConnector{}
+		}
+		p := NewPowerHandler(NewHandler(&Config{}, provider, conn))
+
+		rr := httptest.NewRecorder()
+		p.ServeHTTP(rr, test.req)
+
+		if rr.Code != test.status {
+			t.Errorf("ServeHTTP(%s %s) - expected %d, got %d", test.req.Method,
+				test.req.URL, test.status, rr.Code)
+		}
+		if test.body != "" {
+			body, err := ioutil.ReadAll(rr.Result().Body)
+			if err != nil {
+				t.Errorf("ServeHTTP() - cannot read response: %v", err)
+			}
+			if string(body) != test.body {
+				t.Errorf("ServeHTTP() - unexpected response: %s", string(body))
+			}
+		}
+	}
+}
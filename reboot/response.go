@@ -0,0 +1,233 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+)
+
+// Error codes in JSON responses. They are meant to be matched by clients, so
+// they must not change.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeInvalidHost         = "invalid_host"
+	CodeCredentialsNotFound = "credentials_not_found"
+	CodeCredentialsError    = "credentials_error"
+	CodeUnknownModel        = "unknown_model"
+	CodeHostKeyMismatch     = "host_key_mismatch"
+	CodeDNSFailed           = "dns_failed"
+	CodeConnectionRefused   = "connection_refused"
+	CodeTimeout             = "timeout"
+	CodeAuthRejected        = "auth_rejected"
+	CodeCircuitOpen         = "circuit_open"
+	CodeConnectFailed       = "connect_failed"
+	CodeSessionFailed       = "session_failed"
+	CodeNonZeroExit         = "nonzero_exit"
+	CodeRACADMError         = "racadm_error"
+	CodeCommandFailed       = "command_failed"
+	CodeCanceled            = "canceled"
+	CodeNotSupported        = "not_supported"
+	CodeConflict            = "conflict"
+	CodeRateLimited         = "rate_limited"
+	CodeQueueFull           = "queue_full"
+	CodeShuttingDown        = "shutting_down"
+	CodeInternal            = "internal_error"
+)
+
+// Statuses in JSON responses.
+const (
+	ResponseOK      = "ok"
+	ResponseRefused = "refused"
+	ResponseFailed  = "failed"
+)
+
+// codedError attaches an error code to an error, without changing its
+// message. It records at which step of an operation the error happened.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode returns err with the given error code, unless err is nil.
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// errorCode returns the error code for err. Well-known errors take
+// precedence over the step at which they happened.
+func errorCode(err error) string {
+	var (
+		conflict *ConflictError
+		rle      *RateLimitError
+		coded    *codedError
+	)
+	switch {
+	case errors.As(err, &conflict):
+		return CodeConflict
+	case errors.As(err, &rle):
+		return CodeRateLimited
+	case errors.Is(err, ErrQueueFull):
+		return CodeQueueFull
+	case errors.Is(err, ErrShuttingDown):
+		return CodeShuttingDown
+	case errors.Is(err, creds.ErrNotFound):
+		return CodeCredentialsNotFound
+	case errors.Is(err, connector.ErrUnknownModel):
+		return CodeUnknownModel
+	case errors.Is(err, connector.ErrHostKeyMismatch):
+		return CodeHostKeyMismatch
+	case errors.Is(err, connector.ErrNotSupported):
+		return CodeNotSupported
+	case errors.Is(err, connector.ErrCircuitOpen):
+		return CodeCircuitOpen
+	case errors.Is(err, connector.ErrDNS):
+		return CodeDNSFailed
+	case errors.Is(err, connector.ErrConnectionRefused):
+		return CodeConnectionRefused
+	case errors.Is(err, connector.ErrTimeout):
+		return CodeTimeout
+	case errors.Is(err, connector.ErrAuthRejected):
+		return CodeAuthRejected
+	case errors.Is(err, connector.ErrSession):
+		return CodeSessionFailed
+	case errors.Is(err, connector.ErrNonZeroExit):
+		return CodeNonZeroExit
+	case errors.Is(err, connector.ErrRACADM):
+		return CodeRACADMError
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.As(err, &coded):
+		return coded.code
+	}
+	return CodeInternal
+}
+
+// RebootResponse is the response to a /v1/reboot request, for clients
+// accepting application/json.
+type RebootResponse struct {
+	Node   string `json:"node,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Status is ResponseOK, ResponseRefused or ResponseFailed.
+	Status string `json:"status"`
+	DryRun bool   `json:"dry_run,omitempty"`
+
+	// Output is the output of the reboot command, including when it failed.
+	Output string `json:"output,omitempty"`
+
+	// Code is a stable identifier for the error, and Error describes it.
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// acceptsJSON returns whether the Accept header of r lists
+// application/json. Other clients get plain text responses.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType := strings.TrimSpace(strings.Split(mediaRange, ";")[0])
+			if strings.EqualFold(mediaType, "application/json") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rebootReply writes the response to a /v1/reboot request, either as a
+// RebootResponse or as plain text.
+type rebootReply struct {
+	w     http.ResponseWriter
+	json  bool
+	start time.Time
+	resp  RebootResponse
+}
+
+func newRebootReply(w http.ResponseWriter, r *http.Request) *rebootReply {
+	return &rebootReply{
+		w:     w,
+		json:  acceptsJSON(r),
+		start: time.Now(),
+	}
+}
+
+func (rep *rebootReply) write(status int, text string) {
+	if !rep.json {
+		rep.w.WriteHeader(status)
+		rep.w.Write([]byte(text))
+		return
+	}
+
+	rep.resp.DurationSeconds = time.Since(rep.start).Seconds()
+	rep.w.Header().Set("Content-Type", "application/json")
+	rep.w.WriteHeader(status)
+	json.NewEncoder(rep.w).Encode(rep.resp)
+}
+
+// ok replies with the output of a successful reboot.
+func (rep *rebootReply) ok(output string) {
+	rep.resp.Status = ResponseOK
+	rep.resp.Output = output
+	rep.write(http.StatusOK, output)
+}
+
+// fail replies with an error that happened before trying to reboot. text is
+// the plain text response.
+func (rep *rebootReply) fail(status int, code, text string) {
+	rep.resp.Status = ResponseFailed
+	rep.resp.Code = code
+	rep.resp.Error = text
+	rep.write(status, text)
+}
+
+// failErr replies with the appropriate status code and error code for a
+// refused or failed reboot.
+func (rep *rebootReply) failErr(err error) {
+	code := errorCode(err)
+	rep.resp.Code = code
+	rep.resp.Error = err.Error()
+
+	var cmdErr *connector.CommandError
+	if errors.As(err, &cmdErr) {
+		rep.resp.Output = cmdErr.Output
+	}
+
+	var rle *RateLimitError
+	switch {
+	case code == CodeConflict:
+		rep.resp.Status = ResponseRefused
+		rep.write(http.StatusConflict, fmt.Sprintf("Operation refused: %v", err))
+	case errors.As(err, &rle):
+		rep.resp.Status = ResponseRefused
+		rep.w.Header().Set("Retry-After", rle.retryAfterHeader())
+		rep.write(http.StatusTooManyRequests, fmt.Sprintf("Reboot refused: %v", err))
+	case code == CodeQueueFull, code == CodeShuttingDown:
+		rep.resp.Status = ResponseRefused
+		rep.write(http.StatusServiceUnavailable, fmt.Sprintf("Reboot failed: %v", err))
+	default:
+		log.WithError(err).WithField("code", code).Error("Reboot failed")
+		rep.resp.Status = ResponseFailed
+		rep.write(rebootErrorStatus(err), fmt.Sprintf("Reboot failed: %v", err))
+	}
+}
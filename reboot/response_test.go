@@ -0,0 +1,266 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// commandErrorConnector returns connections whose Reboot fails with a
+// CommandError.
+type commandErrorConnector struct{}
+
+type commandErrorConnection struct {
+	mockConnection
+}
+
+func (c *commandErrorConnector) NewConnection(*connector.ConnectionConfig) (connector.Connection, error) {
+	return &commandErrorConnection{}, nil
+}
+
+func (c *commandErrorConnection) Reboot() (string, error) {
+	return "", &connector.CommandError{
+		Command: "racadm serveraction powercycle",
+		Output:  "ERROR: Unable to perform the requested action.",
+		Err:     errors.New("Process exited with status 1"),
+	}
+}
+
+func Test_errorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: errors.New("unknown"), want: CodeInternal},
+		{err: &ConflictError{}, want: CodeConflict},
+		{err: &RateLimitError{Reason: reasonCooldown}, want: CodeRateLimited},
+		{err: ErrQueueFull, want: CodeQueueFull},
+		{err: ErrShuttingDown, want: CodeShuttingDown},
+		{
+			err:  withCode(CodeCredentialsError, fmt.Errorf("%w: x", creds.ErrNotFound)),
+			want: CodeCredentialsNotFound,
+		},
+		{err: withCode(CodeCredentialsError, errors.New("timeout")), want: CodeCredentialsError},
+		{
+			err:  wrapConnectError(fmt.Errorf("%w: x", connector.ErrHostKeyMismatch)),
+			want: CodeHostKeyMismatch,
+		},
+		{err: wrapConnectError(connector.ErrUnknownModel), want: CodeUnknownModel},
+		{err: wrapConnectError(errors.New("refused")), want: CodeConnectFailed},
+		{err: withCode(CodeCommandFailed, connector.ErrNotSupported), want: CodeNotSupported},
+		{err: withCode(CodeCommandFailed, errors.New("exit 1")), want: CodeCommandFailed},
+		{
+			err:  wrapConnectError(fmt.Errorf("%w: no such host", connector.ErrDNS)),
+			want: CodeDNSFailed,
+		},
+		{err: wrapConnectError(connector.ErrConnectionRefused), want: CodeConnectionRefused},
+		{err: wrapConnectError(connector.ErrTimeout), want: CodeTimeout},
+		{err: wrapConnectError(connector.ErrAuthRejected), want: CodeAuthRejected},
+		{err: withCode(CodeCommandFailed, connector.ErrSession), want: CodeSessionFailed},
+		{err: withCode(CodeCommandFailed, connector.ErrNonZeroExit), want: CodeNonZeroExit},
+		{err: wrapConnectError(context.Canceled), want: CodeCanceled},
+		{
+			err:  wrapConnectError(fmt.Errorf("%w: bmc", connector.ErrCircuitOpen)),
+			want: CodeCircuitOpen,
+		},
+		{
+			err: withCode(CodeCommandFailed, &connector.CommandError{
+				Command: "racadm serveraction powercycle",
+				Err:     connector.ErrRACADM,
+			}),
+			want: CodeRACADMError,
+		},
+	}
+	for _, tt := range tests {
+		if got := errorCode(tt.err); got != tt.want {
+			t.Errorf("errorCode(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+	if withCode(CodeInternal, nil) != nil {
+		t.Errorf("withCode() of a nil error is not nil")
+	}
+}
+
+func Test_acceptsJSON(t *testing.T) {
+	tests := map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"text/plain":                        false,
+		"application/json":                  true,
+		"text/html, Application/JSON;q=0.9": true,
+	}
+	for accept, want := range tests {
+		r := httptest.NewRequest("POST", "/v1/reboot", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if got := acceptsJSON(r); got != want {
+			t.Errorf("acceptsJSON(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestHandler_jsonResponses(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	provider.AddCredentials(context.Background(),
+		"mlab2d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+
+	tests := []struct {
+		name      string
+		method    string
+		query     string
+		connector connector.Connector
+		status    int
+		want      RebootResponse
+	}{
+		{
+			name:      "ok",
+			method:    "POST",
+			query:     "?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+			connector: &mockConnector{},
+			status:    http.StatusOK,
+			want: RebootResponse{
+				Node:   "mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+				Method: "bmc",
+				Status: ResponseOK,
+				Output: "Server power operation successful",
+			},
+		},
+		{
+			name:      "method-not-allowed",
+			method:    "GET",
+			connector: &mockConnector{},
+			status:    http.StatusMethodNotAllowed,
+			want: RebootResponse{
+				Status: ResponseFailed,
+				Code:   CodeMethodNotAllowed,
+			},
+		},
+		{
+			name:      "invalid-host",
+			method:    "POST",
+			query:     "?host=invalid",
+			connector: &mockConnector{},
+			status:    http.StatusBadRequest,
+			want: RebootResponse{
+				Status: ResponseFailed,
+				Code:   CodeInvalidHost,
+				Error:  "The specified hostname is not a valid M-Lab node: invalid",
+			},
+		},
+		{
+			name:      "credentials-not-found",
+			method:    "POST",
+			query:     "?host=mlab3d-abc0t.mlab-sandbox.measurement-lab.org",
+			connector: &mockConnector{},
+			status:    http.StatusInternalServerError,
+			want: RebootResponse{
+				Node:   "mlab3d-abc0t.mlab-sandbox.measurement-lab.org",
+				Method: "bmc",
+				Status: ResponseFailed,
+				Code:   CodeCredentialsNotFound,
+				Error:  "credentials not found: mlab3d-abc0t.mlab-sandbox.measurement-lab.org",
+			},
+		},
+		{
+			name:      "connect-failed",
+			method:    "POST",
+			query:     "?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+			connector: &mockConnector{mustFail: true},
+			status:    http.StatusInternalServerError,
+			want: RebootResponse{
+				Node:   "mlab1d-abc0t.mlab-sandbox.measurement-lab.org",
+				Method: "bmc",
+				Status: ResponseFailed,
+				Code:   CodeConnectFailed,
+				Error:  "method NewConnection() failed",
+			},
+		},
+		{
+			name:      "command-failed",
+			method:    "POST",
+			query:     "?host=mlab2d-abc0t.mlab-sandbox.measurement-lab.org",
+			connector: &commandErrorConnector{},
+			status:    http.StatusInternalServerError,
+			want: RebootResponse{
+				Node:   "mlab2d-abc0t.mlab-sandbox.measurement-lab.org",
+				Method: "bmc",
+				Status: ResponseFailed,
+				Output: "ERROR: Unable to perform the requested action.",
+				Code:   CodeCommandFailed,
+				Error:  "racadm serveraction powercycle: Process exited with status 1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		h := NewHandler(&Config{}, provider, tt.connector)
+		r := httptest.NewRequest(tt.method, "/v1/reboot"+tt.query, nil)
+		r.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+
+		if rr.Code != tt.status {
+			t.Errorf("ServeHTTP() %s - expected %d, got %d", tt.name, tt.status, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("ServeHTTP() %s - unexpected Content-Type: %s", tt.name, ct)
+		}
+		var got RebootResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("ServeHTTP() %s - cannot unmarshal response: %v", tt.name, err)
+		}
+		if got.DurationSeconds < 0 {
+			t.Errorf("ServeHTTP() %s - negative duration: %v", tt.name, got.DurationSeconds)
+		}
+		got.DurationSeconds = 0
+		if got != tt.want {
+			t.Errorf("ServeHTTP() %s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_jsonRefused(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	h := NewHandler(&Config{Cooldown: time.Hour}, provider, &mockConnector{})
+
+	post := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST",
+			"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org", nil)
+		r.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+	post()
+	rr := post()
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("ServeHTTP() - unexpected response: %d %v", rr.Code, rr.Header())
+	}
+	var got RebootResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("cannot unmarshal response: %v", err)
+	}
+	if got.Status != ResponseRefused || got.Code != CodeRateLimited {
+		t.Errorf("unexpected response: %+v", got)
+	}
+}
@@ -0,0 +1,114 @@
+package reboot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxRetryAttempts is the maximum number of attempts a request can ask for.
+const maxRetryAttempts = 10
+
+// Stages at which attempts are made.
+const (
+	stageConnect = "connect"
+	stageCommand = "command"
+)
+
+var metricAttempts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "reboot_attempts_total",
+		Help: "Total number of attempts at connecting to nodes and BMCs and at running commands",
+	},
+	[]string{
+		"stage",
+		"status",
+	},
+)
+
+type retryAttemptsKey struct{}
+
+// withRetryAttempts returns a context in which connections and commands are
+// attempted up to attempts times, overriding the configured policy.
+func withRetryAttempts(ctx context.Context, attempts int) context.Context {
+	return context.WithValue(ctx, retryAttemptsKey{}, attempts)
+}
+
+// retryPolicy returns the retry policy for operations running in ctx.
+func (h *Handler) retryPolicy(ctx context.Context) connector.RetryPolicy {
+	policy := h.config.Retry
+	if attempts, ok := ctx.Value(retryAttemptsKey{}).(int); ok {
+		policy.Attempts = attempts
+	}
+	return policy
+}
+
+// attemptsParam returns the number of attempts requested by r, or zero if
+// r doesn't override the configured policy.
+func attemptsParam(r *http.Request) (int, error) {
+	attempts, err := intParam(r, "attempts", 0)
+	if err == nil && r.URL.Query().Get("attempts") != "" &&
+		(attempts < 1 || attempts > maxRetryAttempts) {
+		err = fmt.Errorf("URL parameter 'attempts' must be between 1 and %d",
+			maxRetryAttempts)
+	}
+	return attempts, err
+}
+
+// attempt runs f with the retry policy of ctx, retrying when retryable
+// returns true. Every attempt is counted in metricAttempts.
+func (h *Handler) attempt(ctx context.Context, stage, what string,
+	retryable func(error) bool, f func() error) error {
+
+	policy := h.retryPolicy(ctx)
+	return policy.Retry(ctx, retryable, func(attempt int) error {
+		err := f()
+		switch {
+		case err == nil:
+			metricAttempts.WithLabelValues(stage, metricStatus(ctx, "ok")).Inc()
+		case retryable(err):
+			metricAttempts.WithLabelValues(stage, metricStatus(ctx, "error-transient")).Inc()
+			if attempt < policy.Attempts {
+				log.WithError(err).Warnf("Attempt %d/%d at %s failed, retrying",
+					attempt, policy.Attempts, what)
+			}
+		default:
+			metricAttempts.WithLabelValues(stage, metricStatus(ctx, "error")).Inc()
+		}
+		return err
+	})
+}
+
+// connect creates a connection with config, retrying transient failures.
+func (h *Handler) connect(ctx context.Context,
+	config *connector.ConnectionConfig) (connector.Connection, error) {
+
+	var conn connector.Connection
+	what := fmt.Sprintf("connecting to %s:%d", config.Hostname, config.Port)
+	err := h.attempt(ctx, stageConnect, what, connector.IsTransientConnectError,
+		func() error {
+			var err error
+			conn, err = connector.NewConnectionContext(ctx, h.connector, config)
+			return err
+		})
+	return conn, err
+}
+
+// run runs a command with f, retrying if it could not be delivered.
+func (h *Handler) run(ctx context.Context, what string,
+	f func() (string, error)) (string, error) {
+
+	var output string
+	err := h.attempt(ctx, stageCommand, what, connector.IsTransientCommandError,
+		func() error {
+			var err error
+			output, err = f()
+			return err
+		})
+	return output, err
+}
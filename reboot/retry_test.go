@@ -0,0 +1,171 @@
+package reboot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// flakyConnector fails to connect with connErr, and then to reboot with
+// rebootErr, a given number of times.
+type flakyConnector struct {
+	connFailures   int
+	connErr        error
+	rebootFailures int
+	rebootErr      error
+
+	connects, reboots int
+}
+
+func (c *flakyConnector) NewConnection(*connector.ConnectionConfig) (connector.Connection, error) {
+	c.connects++
+	if c.connects <= c.connFailures {
+		return nil, c.connErr
+	}
+	return &flakyConnection{mockConnection: mockConnection{}, c: c}, nil
+}
+
+type flakyConnection struct {
+	mockConnection
+	c *flakyConnector
+}
+
+func (conn *flakyConnection) Reboot() (string, error) {
+	conn.c.reboots++
+	if conn.c.reboots <= conn.c.rebootFailures {
+		return "", conn.c.rebootErr
+	}
+	return conn.mockConnection.Reboot()
+}
+
+func Test_attemptsParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{query: "", want: 0},
+		{query: "attempts=1", want: 1},
+		{query: "attempts=10", want: 10},
+		{query: "attempts=0", wantErr: true},
+		{query: "attempts=11", wantErr: true},
+		{query: "attempts=-1", wantErr: true},
+		{query: "attempts=many", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/v1/reboot?"+tt.query, nil)
+		got, err := attemptsParam(r)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("attemptsParam(%q) = %d, %v", tt.query, got, err)
+		}
+	}
+}
+
+func TestHandler_retries(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	node, err := host.Parse("mlab1-abc0t.mlab-sandbox.measurement-lab.org")
+	if err != nil {
+		t.Fatalf("cannot parse hostname: %v", err)
+	}
+	config := &Config{
+		Retry: connector.RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+	}
+	refused := fmt.Errorf("%w: dial", connector.ErrConnectionRefused)
+	session := fmt.Errorf("%w: EOF", connector.ErrSession)
+	tests := []struct {
+		name     string
+		c        *flakyConnector
+		ctx      context.Context
+		wantErr  bool
+		connects int
+		reboots  int
+	}{
+		{
+			name:     "connect-retried",
+			c:        &flakyConnector{connFailures: 2, connErr: refused},
+			connects: 3,
+			reboots:  1,
+		},
+		{
+			name:     "connect-exhausted",
+			c:        &flakyConnector{connFailures: 3, connErr: refused},
+			wantErr:  true,
+			connects: 3,
+		},
+		{
+			name:     "connect-permanent",
+			c:        &flakyConnector{connFailures: 1, connErr: connector.ErrAuthRejected},
+			wantErr:  true,
+			connects: 1,
+		},
+		{
+			name:     "session-retried",
+			c:        &flakyConnector{rebootFailures: 2, rebootErr: session},
+			connects: 1,
+			reboots:  3,
+		},
+		{
+			// The command may have been delivered: it must not be run twice.
+			name: "command-not-retried",
+			c: &flakyConnector{rebootFailures: 1, rebootErr: &connector.CommandError{
+				Command: "racadm serveraction powercycle", Err: io.EOF,
+			}},
+			wantErr:  true,
+			connects: 1,
+			reboots:  1,
+		},
+		{
+			name:     "request-override",
+			c:        &flakyConnector{connFailures: 1, connErr: refused},
+			ctx:      withRetryAttempts(context.Background(), 1),
+			wantErr:  true,
+			connects: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(config, provider, tt.c)
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			_, err := h.rebootBMC(ctx, node)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rebootBMC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.c.connects != tt.connects || tt.c.reboots != tt.reboots {
+				t.Errorf("rebootBMC() made %d connections and %d reboots, want %d and %d",
+					tt.c.connects, tt.c.reboots, tt.connects, tt.reboots)
+			}
+		})
+	}
+
+	// The number of attempts can be set per request.
+	c := &flakyConnector{connFailures: 2, connErr: refused}
+	h := NewHandler(&Config{}, provider, c)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&attempts=3", nil))
+	if rr.Code != http.StatusOK || c.connects != 3 {
+		t.Errorf("ServeHTTP() = %d after %d connections", rr.Code, c.connects)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1-abc0t.mlab-sandbox.measurement-lab.org&attempts=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
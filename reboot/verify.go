@@ -0,0 +1,324 @@
+package reboot
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultVerifyInterval    = 10 * time.Second
+	defaultVerifyDownTimeout = 5 * time.Minute
+	defaultVerifyUpTimeout   = 15 * time.Minute
+
+	// sshProbeTimeout is the timeout for connecting to a node and reading
+	// its SSH banner.
+	sshProbeTimeout = 5 * time.Second
+)
+
+// Verification checks that can be requested with the verify parameter.
+const (
+	// CheckSSH waits for the node's SSH banner to go away and come back.
+	CheckSSH = "ssh"
+	// CheckPower waits for the BMC to report the node as powered on.
+	CheckPower = "power"
+)
+
+// VerifyStatus is the outcome of a post-reboot verification.
+type VerifyStatus string
+
+// Possible verification outcomes.
+const (
+	VerifyRecovered VerifyStatus = "recovered"
+	VerifyNotDown   VerifyStatus = "not_down"
+	VerifyNotUp     VerifyStatus = "not_up"
+	VerifyError     VerifyStatus = "error"
+)
+
+var errVerifyTimeout = errors.New("timed out")
+
+var (
+	metricVerified = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "reboot_verified_total",
+			Help: "Total number of verified reboots, by outcome",
+		},
+		[]string{
+			"method",
+			"status",
+		},
+	)
+	metricRecoveryTimeHist = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "reboot_recovery_duration_seconds",
+		Help:    "Time between a reboot and the node coming back, in seconds",
+		Buckets: []float64{30, 60, 120, 180, 300, 450, 600, 900},
+	},
+		[]string{
+			"method",
+		},
+	)
+)
+
+// Verification is the result of checking that a node went down and came
+// back after a reboot.
+type Verification struct {
+	Checks []string     `json:"checks"`
+	Status VerifyStatus `json:"status"`
+
+	WentDown *time.Time `json:"went_down,omitempty"`
+	CameBack *time.Time `json:"came_back,omitempty"`
+
+	// RecoverySeconds is the time between the reboot being issued and the
+	// node coming back.
+	RecoverySeconds float64 `json:"recovery_seconds,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ParseChecks parses the value of the verify parameter. "true" is the same
+// as "ssh", while "false" and the empty string disable verification.
+// Otherwise, value is a comma-separated list of checks.
+func ParseChecks(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		if !b {
+			return nil, nil
+		}
+		return []string{CheckSSH}, nil
+	}
+
+	var checks []string
+	for _, c := range strings.Split(value, ",") {
+		c = strings.TrimSpace(c)
+		if c != CheckSSH && c != CheckPower {
+			return nil, fmt.Errorf("unknown check: %q", c)
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// check is a way of telling whether a node is up.
+type check struct {
+	name string
+	// mustGoDown is whether the node must be seen down by this check before
+	// it is considered back.
+	mustGoDown bool
+	// up reports whether the node is up. Errors abort the verification.
+	up func(ctx context.Context) (bool, error)
+}
+
+// hostNode returns the name of node's host, i.e. without the BMC suffix.
+func hostNode(node host.Name) host.Name {
+	node.Machine = strings.TrimSuffix(node.Machine, "d")
+	return node
+}
+
+// sshBannerUp reports whether an SSH server is sending its banner at addr.
+func sshBannerUp(ctx context.Context, addr string) bool {
+	dialer := &net.Dialer{Timeout: sshProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(sshProbeTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	return err == nil && strings.HasPrefix(line, "SSH-")
+}
+
+// bmcPowerOn reports whether the BMC of node reports the system as on. A
+// BMC that cannot be reached counts as off.
+func (h *Handler) bmcPowerOn(ctx context.Context, node host.Name) (bool, error) {
+	config, err := h.bmcConnectionConfig(ctx, bmcNode(node))
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := connector.NewConnectionContext(ctx, h.connector, config)
+	if err != nil {
+		if errors.Is(err, connector.ErrUnknownModel) {
+			return false, err
+		}
+		log.WithError(err).Debugf("Cannot connect to BMC: %s", config.Hostname)
+		return false, nil
+	}
+	defer conn.Close()
+
+	status, err := conn.PowerStatus()
+	if err != nil {
+		if errors.Is(err, connector.ErrNotSupported) {
+			return false, err
+		}
+		log.WithError(err).Debugf("Cannot read power status: %s", config.Hostname)
+		return false, nil
+	}
+	return status == connector.PowerStatusOn, nil
+}
+
+// checks returns the checks with the given names for node.
+func (h *Handler) checks(node host.Name, names []string) []check {
+	var checks []check
+	for _, name := range names {
+		switch name {
+		case CheckSSH:
+			addr := net.JoinHostPort(hostNode(node).String(),
+				strconv.Itoa(int(h.config.SSHPort)))
+			checks = append(checks, check{
+				name:       name,
+				mustGoDown: true,
+				up: func(ctx context.Context) (bool, error) {
+					return h.sshUp(ctx, addr), nil
+				},
+			})
+		case CheckPower:
+			checks = append(checks, check{
+				name: name,
+				up: func(ctx context.Context) (bool, error) {
+					return h.bmcPowerOn(ctx, node)
+				},
+			})
+		}
+	}
+	return checks
+}
+
+// poll calls cond every interval until it returns true, an error, or the
+// timeout expires.
+func poll(ctx context.Context, interval, timeout time.Duration,
+	cond func(context.Context) (bool, error)) (time.Time, error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := cond(ctx)
+		if err != nil {
+			return time.Time{}, err
+		}
+		now := time.Now()
+		if ok {
+			return now, nil
+		}
+		if now.After(deadline) {
+			return time.Time{}, errVerifyTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// durationOrDefault returns d, or def if d is not positive.
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// verify waits for node to go down and come back after a reboot issued at
+// start, according to checks.
+func (h *Handler) verify(ctx context.Context, start time.Time, checks []check) *Verification {
+	interval := durationOrDefault(h.config.VerifyInterval, defaultVerifyInterval)
+	downTimeout := durationOrDefault(h.config.VerifyDownTimeout, defaultVerifyDownTimeout)
+	upTimeout := durationOrDefault(h.config.VerifyUpTimeout, defaultVerifyUpTimeout)
+
+	v := &Verification{}
+	for _, c := range checks {
+		v.Checks = append(v.Checks, c.name)
+	}
+
+	// The node is down when every check that must see it go down reports
+	// it as down.
+	var downChecks []check
+	for _, c := range checks {
+		if c.mustGoDown {
+			downChecks = append(downChecks, c)
+		}
+	}
+	if len(downChecks) > 0 {
+		wentDown, err := poll(ctx, interval, downTimeout, func(ctx context.Context) (bool, error) {
+			for _, c := range downChecks {
+				up, err := c.up(ctx)
+				if err != nil || up {
+					return false, err
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return v.fail(VerifyNotDown, err, downTimeout)
+		}
+		v.WentDown = &wentDown
+	}
+
+	// The node is back when every check reports it as up.
+	cameBack, err := poll(ctx, interval, upTimeout, func(ctx context.Context) (bool, error) {
+		for _, c := range checks {
+			up, err := c.up(ctx)
+			if err != nil || !up {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return v.fail(VerifyNotUp, err, upTimeout)
+	}
+
+	v.CameBack = &cameBack
+	v.RecoverySeconds = cameBack.Sub(start).Seconds()
+	v.Status = VerifyRecovered
+	return v
+}
+
+// fail records a failed verification. Timeouts are reported with status,
+// other errors with VerifyError.
+func (v *Verification) fail(status VerifyStatus, err error, timeout time.Duration) *Verification {
+	if err == errVerifyTimeout {
+		v.Status = status
+		if status == VerifyNotDown {
+			v.Error = fmt.Sprintf("node did not go down within %v", timeout)
+		} else {
+			v.Error = fmt.Sprintf("node did not come back within %v", timeout)
+		}
+		return v
+	}
+	v.Status = VerifyError
+	v.Error = err.Error()
+	return v
+}
+
+// verifyJob verifies that node came back after a reboot issued at start,
+// recording the outcome in job.
+func (h *Handler) verifyJob(ctx context.Context, job *Job, node host.Name,
+	start time.Time, checkNames []string) error {
+
+	v := h.verify(ctx, start, h.checks(node, checkNames))
+	job.Verification = v
+	metricVerified.WithLabelValues(job.Method, string(v.Status)).Inc()
+	if v.Status != VerifyRecovered {
+		log.Warnf("Reboot of %s could not be verified: %s", node.String(), v.Error)
+		return fmt.Errorf("verification failed: %s", v.Error)
+	}
+
+	metricRecoveryTimeHist.WithLabelValues(job.Method).Observe(v.RecoverySeconds)
+	log.Infof("%s came back after %.0f seconds", node.String(), v.RecoverySeconds)
+	return nil
+}
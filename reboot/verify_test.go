@@ -0,0 +1,325 @@
+package reboot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/host"
+	"github.com/m-lab/reboot-service/connector"
+	"github.com/m-lab/reboot-service/creds"
+	"github.com/m-lab/reboot-service/creds/credstest"
+)
+
+// powerConnector returns connections that report a fixed power status.
+type powerConnector struct {
+	state string
+	err   error
+}
+
+type powerConnection struct {
+	mockConnection
+	state string
+}
+
+func (c *powerConnector) NewConnection(*connector.ConnectionConfig) (connector.Connection, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &powerConnection{state: c.state}, nil
+}
+
+func (c *powerConnection) PowerStatus() (string, error) {
+	switch c.state {
+	case "":
+		return "", errors.New("method PowerStatus() failed")
+	case "unsupported":
+		return "", connector.ErrNotSupported
+	}
+	return c.state, nil
+}
+
+func TestParseChecks(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "false", want: nil},
+		{value: "true", want: []string{CheckSSH}},
+		{value: "ssh,power", want: []string{CheckSSH, CheckPower}},
+		{value: "power", want: []string{CheckPower}},
+		{value: "ping", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseChecks(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseChecks(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseChecks(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func Test_sshBannerUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() returned err: %v", err)
+	}
+	banner := make(chan string, 2)
+	banner <- "SSH-2.0-OpenSSH_7.4\r\n"
+	banner <- "HTTP/1.1 400 Bad Request\r\n"
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(<-banner))
+			conn.Close()
+		}
+	}()
+
+	addr := l.Addr().String()
+	if !sshBannerUp(context.Background(), addr) {
+		t.Errorf("sshBannerUp() = false, want true")
+	}
+	if sshBannerUp(context.Background(), addr) {
+		t.Errorf("sshBannerUp() = true for a non-SSH server")
+	}
+	l.Close()
+	if sshBannerUp(context.Background(), addr) {
+		t.Errorf("sshBannerUp() = true for a closed port")
+	}
+}
+
+// sequence returns a check's up func returning the given states in order,
+// repeating the last one.
+func sequence(states ...bool) func(context.Context) (bool, error) {
+	i := 0
+	return func(context.Context) (bool, error) {
+		s := states[i]
+		if i < len(states)-1 {
+			i++
+		}
+		return s, nil
+	}
+}
+
+func TestHandler_verify(t *testing.T) {
+	h := NewHandler(&Config{
+		VerifyInterval:    time.Millisecond,
+		VerifyDownTimeout: 50 * time.Millisecond,
+		VerifyUpTimeout:   50 * time.Millisecond,
+	}, credstest.NewProvider(), &mockConnector{})
+
+	tests := []struct {
+		name   string
+		checks []check
+		want   VerifyStatus
+	}{
+		{
+			name: "recovered",
+			checks: []check{
+				{name: CheckSSH, mustGoDown: true, up: sequence(true, false, false, true)},
+				{name: CheckPower, up: sequence(true)},
+			},
+			want: VerifyRecovered,
+		},
+		{
+			name: "not-down",
+			checks: []check{
+				{name: CheckSSH, mustGoDown: true, up: sequence(true)},
+			},
+			want: VerifyNotDown,
+		},
+		{
+			name: "not-up",
+			checks: []check{
+				{name: CheckSSH, mustGoDown: true, up: sequence(false)},
+			},
+			want: VerifyNotUp,
+		},
+		{
+			name: "power-only",
+			checks: []check{
+				{name: CheckPower, up: sequence(false, true)},
+			},
+			want: VerifyRecovered,
+		},
+		{
+			name: "error",
+			checks: []check{
+				{name: CheckPower, up: func(context.Context) (bool, error) {
+					return false, connector.ErrNotSupported
+				}},
+			},
+			want: VerifyError,
+		},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		v := h.verify(context.Background(), start, tt.checks)
+		if v.Status != tt.want {
+			t.Errorf("verify() %s = %s (%s), want %s", tt.name, v.Status,
+				v.Error, tt.want)
+		}
+		if v.Status == VerifyRecovered && (v.CameBack == nil || v.RecoverySeconds <= 0) {
+			t.Errorf("verify() %s returned no recovery time: %+v", tt.name, v)
+		}
+		if v.Status != VerifyRecovered && v.Error == "" {
+			t.Errorf("verify() %s returned no error", tt.name)
+		}
+	}
+
+	// Verification stops when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v := h.verify(ctx, time.Now(), []check{
+		{name: CheckSSH, mustGoDown: true, up: sequence(true)},
+	})
+	if v.Status != VerifyError {
+		t.Errorf("verify() with canceled context = %s, want %s", v.Status, VerifyError)
+	}
+}
+
+func TestHandler_bmcPowerOn(t *testing.T) {
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	node, err := host.Parse("mlab1-abc0t.mlab-sandbox.measurement-lab.org")
+	if err != nil {
+		t.Fatalf("host.Parse() returned err: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		connector connector.Connector
+		want      bool
+		wantErr   bool
+	}{
+		{name: "on", connector: &powerConnector{state: connector.PowerStatusOn}, want: true},
+		{name: "off", connector: &powerConnector{state: connector.PowerStatusOff}},
+		{name: "unreachable", connector: &powerConnector{err: errors.New("timeout")}},
+		{name: "power-status-error", connector: &powerConnector{}},
+		{name: "unsupported", connector: &powerConnector{state: "unsupported"}, wantErr: true},
+		{
+			name:      "unknown-model",
+			connector: &powerConnector{err: connector.ErrUnknownModel},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		h := NewHandler(&Config{}, provider, tt.connector)
+		got, err := h.bmcPowerOn(context.Background(), node)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("bmcPowerOn() %s error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("bmcPowerOn() %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Nodes without credentials cannot be checked.
+	h := NewHandler(&Config{}, provider, &powerConnector{state: connector.PowerStatusOn})
+	node.Site = "abc1t"
+	if _, err := h.bmcPowerOn(context.Background(), node); err == nil {
+		t.Errorf("bmcPowerOn() expected err, got nil.")
+	}
+}
+
+func TestHandler_verifyJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := credstest.NewProvider()
+	provider.AddCredentials(context.Background(),
+		"mlab1d-abc0t.mlab-sandbox.measurement-lab.org", &creds.Credentials{
+			Address: "testaddr",
+		})
+	h := NewHandler(&Config{
+		VerifyInterval:  time.Millisecond,
+		VerifyUpTimeout: 50 * time.Millisecond,
+	}, provider, &powerConnector{state: connector.PowerStatusOff})
+	store := NewMemoryJobStore(time.Hour)
+	h.StartJobs(ctx, store, 1, 1)
+
+	// Verification is only supported for asynchronous reboots.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=false&verify=power", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true&verify=ping", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("ServeHTTP() - expected %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	// The BMC never reports the node as on.
+	node, _ := host.Parse("mlab1d-abc0t.mlab-sandbox.measurement-lab.org")
+	job := &Job{ID: "test", Method: "bmc"}
+	err := h.verifyJob(ctx, job, node, time.Now(), []string{CheckPower})
+	if err == nil {
+		t.Errorf("verifyJob() expected err, got nil.")
+	}
+	if job.Verification == nil || job.Verification.Status != VerifyNotUp {
+		t.Errorf("unexpected verification: %+v", job.Verification)
+	}
+
+	// The node comes back.
+	h.connector = &powerConnector{state: connector.PowerStatusOn}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true&verify=power", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("ServeHTTP() - expected %d, got %d", http.StatusAccepted, rr.Code)
+	}
+	json.Unmarshal(rr.Body.Bytes(), job)
+	job = waitForJob(t, store, job.ID)
+	if job.Status != JobSucceeded || job.Output != "Server power operation successful" {
+		t.Errorf("unexpected job: %+v", job)
+	}
+	if job.Verification == nil || job.Verification.Status != VerifyRecovered {
+		t.Errorf("unexpected verification: %+v", job.Verification)
+	}
+
+	// If the reboot fails, there is nothing to verify.
+	h.connector = &powerConnector{err: errors.New("connection refused")}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST",
+		"/v1/reboot?host=mlab1d-abc0t.mlab-sandbox.measurement-lab.org&async=true&verify=power", nil))
+	json.Unmarshal(rr.Body.Bytes(), job)
+	job = waitForJob(t, store, job.ID)
+	if job.Status != JobFailed || job.Verification != nil {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
+
+func TestHandler_checks(t *testing.T) {
+	h := NewHandler(&Config{SSHPort: 22}, credstest.NewProvider(), &mockConnector{})
+	node, _ := host.Parse("mlab1d-abc0t.mlab-sandbox.measurement-lab.org")
+
+	checks := h.checks(node, []string{CheckSSH, CheckPower})
+	if len(checks) != 2 || checks[0].name != CheckSSH || checks[1].name != CheckPower {
+		t.Fatalf("unexpected checks: %+v", checks)
+	}
+	if !checks[0].mustGoDown || checks[1].mustGoDown {
+		t.Errorf("only the SSH check must see the node go down")
+	}
+	if got := hostNode(node).Machine; got != "mlab1" {
+		t.Errorf("hostNode() = %s, want mlab1", got)
+	}
+}